@@ -3,6 +3,7 @@ package main
 import (
 	"encoding/json"
 	"fmt"
+	"strconv"
 	"time"
 
 	"github.com/notnil/chess"
@@ -13,6 +14,15 @@ type analysis struct {
 	Moves         map[string]interface{} `json:"moves"`
 	WhiteAccuracy float64                `json:"white_accuracy"`
 	BlackAccuracy float64                `json:"black_accuracy"`
+	Engine        *analysisEngine        `json:"engine,omitempty"` // absent from analyses stored before it was recorded
+	Evaluated     bool                   `json:"evaluated"`        // every move has an eval and a classification, see markEvaluatedAnalysis
+}
+
+// the engine and search settings an analysis was made with
+type analysisEngine struct {
+	Name     string   `json:"name"` // as the engine names itself
+	MoveTime duration `json:"movetime"`
+	Depth    int      `json:"depth"`
 }
 
 type player struct {
@@ -31,8 +41,98 @@ func NewPlayer(uuid string, username string, rating float64) (p player) {
 	return p
 }
 
+// the tags of a game's PGN: the seven tag roster, then the extras chess.com and Lichess add
+type pgnHeaders struct {
+	Event           string            `json:"event"`
+	Site            string            `json:"site"`
+	Date            string            `json:"date"` // YYYY.MM.DD, with ?? where unknown
+	Round           string            `json:"round"`
+	White           string            `json:"white"`
+	Black           string            `json:"black"`
+	Result          string            `json:"result"` // 1-0 / 0-1 / 1/2-1/2 / *
+	WhiteElo        int               `json:"white_elo,omitempty"`
+	BlackElo        int               `json:"black_elo,omitempty"`
+	TimeControl     string            `json:"time_control,omitempty"`
+	ECO             string            `json:"eco,omitempty"`
+	ECOUrl          string            `json:"eco_url,omitempty"`
+	Opening         string            `json:"opening,omitempty"` // the Opening tag, or the name in ECOUrl
+	Termination     string            `json:"termination,omitempty"`
+	StartTime       *time.Time        `json:"start_time,omitempty"`
+	EndTime         *time.Time        `json:"end_time,omitempty"`
+	Timezone        string            `json:"timezone,omitempty"`
+	CurrentPosition string            `json:"current_position,omitempty"` // FEN
+	Link            string            `json:"link,omitempty"`
+	Tags            map[string]string `json:"tags"` // every tag, as written
+}
+
+// pgnHeaders creator function
+func NewPGNHeaders(tags []*chess.TagPair) (h pgnHeaders) {
+	h.Tags = make(map[string]string)
+	for _, tp := range tags {
+		h.Tags[tp.Key] = tp.Value
+	}
+	tag := func(name string) string {
+		return h.Tags[name]
+	}
+
+	h.Event = tag("Event")
+	h.Site = tag("Site")
+	h.Date = tag("Date")
+	h.Round = tag("Round")
+	h.White = tag("White")
+	h.Black = tag("Black")
+	h.Result = tag("Result")
+	h.WhiteElo, _ = strconv.Atoi(tag("WhiteElo"))
+	h.BlackElo, _ = strconv.Atoi(tag("BlackElo"))
+	h.TimeControl = tag("TimeControl")
+	h.ECO = tag("ECO")
+	h.ECOUrl = tag("ECOUrl")
+	h.Opening = tag("Opening")
+	if h.Opening == "" {
+		h.Opening = openingFromECOURL(h.ECOUrl)
+	}
+	h.Termination = tag("Termination")
+	h.Timezone = tag("Timezone")
+	h.CurrentPosition = tag("CurrentPosition")
+	h.Link = tag("Link")
+
+	// chess.com writes the start and end in Timezone, Lichess the start in UTC
+	loc, err := time.LoadLocation(h.Timezone)
+	if err != nil {
+		loc = time.UTC
+	}
+	if t, ok := pgnDateTime(tag("UTCDate"), tag("UTCTime"), time.UTC); ok {
+		h.StartTime = &t
+	} else if t, ok := pgnDateTime(tag("Date"), tag("StartTime"), loc); ok {
+		h.StartTime = &t
+	}
+	if t, ok := pgnDateTime(tag("EndDate"), tag("EndTime"), loc); ok {
+		h.EndTime = &t
+	}
+
+	return h
+}
+
+// a PGN date and time, e.g. "2025.02.03" and "10:00:00"
+func pgnDateTime(date string, clock string, loc *time.Location) (t time.Time, ok bool) {
+	t, err := time.ParseInLocation("2006.01.02 15:04:05", date+" "+clock, loc)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return t.UTC(), true
+}
+
+// chess.com's own accuracies, present once the game has been reviewed on chess.com
+type chessComAccuracies struct {
+	White float64 `json:"white"`
+	Black float64 `json:"black"`
+}
+
 type result struct {
 	UUID        string               `json:"uuid"`
+	Source      string               `json:"source"`  // chess.com / lichess
+	Variant     string               `json:"variant"` // chess / chess960
+	StartFEN    string               `json:"start_fen"`
 	Date        time.Time            `json:"date"`
 	TimeClass   string               `json:"time_class"`
 	TimeControl string               `json:"time_control"`
@@ -45,7 +145,17 @@ type result struct {
 	MoveCount   int                  `json:"move_count"`
 	Winner      chess.Color          `json:"winner"` // White / Black / NoColor(draw)
 	Outcome     string               `json:"outcome"`
+	Headers     pgnHeaders           `json:"headers"`
 	Analysis    analysis             `json:"analysis"`
+
+	// chess.com game JSON
+	Accuracies   *chessComAccuracies `json:"accuracies,omitempty"`
+	TCN          string              `json:"tcn,omitempty"` // chess.com's compact move encoding
+	InitialSetup string              `json:"initial_setup,omitempty"`
+	Rules        string              `json:"rules"`
+	FEN          string              `json:"fen,omitempty"` // the final position
+
+	plies []gamePly // the moves under the rules of the variant, for analysis
 }
 
 // result creator function
@@ -73,23 +183,46 @@ func NewResult(gameData map[string]interface{}) (r result, err error) {
 		outcome = whiteMap["result"].(string)
 	}
 
-	moveHistory, err := pgnToMoveHistory(gameData["pgn"].(string))
+	variant, err := gameVariant(gameData)
 	if err != nil {
-		err = fmt.Errorf("pgnToMoveHistory: %w", err)
+		err = fmt.Errorf("gameVariant: %w", err)
 		return result{}, WrapError(err)
 	}
+	initialSetup, _ := gameData["initial_setup"].(string)
+	start, plies, err := replayPGN(gameData["pgn"].(string), variant, initialSetup)
+	if err != nil {
+		err = fmt.Errorf("replayPGN: %w", err)
+		return result{}, WrapError(err)
+	}
+
+	tags, _, _ := parsePGN(gameData["pgn"].(string))
+
+	var accuracies *chessComAccuracies
+	if accuraciesMap, ok := gameData["accuracies"].(map[string]interface{}); ok {
+		accuracies = &chessComAccuracies{}
+		accuracies.White, _ = accuraciesMap["white"].(float64)
+		accuracies.Black, _ = accuraciesMap["black"].(float64)
+	}
+	rules, _ := gameData["rules"].(string)
+	if rules == "" {
+		rules = variantChess
+	}
+	tcn, _ := gameData["tcn"].(string)
+	fen, _ := gameData["fen"].(string)
 
 	// calculate moveCount from plies (half-moves)
-	plies := len(moveHistory)
 	moveCount := 0
-	if plies%2 == 0 {
-		moveCount = plies / 2
+	if len(plies)%2 == 0 {
+		moveCount = len(plies) / 2
 	} else {
-		moveCount = (plies + 1) / 2
+		moveCount = (len(plies) + 1) / 2
 	}
 
 	r = result{
 		UUID:        gameData["uuid"].(string),
+		Source:      gameSourceName(gameData),
+		Variant:     variant,
+		StartFEN:    start.fen(),
 		Date:        epochToTime(gameData["end_time"].(float64)),
 		TimeClass:   gameData["time_class"].(string),
 		TimeControl: gameData["time_control"].(string),
@@ -98,11 +231,20 @@ func NewResult(gameData map[string]interface{}) (r result, err error) {
 		PlayerWhite: NewPlayer(playerWhiteUUID, playerWhiteUsername, playerWhiteRating),
 		PlayerBlack: NewPlayer(playerBlackUUID, playerBlackUsername, playerBlackRating),
 		PGN:         gameData["pgn"].(string),
-		MoveHistory: moveHistory,
+		MoveHistory: pliesToMoveHistory(plies),
 		MoveCount:   moveCount,
 		Winner:      winner,
 		Outcome:     outcome,
+		Headers:     NewPGNHeaders(tags),
 		Analysis:    analysis{}, // populated by newAnalysis() on next line
+
+		Accuracies:   accuracies,
+		TCN:          tcn,
+		InitialSetup: initialSetup,
+		Rules:        rules,
+		FEN:          fen,
+
+		plies: plies,
 	}
 
 	// search the Analysis database for an existing analysis
@@ -120,6 +262,15 @@ func NewResult(gameData map[string]interface{}) (r result, err error) {
 			WhiteAccuracy: accuracyMap["white"].(float64),
 			BlackAccuracy: accuracyMap["black"].(float64),
 		}
+		r.Analysis.Evaluated, _ = existingAnalysis.(map[string]interface{})["evaluated"].(bool)
+		if engineMap, ok := existingAnalysis.(map[string]interface{})["engine"].(map[string]interface{}); ok {
+			r.Analysis.Engine = &analysisEngine{}
+			r.Analysis.Engine.Name, _ = engineMap["name"].(string)
+			moveTime, _ := engineMap["movetime"].(string)
+			r.Analysis.Engine.MoveTime.UnmarshalText([]byte(moveTime))
+			depth, _ := engineMap["depth"].(float64)
+			r.Analysis.Engine.Depth = int(depth)
+		}
 	}
 
 	return r, nil
@@ -137,7 +288,7 @@ func (r *result) analyzeGame() (err error) {
 	} else {
 		fmt.Println("Analyzing game:", r.UUID, "...")
 
-		r.Analysis, err = moveHistoryToAnalysis(r.MoveHistory)
+		r.Analysis, err = moveHistoryToAnalysis(r.plies)
 		if err != nil {
 			err = fmt.Errorf("moveHistoryToAnalysis: %w", err)
 			return WrapError(err)
@@ -150,6 +301,9 @@ func (r *result) analyzeGame() (err error) {
 		accuracyMap["white"] = r.Analysis.WhiteAccuracy
 		accuracyMap["black"] = r.Analysis.BlackAccuracy
 		analysisMap[r.UUID].(map[string]interface{})["accuracy"] = accuracyMap
+		analysisMap[r.UUID].(map[string]interface{})["variant"] = r.Variant
+		analysisMap[r.UUID].(map[string]interface{})["engine"] = r.Analysis.Engine
+		analysisMap[r.UUID].(map[string]interface{})["evaluated"] = r.Analysis.Evaluated
 
 		// create a database object
 		db, err := newDatabase("analysis", "")
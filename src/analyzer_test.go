@@ -0,0 +1,104 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNewResultHeaders(t *testing.T) {
+	appConfig.DataDir = t.TempDir()
+	defer func() { appConfig = defaultConfig() }()
+
+	ad := fixtureArchiveData(t, "asdf", "2025-02", "testdata/asdf_2025_02.json")
+	gameMap := ad.ArchiveData["2025-02"].([]interface{})[0].(map[string]interface{})
+	gameMap["accuracies"] = map[string]interface{}{"white": 83.4, "black": 61.2}
+
+	r, err := NewResult(gameMap)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h := r.Headers
+	expected := map[string]string{
+		"Event":           "Live Chess",
+		"Site":            "Chess.com",
+		"Date":            "2025.02.03",
+		"Round":           "-",
+		"White":           "asdf",
+		"Black":           "opponent1",
+		"Result":          "1-0",
+		"ECO":             "C20",
+		"ECOUrl":          "https://www.chess.com/openings/Kings-Pawn-Opening",
+		"Opening":         "Kings Pawn Opening",
+		"Termination":     "asdf won by checkmate",
+		"Timezone":        "UTC",
+		"CurrentPosition": "r1bqkb1r/pppp1Qpp/2n2n2/4p3/2B1P3/8/PPPP1PPP/RNB1K1NR b KQkq - 0 4",
+		"Link":            "https://www.chess.com/game/live/100000001",
+	}
+	got := map[string]string{
+		"Event":           h.Event,
+		"Site":            h.Site,
+		"Date":            h.Date,
+		"Round":           h.Round,
+		"White":           h.White,
+		"Black":           h.Black,
+		"Result":          h.Result,
+		"ECO":             h.ECO,
+		"ECOUrl":          h.ECOUrl,
+		"Opening":         h.Opening,
+		"Termination":     h.Termination,
+		"Timezone":        h.Timezone,
+		"CurrentPosition": h.CurrentPosition,
+		"Link":            h.Link,
+	}
+	for name, value := range expected {
+		if got[name] != value {
+			t.Errorf("%s: expected %v, got %v", name, value, got[name])
+		}
+	}
+	if h.WhiteElo != 1200 || h.BlackElo != 1250 {
+		t.Errorf("expected %v/%v, got %v/%v", 1200, 1250, h.WhiteElo, h.BlackElo)
+	}
+	start := time.Date(2025, 2, 3, 10, 0, 0, 0, time.UTC)
+	if h.StartTime == nil || !h.StartTime.Equal(start) || h.EndTime == nil || !h.EndTime.Equal(start) {
+		t.Errorf("expected %v, got %v and %v", start, h.StartTime, h.EndTime)
+	}
+	if h.Tags["UTCDate"] != "2025.02.03" {
+		t.Errorf("expected every tag, got %v", h.Tags)
+	}
+
+	if r.Accuracies == nil || r.Accuracies.White != 83.4 || r.Accuracies.Black != 61.2 {
+		t.Errorf("expected %v/%v, got %+v", 83.4, 61.2, r.Accuracies)
+	}
+	if r.TCN != "mC0KdN5QfA!TN1" || r.Rules != "chess" {
+		t.Errorf("expected the tcn and rules, got %q and %q", r.TCN, r.Rules)
+	}
+	if r.InitialSetup != standardFEN || r.FEN != h.CurrentPosition {
+		t.Errorf("expected %v and %v, got %v and %v", standardFEN, h.CurrentPosition, r.InitialSetup, r.FEN)
+	}
+
+	// accuracies are only there once chess.com has reviewed the game
+	delete(gameMap, "accuracies")
+	r, err = NewResult(gameMap)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Accuracies != nil {
+		t.Errorf("expected no accuracies, got %+v", r.Accuracies)
+	}
+}
+
+func TestNewPGNHeaders(t *testing.T) {
+	tags, _, _ := parsePGN("[Event \"Rated Blitz game\"]\n[Date \"2025.02.07\"]\n[UTCDate \"2025.02.07\"]\n[UTCTime \"10:00:00\"]\n[Opening \"King's Pawn Game\"]\n[WhiteElo \"?\"]\n\n1. e4 *\n")
+	h := NewPGNHeaders(tags)
+	if h.Opening != "King's Pawn Game" {
+		t.Errorf("expected %v, got %v", "King's Pawn Game", h.Opening)
+	}
+	if h.WhiteElo != 0 || h.EndTime != nil {
+		t.Errorf("expected no rating and no end, got %v and %v", h.WhiteElo, h.EndTime)
+	}
+	start := time.Date(2025, 2, 7, 10, 0, 0, 0, time.UTC)
+	if h.StartTime == nil || !h.StartTime.Equal(start) {
+		t.Errorf("expected %v, got %v", start, h.StartTime)
+	}
+}
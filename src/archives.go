@@ -9,19 +9,20 @@ import (
 
 // archiveList struct
 type archiveList struct {
+	Site        gameSource             `json:"-"`
 	Player      string                 `json:"player"`
 	URL         string                 `json:"url"`
 	ArchiveList map[string]interface{} `json:"archives"`
 	Present     map[string]bool        `json:"present"`
+	Unchanged   bool                   `json:"-"` // chess.com answered 304 Not Modified
 }
 
 // ArchiveList creator function
-func NewArchiveList(player string, source string) (al archiveList, err error) {
-	archiveListUrl := fmt.Sprintf("https://api.chess.com/pub/player/%s/games/archives", player)
-
+func NewArchiveList(site gameSource, player string, source string) (al archiveList, err error) {
 	al = archiveList{
+		Site:        site,
 		Player:      player,
-		URL:         archiveListUrl,
+		URL:         site.archiveListURL(player),
 		ArchiveList: make(map[string]interface{}), // this needs to be refreshable
 		//                                            it's therefore intialized blank
 		//                                            and updated via
@@ -35,7 +36,11 @@ func NewArchiveList(player string, source string) (al archiveList, err error) {
 	if source == "db" {
 		al.getArchiveListFromDB()
 	} else if source == "api" {
-		al.getArchiveListFromAPI()
+		err = al.getArchiveListFromAPI()
+		if err != nil {
+			err = fmt.Errorf("getArchiveListFromAPI: %w", err)
+			return archiveList{}, WrapError(err)
+		}
 	} else {
 		err = fmt.Errorf("NewArchiveList: invalid source")
 		return archiveList{}, WrapError(err)
@@ -49,7 +54,7 @@ func NewArchiveList(player string, source string) (al archiveList, err error) {
 // archiveList methods
 func (al *archiveList) getArchiveListFromDB() (err error) {
 	// read from the database
-	db, err := newDatabase("archive_list", al.Player)
+	db, err := newDatabase(al.Site.contentType("archive_list"), al.Player)
 	if err != nil {
 		err = fmt.Errorf("newDatabase: %w", err)
 		return WrapError(err)
@@ -62,27 +67,45 @@ func (al *archiveList) getArchiveListFromDB() (err error) {
 }
 
 func (al *archiveList) getArchiveListFromAPI() (err error) {
-	// read from the API and write it to the object
-	al.ArchiveList, err = queryAPI(al.URL)
+	db, err := newDatabase(al.Site.contentType("archive_list"), al.Player)
+	if err != nil {
+		err = fmt.Errorf("newDatabase: %w", err)
+		return WrapError(err)
+	}
+
+	// read from the API, only if it changed since the stored copy
+	_, haveCopy := db.Data["archives"]
+	apiData, modified, err := al.Site.fetchArchiveList(al.URL, haveCopy)
 	if err != nil {
-		err = fmt.Errorf("queryAPI: %w", err)
+		err = fmt.Errorf("al.Site.fetchArchiveList: %w", err)
+		return WrapError(err)
+	}
+	if !modified {
+		al.ArchiveList = db.Data
+		al.Unchanged = true
+		return nil
+	}
+
+	// write it to the object and the database
+	al.ArchiveList = apiData
+	err = db.writeData(al.ArchiveList)
+	if err != nil {
+		err = fmt.Errorf("db.writeData: %w", err)
 		return WrapError(err)
 	}
+
 	return nil
 }
 
 func (al *archiveList) getPresent() (err error) {
 	// read from the database
-	db, err := newDatabase("archive_data", al.Player)
+	db, err := newDatabase(al.Site.contentType("archive_data"), al.Player)
 	if err != nil {
 		err = fmt.Errorf("newDatabase: %w", err)
 		return WrapError(err)
 	}
 
 	data := make(map[string]bool)
-	yearStr := ""
-	monthStr := ""
-	keyStr := ""
 
 	// Check if the 'archives' key exists and is a slice of interfaces
 	archives, ok := al.ArchiveList["archives"].([]interface{})
@@ -92,19 +115,11 @@ func (al *archiveList) getPresent() (err error) {
 	}
 
 	for _, archive := range archives {
-		// Convert archive from interface{} to string
-		archiveDataUrl, ok := archive.(string)
-		if !ok {
-			err = fmt.Errorf("archive is not a string")
-			return WrapError(err)
-		}
-
-		_, yearStr, monthStr, err = extractFromArchiveURL(archiveDataUrl)
+		keyStr, err := al.Site.archiveKey(archive)
 		if err != nil {
-			err = fmt.Errorf("extractFromArchiveURL: %w", err)
+			err = fmt.Errorf("al.Site.archiveKey: %w", err)
 			return WrapError(err)
 		}
-		keyStr = fmt.Sprintf("%s-%s", yearStr, monthStr)
 
 		// Check if keyStr is a key within the db.Data map
 		_, exists := db.Data[keyStr]
@@ -147,6 +162,7 @@ func (al *archiveList) prettyPrint() (s string, err error) {
 
 // archiveData struct
 type archiveData struct {
+	Site        gameSource             `json:"-"`
 	Player      string                 `json:"player"`
 	Year        int                    `json:"year"`
 	Month       time.Month             `json:"month"`
@@ -154,10 +170,12 @@ type archiveData struct {
 	URL         string                 `json:"url"`
 	ArchiveData map[string]interface{} `json:"games"`
 	Present     map[string]bool        `json:"present"`
+	Unchanged   bool                   `json:"-"` // chess.com answered 304 Not Modified
+	Added       int                    `json:"-"` // games not stored before the refresh
 }
 
 // archiveData creator function
-func NewArchiveData(player string, year int, month time.Month, source string) (archiveData, error) {
+func NewArchiveData(site gameSource, player string, year int, month time.Month, source string) (archiveData, error) {
 	// convert month from time.Month to int
 	monthInt := int(month)
 
@@ -165,15 +183,13 @@ func NewArchiveData(player string, year int, month time.Month, source string) (a
 	// this is to be used as the top-level key, replacing the general 'games'
 	key := fmt.Sprintf("%d-%02d", year, monthInt)
 
-	// monthInt can now be used to construct the archiveDataUrl
-	archiveDataUrl := fmt.Sprintf("https://api.chess.com/pub/player/%s/games/%d/%02d", player, year, monthInt)
-
 	ad := archiveData{
+		Site:        site,
 		Player:      player,
 		Year:        year,
 		Month:       month,
 		Key:         key,
-		URL:         archiveDataUrl,
+		URL:         site.archiveDataURL(player, year, month),
 		ArchiveData: make(map[string]interface{}), // this needs to be refreshable
 		//                                            it's therefore intialized blank
 		//                                            and updated via
@@ -205,7 +221,7 @@ func NewArchiveData(player string, year int, month time.Month, source string) (a
 // archiveData methods
 func (ad *archiveData) getArchiveDataFromDB() (err error) {
 	// read from the database
-	db, err := newDatabase("archive_data", ad.Player)
+	db, err := newDatabase(ad.Site.contentType("archive_data"), ad.Player)
 	if err != nil {
 		err = fmt.Errorf("newDatabase: %w", err)
 		return WrapError(err)
@@ -221,32 +237,85 @@ func (ad *archiveData) getArchiveDataFromDB() (err error) {
 // Populate the ArchiveData field via an API call
 func (ad *archiveData) getArchiveDataFromAPI() (err error) {
 
-	// read from the API and write it to the object
-	apiData, err := queryAPI(ad.URL)
+	db, err := newDatabase(ad.Site.contentType("archive_data"), ad.Player)
+	if err != nil {
+		err = fmt.Errorf("newDatabase: %w", err)
+		return WrapError(err)
+	}
+	stored, haveCopy := db.Data[ad.Key]
+
+	// read from the API, only if it changed since the stored copy
+	games, modified, err := ad.Site.fetchArchiveData(ad.URL, haveCopy)
 	if err != nil {
-		err = fmt.Errorf("queryAPI: %w", err)
+		err = fmt.Errorf("ad.Site.fetchArchiveData: %w", err)
 		return WrapError(err)
 	}
+	if !modified {
+		ad.ArchiveData = map[string]interface{}{ad.Key: stored}
+		ad.Unchanged = true
+		return nil
+	}
+
+	// record where each game came from
+	gameList, _ := games.([]interface{})
+	for _, game := range gameList {
+		if gameMap, ok := game.(map[string]interface{}); ok {
+			gameMap["source"] = ad.Site.name()
+		}
+	}
+
+	// write it to the object
 	data := make(map[string]interface{})
-	data[ad.Key] = apiData["games"]
+	data[ad.Key] = games
 	ad.ArchiveData = data
+	ad.Added = countNewGames(stored, games)
 
 	// read from the object and write it to the database
-	db, err := newDatabase("archive_data", ad.Player)
+	err = db.writeData(ad.ArchiveData)
 	if err != nil {
-		err = fmt.Errorf("newDatabase: %w", err)
+		err = fmt.Errorf("db.writeData: %w", err)
 		return WrapError(err)
 	}
 
-	err = db.writeData(ad.ArchiveData)
+	// keep the game index in step with the stored games
+	err = updateGameIndex(*ad)
 	if err != nil {
-		err = fmt.Errorf("db.writeData: %w", err)
+		err = fmt.Errorf("updateGameIndex: %w", err)
+		return WrapError(err)
+	}
+	err = updateUUIDIndex(*ad)
+	if err != nil {
+		err = fmt.Errorf("updateUUIDIndex: %w", err)
 		return WrapError(err)
 	}
 
 	return nil
 }
 
+// counts the games in fetched whose uuid is not in stored
+func countNewGames(stored interface{}, fetched interface{}) (added int) {
+	uuids := make(map[string]bool)
+	storedGames, _ := stored.([]interface{})
+	for _, game := range storedGames {
+		if gameMap, ok := game.(map[string]interface{}); ok {
+			if uuid, ok := gameMap["uuid"].(string); ok {
+				uuids[uuid] = true
+			}
+		}
+	}
+
+	fetchedGames, _ := fetched.([]interface{})
+	for _, game := range fetchedGames {
+		if gameMap, ok := game.(map[string]interface{}); ok {
+			if uuid, ok := gameMap["uuid"].(string); ok && !uuids[uuid] {
+				added++
+			}
+		}
+	}
+
+	return added
+}
+
 func (ad *archiveData) getPresent() (err error) {
 	// read from the _analysis database
 	db, err := newDatabase("analysis", "")
@@ -286,15 +355,3 @@ func (ad *archiveData) getPresent() (err error) {
 
 	return nil
 }
-
-func (ad *archiveData) prettyPrint() (s string, err error) {
-	// Pretty-print the Present list as indented JSON
-	presentJSON, err := json.MarshalIndent(ad.Present, "", "  ")
-	if err != nil {
-		err = fmt.Errorf("json.MarshalIndent: %w", err)
-		return "", WrapError(err)
-	}
-
-	s = string(presentJSON)
-	return s, nil
-}
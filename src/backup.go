@@ -0,0 +1,393 @@
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// A backup is a tar.gz holding `manifest.json` followed by every table file.
+// The manifest lists each table with its size and sha256 checksum,
+// which are verified before anything is restored.
+
+const manifestName = "manifest.json"
+
+type backupFile struct {
+	Name          string `json:"name"`
+	Size          int64  `json:"size"`
+	SHA256        string `json:"sha256"`
+	SchemaVersion int    `json:"schema_version"`
+}
+
+type backupManifest struct {
+	Created       time.Time    `json:"created"`
+	SchemaVersion int          `json:"schema_version"`
+	Files         []backupFile `json:"files"`
+}
+
+// streams a tar.gz snapshot of every table to w
+func writeBackup(w io.Writer) (err error) {
+	snapshotDir, err := os.MkdirTemp(appConfig.DataDir, ".backup-")
+	if err != nil {
+		err = fmt.Errorf("os.MkdirTemp: %w", err)
+		return WrapError(err)
+	}
+	defer os.RemoveAll(snapshotDir)
+
+	tableNames, err := snapshotTables(snapshotDir)
+	if err != nil {
+		err = fmt.Errorf("snapshotTables: %w", err)
+		return WrapError(err)
+	}
+
+	manifest, err := newBackupManifest(snapshotDir, tableNames)
+	if err != nil {
+		err = fmt.Errorf("newBackupManifest: %w", err)
+		return WrapError(err)
+	}
+
+	manifestJSON, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		err = fmt.Errorf("json.MarshalIndent: %w", err)
+		return WrapError(err)
+	}
+
+	gw := gzip.NewWriter(w)
+	tw := tar.NewWriter(gw)
+
+	err = tw.WriteHeader(&tar.Header{
+		Name:    manifestName,
+		Mode:    0644,
+		Size:    int64(len(manifestJSON)),
+		ModTime: manifest.Created,
+	})
+	if err != nil {
+		err = fmt.Errorf("tw.WriteHeader: %w", err)
+		return WrapError(err)
+	}
+	_, err = tw.Write(manifestJSON)
+	if err != nil {
+		err = fmt.Errorf("tw.Write: %w", err)
+		return WrapError(err)
+	}
+
+	for _, f := range manifest.Files {
+		err = tw.WriteHeader(&tar.Header{
+			Name:    f.Name,
+			Mode:    0644,
+			Size:    f.Size,
+			ModTime: manifest.Created,
+		})
+		if err != nil {
+			err = fmt.Errorf("tw.WriteHeader: %w", err)
+			return WrapError(err)
+		}
+
+		file, err := os.Open(filepath.Join(snapshotDir, f.Name))
+		if err != nil {
+			err = fmt.Errorf("os.Open: %w", err)
+			return WrapError(err)
+		}
+		_, err = io.Copy(tw, file)
+		file.Close()
+		if err != nil {
+			err = fmt.Errorf("io.Copy: %w", err)
+			return WrapError(err)
+		}
+	}
+
+	err = tw.Close()
+	if err != nil {
+		err = fmt.Errorf("tw.Close: %w", err)
+		return WrapError(err)
+	}
+	err = gw.Close()
+	if err != nil {
+		err = fmt.Errorf("gw.Close: %w", err)
+		return WrapError(err)
+	}
+
+	return nil
+}
+
+// copies every table into snapshotDir, blocking writers only while copying,
+// not while the backup is streamed to a client that may be slow
+func snapshotTables(snapshotDir string) (tableNames []string, err error) {
+	tablesMu.RLock()
+	defer tablesMu.RUnlock()
+
+	tableNames, err = listTables()
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		err = fmt.Errorf("listTables: %w", err)
+		return nil, WrapError(err)
+	}
+
+	for _, tableName := range tableNames {
+		contents, err := os.ReadFile(filepath.Join(appConfig.DataDir, tableName))
+		if err != nil {
+			err = fmt.Errorf("os.ReadFile: %w", err)
+			return nil, WrapError(err)
+		}
+		err = os.WriteFile(filepath.Join(snapshotDir, tableName), contents, 0644)
+		if err != nil {
+			err = fmt.Errorf("os.WriteFile: %w", err)
+			return nil, WrapError(err)
+		}
+	}
+
+	return tableNames, nil
+}
+
+// checksums every table copied into snapshotDir
+func newBackupManifest(snapshotDir string, tableNames []string) (manifest backupManifest, err error) {
+	manifest = backupManifest{
+		Created:       time.Now().UTC(),
+		SchemaVersion: schemaVersion,
+		Files:         []backupFile{},
+	}
+
+	for _, tableName := range tableNames {
+		contents, err := os.ReadFile(filepath.Join(snapshotDir, tableName))
+		if err != nil {
+			err = fmt.Errorf("os.ReadFile: %w", err)
+			return backupManifest{}, WrapError(err)
+		}
+		sum := sha256.Sum256(contents)
+
+		raw := make(map[string]interface{})
+		err = json.Unmarshal(contents, &raw)
+		if err != nil {
+			err = fmt.Errorf("%s: json.Unmarshal: %w", tableName, err)
+			return backupManifest{}, WrapError(err)
+		}
+		version, _, err := decodeTable(raw)
+		if err != nil {
+			err = fmt.Errorf("%s: decodeTable: %w", tableName, err)
+			return backupManifest{}, WrapError(err)
+		}
+
+		manifest.Files = append(manifest.Files, backupFile{
+			Name:          tableName,
+			Size:          int64(len(contents)),
+			SHA256:        hex.EncodeToString(sum[:]),
+			SchemaVersion: version,
+		})
+	}
+
+	return manifest, nil
+}
+
+// validates a tar.gz backup from r and replaces every table with its contents
+// tables not present in the backup are removed, and if any table cannot be
+// restored the existing tables are left as they were
+func restoreBackup(r io.Reader) (restored int, err error) {
+	// stage the files next to the tables, so they can be renamed into place
+	err = os.MkdirAll(appConfig.DataDir, 0755)
+	if err != nil {
+		err = fmt.Errorf("os.MkdirAll: %w", err)
+		return 0, WrapError(err)
+	}
+	stagingDir, err := os.MkdirTemp(appConfig.DataDir, ".restore-")
+	if err != nil {
+		err = fmt.Errorf("os.MkdirTemp: %w", err)
+		return 0, WrapError(err)
+	}
+	defer os.RemoveAll(stagingDir)
+
+	manifest, err := stageBackup(r, stagingDir)
+	if err != nil {
+		// anything wrong with the backup itself is the client's to fix
+		err = withKind(kindInvalidInput, fmt.Errorf("stageBackup: %w", err))
+		return 0, WrapError(err)
+	}
+
+	tablesMu.Lock()
+	defer tablesMu.Unlock()
+
+	existing, err := listTables()
+	if err != nil {
+		err = fmt.Errorf("listTables: %w", err)
+		return 0, WrapError(err)
+	}
+
+	err = swapTables(stagingDir, manifest, existing)
+	if err != nil {
+		err = fmt.Errorf("swapTables: %w", err)
+		return 0, WrapError(err)
+	}
+
+	return len(manifest.Files), nil
+}
+
+// replaces the existing tables with the ones staged, all or none: the
+// existing tables are moved aside into stagingDir first, and put back if any
+// table cannot be moved into place, the caller must hold tablesMu
+func swapTables(stagingDir string, manifest backupManifest, existing []string) (err error) {
+	previousDir := filepath.Join(stagingDir, "previous")
+	err = os.Mkdir(previousDir, 0755)
+	if err != nil {
+		err = fmt.Errorf("os.Mkdir: %w", err)
+		return WrapError(err)
+	}
+
+	var movedAside, restored []string
+	defer func() {
+		if err == nil {
+			return
+		}
+		for _, tableName := range restored {
+			os.Remove(filepath.Join(appConfig.DataDir, tableName))
+		}
+		for _, tableName := range movedAside {
+			os.Rename(filepath.Join(previousDir, tableName), filepath.Join(appConfig.DataDir, tableName))
+		}
+	}()
+
+	for _, tableName := range existing {
+		err = os.Rename(filepath.Join(appConfig.DataDir, tableName), filepath.Join(previousDir, tableName))
+		if err != nil {
+			err = fmt.Errorf("os.Rename: %w", err)
+			return WrapError(err)
+		}
+		movedAside = append(movedAside, tableName)
+	}
+
+	for _, f := range manifest.Files {
+		err = os.Rename(filepath.Join(stagingDir, f.Name), filepath.Join(appConfig.DataDir, f.Name))
+		if err != nil {
+			err = fmt.Errorf("os.Rename: %w", err)
+			return WrapError(err)
+		}
+		restored = append(restored, f.Name)
+	}
+
+	return nil
+}
+
+// unpacks a backup into stagingDir, verifying it against its manifest
+func stageBackup(r io.Reader, stagingDir string) (manifest backupManifest, err error) {
+	gr, err := gzip.NewReader(r)
+	if err != nil {
+		err = fmt.Errorf("gzip.NewReader: %w", err)
+		return backupManifest{}, WrapError(err)
+	}
+	defer gr.Close()
+	tr := tar.NewReader(gr)
+
+	// the manifest must come first
+	header, err := tr.Next()
+	if err != nil {
+		err = fmt.Errorf("tr.Next: %w", err)
+		return backupManifest{}, WrapError(err)
+	}
+	if header.Name != manifestName {
+		err = fmt.Errorf("expected %s as the first entry, got %s", manifestName, header.Name)
+		return backupManifest{}, WrapError(err)
+	}
+	err = json.NewDecoder(tr).Decode(&manifest)
+	if err != nil {
+		err = fmt.Errorf("decoder.Decode: %w", err)
+		return backupManifest{}, WrapError(err)
+	}
+	if manifest.SchemaVersion > schemaVersion {
+		err = conflictError("backup %w: found %d, supported %d", errSchemaTooNew, manifest.SchemaVersion, schemaVersion)
+		return backupManifest{}, WrapError(err)
+	}
+
+	expected := make(map[string]backupFile)
+	for _, f := range manifest.Files {
+		if _, _, ok := parseTableName(f.Name); !ok || filepath.Base(f.Name) != f.Name {
+			err = fmt.Errorf("manifest lists an invalid table name: %q", f.Name)
+			return backupManifest{}, WrapError(err)
+		}
+		expected[f.Name] = f
+	}
+
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			err = fmt.Errorf("tr.Next: %w", err)
+			return backupManifest{}, WrapError(err)
+		}
+
+		f, ok := expected[header.Name]
+		if !ok {
+			err = fmt.Errorf("%s is not listed in the manifest", header.Name)
+			return backupManifest{}, WrapError(err)
+		}
+		delete(expected, header.Name)
+
+		err = stageBackupFile(tr, stagingDir, f)
+		if err != nil {
+			err = fmt.Errorf("stageBackupFile(%s): %w", f.Name, err)
+			return backupManifest{}, WrapError(err)
+		}
+	}
+
+	for name := range expected {
+		err = fmt.Errorf("%s is listed in the manifest but missing from the backup", name)
+		return backupManifest{}, WrapError(err)
+	}
+
+	return manifest, nil
+}
+
+// writes one table into stagingDir and checks its size, checksum and schema version
+func stageBackupFile(r io.Reader, stagingDir string, f backupFile) (err error) {
+	path := filepath.Join(stagingDir, f.Name)
+	file, err := os.Create(path)
+	if err != nil {
+		err = fmt.Errorf("os.Create: %w", err)
+		return WrapError(err)
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	size, err := io.Copy(io.MultiWriter(file, hash), r)
+	if err != nil {
+		err = fmt.Errorf("io.Copy: %w", err)
+		return WrapError(err)
+	}
+	if size != f.Size {
+		err = fmt.Errorf("size mismatch: expected %d, got %d", f.Size, size)
+		return WrapError(err)
+	}
+	if sum := hex.EncodeToString(hash.Sum(nil)); sum != f.SHA256 {
+		err = fmt.Errorf("checksum mismatch: expected %s, got %s", f.SHA256, sum)
+		return WrapError(err)
+	}
+
+	_, err = file.Seek(0, io.SeekStart)
+	if err != nil {
+		err = fmt.Errorf("file.Seek: %w", err)
+		return WrapError(err)
+	}
+	raw := make(map[string]interface{})
+	err = json.NewDecoder(file).Decode(&raw)
+	if err != nil {
+		err = fmt.Errorf("decoder.Decode: %w", err)
+		return WrapError(err)
+	}
+	version, _, err := decodeTable(raw)
+	if err != nil {
+		err = fmt.Errorf("decodeTable: %w", err)
+		return WrapError(err)
+	}
+	if version > schemaVersion {
+		err = conflictError("%w: found %d, supported %d", errSchemaTooNew, version, schemaVersion)
+		return WrapError(err)
+	}
+
+	return nil
+}
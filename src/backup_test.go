@@ -0,0 +1,151 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestBackupRestore(t *testing.T) {
+	appConfig.DataDir = t.TempDir()
+	defer func() { appConfig = defaultConfig() }()
+
+	db, err := newDatabase("archive_list", "asdf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.writeData(map[string]interface{}{"archives": []interface{}{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	original, err := os.ReadFile(db.getFilePath())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var backup bytes.Buffer
+	err = writeBackup(&backup)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("restore replaces every table", func(t *testing.T) {
+		err := os.WriteFile(filepath.Join(appConfig.DataDir, "1234_analysis.json"), []byte("{}"), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = os.WriteFile(db.getFilePath(), []byte("{}"), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		restored, err := restoreBackup(bytes.NewReader(backup.Bytes()))
+		if err != nil {
+			t.Fatalf("expected %v, got %v", nil, err)
+		}
+		if restored != 1 {
+			t.Errorf("expected %v, got %v", 1, restored)
+		}
+
+		actual, _ := os.ReadFile(db.getFilePath())
+		if !bytes.Equal(actual, original) {
+			t.Errorf("expected %s, got %s", original, actual)
+		}
+		if _, err := os.Stat(filepath.Join(appConfig.DataDir, "1234_analysis.json")); !os.IsNotExist(err) {
+			t.Errorf("expected table not in the backup to be removed, got %v", err)
+		}
+	})
+
+	t.Run("failed restore keeps the existing tables", func(t *testing.T) {
+		// a backup of two tables, the first of which can be restored
+		other := filepath.Join(appConfig.DataDir, "aaaa_profile.json")
+		err := os.WriteFile(other, []byte("{}"), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.Remove(other)
+		var twoTables bytes.Buffer
+		err = writeBackup(&twoTables)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = os.WriteFile(other, []byte(`{"changed": true}`), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// and a directory in the way of the second
+		err = os.Remove(db.getFilePath())
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = os.Mkdir(db.getFilePath(), 0755)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.WriteFile(db.getFilePath(), original, 0644)
+		defer os.Remove(db.getFilePath())
+
+		_, err = restoreBackup(bytes.NewReader(twoTables.Bytes()))
+		if err == nil {
+			t.Errorf("expected error, got %v", err)
+		}
+		actual, err := os.ReadFile(other)
+		if err != nil || string(actual) != `{"changed": true}` {
+			t.Errorf("expected %s, got %s (%v)", `{"changed": true}`, actual, err)
+		}
+	})
+
+	t.Run("tampered backup is rejected", func(t *testing.T) {
+		tampered := rewriteBackup(t, backup.Bytes(), func(name string, contents []byte) []byte {
+			if name == db.TableName {
+				return bytes.Replace(contents, []byte("archives"), []byte("archiveZ"), 1)
+			}
+			return contents
+		})
+
+		_, err := restoreBackup(bytes.NewReader(tampered))
+		if err == nil {
+			t.Errorf("expected error, got %v", err)
+		}
+		actual, _ := os.ReadFile(db.getFilePath())
+		if !bytes.Equal(actual, original) {
+			t.Errorf("expected %s, got %s", original, actual)
+		}
+	})
+}
+
+// copies a backup, passing every entry through modify
+func rewriteBackup(t *testing.T, backup []byte, modify func(name string, contents []byte) []byte) []byte {
+	gr, err := gzip.NewReader(bytes.NewReader(backup))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := tar.NewReader(gr)
+
+	var out bytes.Buffer
+	gw := gzip.NewWriter(&out)
+	tw := tar.NewWriter(gw)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		contents, _ := io.ReadAll(tr)
+		contents = modify(header.Name, contents)
+		header.Size = int64(len(contents))
+		tw.WriteHeader(header)
+		tw.Write(contents)
+	}
+	tw.Close()
+	gw.Close()
+
+	return out.Bytes()
+}
@@ -0,0 +1,387 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// chessComClient talks to the chess.com published-data API.
+// Following chess.com's guidance, requests are made one at a time
+// and spaced at least MinInterval apart. Network errors and 5xx responses
+// are retried with exponential backoff and jitter, 429 responses wait
+// for Retry-After (or the backoff) before retrying, unless it is longer than
+// MaxBackoff: every other request waits with it, so the request fails instead.
+// Any other error, e.g. a request that cannot be made, is not retried.
+type chessComClient struct {
+	source      string // chess.com or lichess, for the metrics
+	httpClient  *http.Client
+	userAgent   string
+	accept      string
+	maxRetries  int
+	backoff     time.Duration // delay before the first retry, doubled for each retry after
+	maxBackoff  time.Duration
+	minInterval time.Duration
+	mode        string // live, record or replay, see recorder.go
+	fixtureDir  string
+
+	mu          sync.Mutex // serializes requests
+	lastRequest time.Time
+
+	sleep func(time.Duration) // replaced in tests
+}
+
+// the client used for all chess.com requests, set up from appConfig by setConfig
+var chessCom = newChessComClient(defaultConfig().ChessCom)
+
+// validators from a previous response, sent to make a request conditional
+type cacheValidators struct {
+	ETag         string `json:"etag"`
+	LastModified string `json:"last_modified"`
+}
+
+// a successful response, StatusCode is either 200 or 304
+type chessComResponse struct {
+	StatusCode int
+	Body       []byte
+	Validators cacheValidators
+}
+
+// returned for any response other than 200 or 304 once retries are exhausted
+type upstreamStatusError struct {
+	URL        string
+	StatusCode int
+}
+
+func (e *upstreamStatusError) Error() string {
+	return fmt.Sprintf("%s: unexpected status %d %s", e.URL, e.StatusCode, http.StatusText(e.StatusCode))
+}
+
+// returned when no response was received, or its body could not be read
+type upstreamTransportError struct {
+	URL string
+	Err error
+}
+
+func (e *upstreamTransportError) Error() string {
+	return fmt.Sprintf("%s: %v", e.URL, e.Err)
+}
+
+func (e *upstreamTransportError) Unwrap() error {
+	return e.Err
+}
+
+// whether the client failed to reach the server or lost the connection, as
+// opposed to a request it could not send at all, e.g. to an unsupported scheme
+func isTransportError(err error) bool {
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		err = urlErr.Err // a *url.Error is a net.Error whatever its cause
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) ||
+		errors.Is(err, io.EOF) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, context.DeadlineExceeded)
+}
+
+// whether a failed request may succeed when retried: network errors, 429 and 5xx
+func isRetryable(err error) bool {
+	var transportErr *upstreamTransportError
+	var statusErr *upstreamStatusError
+	switch {
+	case errors.As(err, &transportErr):
+		return true
+	case errors.As(err, &statusErr):
+		return statusErr.StatusCode == http.StatusTooManyRequests || statusErr.StatusCode >= 500
+	}
+	return false
+}
+
+// gives a failed request its kind: chess.com not knowing the player or the
+// archive is not found, anything else means it is unavailable
+func upstreamError(err error) error {
+	var statusErr *upstreamStatusError
+	if errors.As(err, &statusErr) && (statusErr.StatusCode == http.StatusNotFound || statusErr.StatusCode == http.StatusGone) {
+		return withKind(kindNotFound, err)
+	}
+	return withKind(kindUpstreamUnavailable, err)
+}
+
+func newChessComClient(c upstreamConfig) *chessComClient {
+	userAgent := c.UserAgent
+	if c.Contact != "" {
+		userAgent = fmt.Sprintf("%s (contact: %s)", userAgent, c.Contact)
+	}
+
+	return &chessComClient{
+		source:      chessComSource.name(),
+		httpClient:  &http.Client{Timeout: time.Duration(c.Timeout)},
+		userAgent:   userAgent,
+		accept:      "application/json",
+		maxRetries:  c.MaxRetries,
+		backoff:     time.Duration(c.Backoff),
+		maxBackoff:  time.Duration(c.MaxBackoff),
+		minInterval: time.Duration(c.MinInterval),
+		mode:        c.Mode,
+		fixtureDir:  c.FixtureDir,
+		sleep:       time.Sleep,
+	}
+}
+
+// queries a URL and returns the data as a Golang map
+func (c *chessComClient) getJSON(url string) (data map[string]interface{}, err error) {
+	resp, err := c.get(url, cacheValidators{})
+	if err != nil {
+		err = fmt.Errorf("c.get: %w", err)
+		return nil, WrapError(err)
+	}
+
+	// Convert the JSON data within 'body' to a Golang map in the 'data' var
+	err = json.Unmarshal(resp.Body, &data)
+	if err != nil {
+		err = fmt.Errorf("json.Unmarshal: %w", err)
+		return nil, WrapError(err)
+	}
+
+	return data, nil
+}
+
+// queries a URL conditionally, using the validators stored from the last response
+// haveCopy must only be true if the caller still holds the data of that response,
+// when the data is unchanged modified is false and data is nil
+func (c *chessComClient) getJSONIfModified(url string, haveCopy bool) (data map[string]interface{}, modified bool, err error) {
+	cache, err := newDatabase("http_cache", "")
+	if err != nil {
+		err = fmt.Errorf("newDatabase: %w", err)
+		return nil, false, WrapError(err)
+	}
+
+	var validators cacheValidators
+	if haveCopy {
+		validators = cachedValidators(cache, url)
+	}
+
+	resp, err := c.get(url, validators)
+	if err != nil {
+		err = fmt.Errorf("c.get: %w", err)
+		return nil, false, WrapError(err)
+	}
+	if resp.StatusCode == http.StatusNotModified {
+		// the stored copy is current as of now
+		err = recordFetch(cache, url, validators)
+		if err != nil {
+			err = fmt.Errorf("recordFetch: %w", err)
+			return nil, false, WrapError(err)
+		}
+		return nil, false, nil
+	}
+
+	// Convert the JSON data within 'body' to a Golang map in the 'data' var
+	err = json.Unmarshal(resp.Body, &data)
+	if err != nil {
+		err = fmt.Errorf("json.Unmarshal: %w", err)
+		return nil, false, WrapError(err)
+	}
+
+	// remember the validators for next time
+	err = recordFetch(cache, url, resp.Validators)
+	if err != nil {
+		err = fmt.Errorf("recordFetch: %w", err)
+		return nil, false, WrapError(err)
+	}
+
+	return data, true, nil
+}
+
+// stores the validators of a URL and the time the stored copy was last known
+// to be current, see lastFetched
+func recordFetch(cache database, url string, validators cacheValidators) (err error) {
+	err = cache.writeData(map[string]interface{}{
+		url: map[string]interface{}{
+			"etag":          validators.ETag,
+			"last_modified": validators.LastModified,
+			"fetched":       time.Now().UTC(),
+		},
+	})
+	if err != nil {
+		err = fmt.Errorf("cache.writeData: %w", err)
+		return WrapError(err)
+	}
+	return nil
+}
+
+// when the stored copy of a URL was last fetched or confirmed unchanged
+func lastFetched(cache database, url string) (fetched time.Time, ok bool) {
+	entry, ok := cache.Data[url].(map[string]interface{})
+	if !ok {
+		return time.Time{}, false
+	}
+	value, _ := entry["fetched"].(string)
+	fetched, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return fetched, true
+}
+
+// reads the validators stored for a URL
+func cachedValidators(cache database, url string) (validators cacheValidators) {
+	entry, ok := cache.Data[url].(map[string]interface{})
+	if !ok {
+		return cacheValidators{}
+	}
+	validators.ETag, _ = entry["etag"].(string)
+	validators.LastModified, _ = entry["last_modified"].(string)
+	return validators
+}
+
+// GETs a URL, retrying as described on chessComClient, and returns the 200 or 304 response
+func (c *chessComClient) get(url string, validators cacheValidators) (resp chessComResponse, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	switch c.mode {
+	case "replay":
+		resp, err = c.replay(url, validators)
+		return resp, upstreamError(err)
+	case "record":
+		// always fetch the full response, so it can be replayed
+		validators = cacheValidators{}
+	}
+
+	for attempt := 0; ; attempt++ {
+		resp, retryAfter, err := c.try(url, validators)
+		if err == nil {
+			return resp, nil
+		}
+
+		if !isRetryable(err) || attempt >= c.maxRetries {
+			upstreamErrors.add(1, c.source)
+			return chessComResponse{}, WrapError(upstreamError(err))
+		}
+
+		// the wait is asked of this client, so every other request waits with it
+		if retryAfter > c.maxBackoff {
+			upstreamErrors.add(1, c.source)
+			err = fmt.Errorf("%w: Retry-After %s is longer than the max backoff of %s", err, retryAfter, c.maxBackoff)
+			return chessComResponse{}, WrapError(upstreamError(err))
+		}
+		delay := c.backoffDelay(attempt)
+		if retryAfter > 0 {
+			delay = retryAfter
+		}
+		errorLog.Printf("chess.com request failed (%v), retry %d/%d in %s", err, attempt+1, c.maxRetries, delay)
+		c.sleep(delay)
+	}
+}
+
+// makes a single request, returning the Retry-After delay of a 429
+func (c *chessComClient) try(url string, validators cacheValidators) (resp chessComResponse, retryAfter time.Duration, err error) {
+	// global rate limit
+	if wait := c.minInterval - time.Since(c.lastRequest); wait > 0 {
+		c.sleep(wait)
+	}
+	c.lastRequest = time.Now()
+
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		err = fmt.Errorf("http.NewRequest: %w", err)
+		return chessComResponse{}, 0, err
+	}
+	req.Header.Set("User-Agent", c.userAgent)
+	req.Header.Set("Accept", c.accept)
+	if validators.ETag != "" {
+		req.Header.Set("If-None-Match", validators.ETag)
+	}
+	if validators.LastModified != "" {
+		req.Header.Set("If-Modified-Since", validators.LastModified)
+	}
+
+	start := time.Now()
+	httpResp, err := c.httpClient.Do(req)
+	upstreamRequestSeconds.observeSince(start, c.source)
+	if err != nil {
+		upstreamRequests.add(1, c.source, "error")
+		err = fmt.Errorf("c.httpClient.Do: %w", err)
+		if isTransportError(err) {
+			err = &upstreamTransportError{URL: url, Err: err}
+		}
+		return chessComResponse{}, 0, err
+	}
+	defer httpResp.Body.Close()
+	upstreamRequests.add(1, c.source, strconv.Itoa(httpResp.StatusCode))
+
+	// Get the body of the response from the ReaderCloser interface into a Go variable 'body'
+	body, err := io.ReadAll(httpResp.Body)
+	if err != nil {
+		err = &upstreamTransportError{URL: url, Err: fmt.Errorf("io.ReadAll: %w", err)}
+		return chessComResponse{}, 0, err
+	}
+
+	if c.mode == "record" {
+		err = c.record(url, httpResp.StatusCode, httpResp.Header, body)
+		if err != nil {
+			err = fmt.Errorf("c.record: %w", err)
+			return chessComResponse{}, 0, err
+		}
+	}
+
+	resp = chessComResponse{
+		StatusCode: httpResp.StatusCode,
+		Validators: cacheValidators{
+			ETag:         httpResp.Header.Get("ETag"),
+			LastModified: httpResp.Header.Get("Last-Modified"),
+		},
+	}
+
+	if httpResp.StatusCode == http.StatusNotModified {
+		return resp, 0, nil
+	}
+	if httpResp.StatusCode != http.StatusOK {
+		if httpResp.StatusCode == http.StatusTooManyRequests {
+			retryAfter = parseRetryAfter(httpResp.Header.Get("Retry-After"))
+		}
+		return chessComResponse{}, retryAfter, &upstreamStatusError{URL: url, StatusCode: httpResp.StatusCode}
+	}
+
+	resp.Body = body
+	return resp, 0, nil
+}
+
+// exponential backoff with jitter, between half and all of backoff * 2^attempt
+func (c *chessComClient) backoffDelay(attempt int) time.Duration {
+	delay := c.backoff << attempt
+	if delay > c.maxBackoff || delay <= 0 {
+		delay = c.maxBackoff
+	}
+	if delay <= 1 {
+		return delay
+	}
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
+
+// Retry-After is either a number of seconds or an HTTP date
+func parseRetryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if t, err := http.ParseTime(value); err == nil {
+		if d := time.Until(t); d > 0 {
+			return d
+		}
+	}
+	return 0
+}
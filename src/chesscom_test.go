@@ -0,0 +1,113 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestChessComClient(t *testing.T) {
+	type testCase struct {
+		// Input Params
+		statuses   []int // returned in order, the last one repeats
+		retryAfter string
+		// Expected Values
+		requests int
+		sleeps   []time.Duration
+		isErr    bool
+	}
+
+	t.Run("retries and backoff", func(t *testing.T) {
+		tests := []testCase{
+			{[]int{200}, "", 1, nil, false},
+			{[]int{500, 502, 200}, "", 3, []time.Duration{time.Second, 2 * time.Second}, false},
+			{[]int{429, 200}, "3", 2, []time.Duration{3 * time.Second}, false},
+			{[]int{429, 200}, "30", 2, []time.Duration{30 * time.Second}, false},
+			// a longer wait would hold up every other request
+			{[]int{429, 200}, "31", 1, nil, true},
+			{[]int{429, 200}, "86400", 1, nil, true},
+			{[]int{404}, "", 1, nil, true},
+			{[]int{503}, "", 3, []time.Duration{time.Second, 2 * time.Second}, true},
+		}
+
+		for _, test := range tests {
+			requests := 0
+			var userAgent string
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				userAgent = r.Header.Get("User-Agent")
+				status := test.statuses[min(requests, len(test.statuses)-1)]
+				requests++
+				if test.retryAfter != "" {
+					w.Header().Set("Retry-After", test.retryAfter)
+				}
+				w.WriteHeader(status)
+				w.Write([]byte(`{"archives": []}`))
+			}))
+
+			c := defaultConfig().ChessCom
+			c.MaxRetries = 2
+			c.Contact = "someone@example.com"
+			client := newChessComClient(c)
+			var sleeps []time.Duration
+			client.sleep = func(d time.Duration) { sleeps = append(sleeps, d) }
+			client.minInterval = 0
+
+			_, err := client.getJSON(server.URL)
+			server.Close()
+
+			if (err != nil) != test.isErr {
+				t.Errorf("%v: expected error %v, got %v", test.statuses, test.isErr, err)
+			}
+			if requests != test.requests {
+				t.Errorf("%v: expected %v requests, got %v", test.statuses, test.requests, requests)
+			}
+			if len(sleeps) != len(test.sleeps) {
+				t.Errorf("%v: expected sleeps %v, got %v", test.statuses, test.sleeps, sleeps)
+				continue
+			}
+			for i := range sleeps {
+				// jitter keeps backoff between half and all of the expected delay
+				if sleeps[i] > test.sleeps[i] || sleeps[i] < test.sleeps[i]/2 {
+					t.Errorf("%v: expected sleep of up to %v, got %v", test.statuses, test.sleeps[i], sleeps[i])
+				}
+			}
+			if userAgent != c.UserAgent+" (contact: someone@example.com)" {
+				t.Errorf("expected %v, got %v", c.UserAgent+" (contact: someone@example.com)", userAgent)
+			}
+		}
+	})
+	t.Run("only transport errors and statuses are retried", func(t *testing.T) {
+		closed := httptest.NewServer(http.NotFoundHandler())
+		closed.Close()
+
+		type testCase struct {
+			// Input Params
+			url string
+			// Expected Values
+			sleeps int
+		}
+
+		tests := []testCase{
+			{closed.URL, 2},    // connection refused
+			{"http://%zz", 0},  // the request cannot be made
+			{"unknown://x", 0}, // nor sent
+		}
+		for _, test := range tests {
+			c := defaultConfig().ChessCom
+			c.MaxRetries = 2
+			client := newChessComClient(c)
+			sleeps := 0
+			client.sleep = func(d time.Duration) { sleeps++ }
+			client.minInterval = 0
+
+			_, err := client.getJSON(test.url)
+			if err == nil {
+				t.Errorf("%s: expected error, got %v", test.url, err)
+			}
+			if sleeps != test.sleeps {
+				t.Errorf("%s: expected %v sleeps, got %v", test.url, test.sleeps, sleeps)
+			}
+		}
+	})
+}
@@ -0,0 +1,409 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Configuration is resolved in order of precedence (highest first):
+//   1. command line flags
+//   2. environment variables (CHESS_ANALYZER_*)
+//   3. config file (YAML or JSON, chosen by extension)
+//   4. defaults
+
+type engineConfig struct {
+	Path    string            `json:"path" yaml:"path"`
+	Options map[string]string `json:"options" yaml:"options"` // passed to the engine via `setoption`
+}
+
+type searchConfig struct {
+	MoveTime duration `json:"movetime" yaml:"movetime"`
+	Depth    int      `json:"depth" yaml:"depth"`
+}
+
+// the client settings of an upstream site, chess.com or Lichess
+type upstreamConfig struct {
+	BaseURL     string   `json:"base_url" yaml:"base_url"`
+	UserAgent   string   `json:"user_agent" yaml:"user_agent"`
+	Contact     string   `json:"contact" yaml:"contact"` // appended to the User-Agent, so the site can reach us
+	Timeout     duration `json:"timeout" yaml:"timeout"`
+	MaxRetries  int      `json:"max_retries" yaml:"max_retries"`
+	Backoff     duration `json:"backoff" yaml:"backoff"`
+	MaxBackoff  duration `json:"max_backoff" yaml:"max_backoff"`
+	MinInterval duration `json:"min_interval" yaml:"min_interval"` // between the start of consecutive requests
+	Mode        string   `json:"mode" yaml:"mode"`                 // live, record or replay
+	FixtureDir  string   `json:"fixture_dir" yaml:"fixture_dir"`   // where record saves and replay reads responses
+}
+
+type config struct {
+	DataDir     string         `json:"data_dir" yaml:"data_dir"`
+	Listen      string         `json:"listen" yaml:"listen"`
+	Engine      engineConfig   `json:"engine" yaml:"engine"`
+	Search      searchConfig   `json:"search" yaml:"search"`
+	Workers     int            `json:"workers" yaml:"workers"`           // engine processes used per analysis
+	MaxAnalyses int            `json:"max_analyses" yaml:"max_analyses"` // running at once, further analyses are refused, 0 for no limit
+	ChessCom    upstreamConfig `json:"chesscom" yaml:"chesscom"`
+	Lichess     upstreamConfig `json:"lichess" yaml:"lichess"`
+}
+
+// the effective configuration, set by main before any command runs
+var appConfig = defaultConfig()
+
+// makes c the effective configuration
+func setConfig(c config) {
+	appConfig = c
+	chessCom = newChessComClient(c.ChessCom)
+	lichess = newLichessClient(c.Lichess)
+}
+
+func defaultConfig() config {
+	return config{
+		DataDir: "/var/lib/data",
+		Listen:  ":24377", // 24377 = 'chess' in T9
+		Engine: engineConfig{
+			Path:    "stockfish",
+			Options: make(map[string]string),
+		},
+		Search: searchConfig{
+			MoveTime: duration(time.Second * 1),
+		},
+		Workers: 1,
+		ChessCom: upstreamConfig{
+			BaseURL:     "https://api.chess.com/pub",
+			UserAgent:   "chess-analyzer (+https://github.com/josephchapman/chess-analyzer)",
+			Timeout:     duration(time.Second * 30),
+			MaxRetries:  4,
+			Backoff:     duration(time.Second * 1),
+			MaxBackoff:  duration(time.Second * 30),
+			MinInterval: duration(time.Millisecond * 250),
+			Mode:        "live",
+		},
+		Lichess: upstreamConfig{
+			BaseURL:     "https://lichess.org",
+			UserAgent:   "chess-analyzer (+https://github.com/josephchapman/chess-analyzer)",
+			Timeout:     duration(time.Second * 30),
+			MaxRetries:  4,
+			Backoff:     duration(time.Second * 1),
+			MaxBackoff:  duration(time.Second * 30),
+			MinInterval: duration(time.Millisecond * 250),
+			Mode:        "live",
+		},
+	}
+}
+
+// duration is a time.Duration that reads and writes as a string (e.g. "1s")
+type duration time.Duration
+
+func (d duration) MarshalText() ([]byte, error) {
+	return []byte(time.Duration(d).String()), nil
+}
+
+func (d *duration) UnmarshalText(text []byte) error {
+	parsed, err := time.ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+	*d = duration(parsed)
+	return nil
+}
+
+// setting ties a config value to its flag and environment variable
+type setting struct {
+	Flag  string
+	Env   string
+	Usage string
+	Set   func(c *config, value string) error
+}
+
+var settings = slices.Concat([]setting{
+	{"data-dir", "CHESS_ANALYZER_DATA_DIR", "directory holding the table files", func(c *config, value string) error {
+		c.DataDir = value
+		return nil
+	}},
+	{"listen", "CHESS_ANALYZER_LISTEN", "address the API listens on", func(c *config, value string) error {
+		c.Listen = value
+		return nil
+	}},
+	{"engine-path", "CHESS_ANALYZER_ENGINE_PATH", "UCI engine binary", func(c *config, value string) error {
+		c.Engine.Path = value
+		return nil
+	}},
+	{"engine-options", "CHESS_ANALYZER_ENGINE_OPTIONS", "comma separated UCI options, e.g. Threads=2,Hash=64", func(c *config, value string) error {
+		options, err := parseEngineOptions(value)
+		if err != nil {
+			return err
+		}
+		c.Engine.Options = options
+		return nil
+	}},
+	{"search-movetime", "CHESS_ANALYZER_SEARCH_MOVETIME", "engine time per position, e.g. 1s", func(c *config, value string) error {
+		return c.Search.MoveTime.UnmarshalText([]byte(value))
+	}},
+	{"search-depth", "CHESS_ANALYZER_SEARCH_DEPTH", "engine depth per position", func(c *config, value string) (err error) {
+		c.Search.Depth, err = strconv.Atoi(value)
+		return err
+	}},
+	{"workers", "CHESS_ANALYZER_WORKERS", "engine processes used per analysis", func(c *config, value string) (err error) {
+		c.Workers, err = strconv.Atoi(value)
+		return err
+	}},
+	{"max-analyses", "CHESS_ANALYZER_MAX_ANALYSES", "game or position analyses running at once, further analyses are refused (0 for no limit)", func(c *config, value string) (err error) {
+		c.MaxAnalyses, err = strconv.Atoi(value)
+		return err
+	}},
+},
+	upstreamSettings("chesscom", "chess.com", func(c *config) *upstreamConfig { return &c.ChessCom }),
+	upstreamSettings("lichess", "Lichess", func(c *config) *upstreamConfig { return &c.Lichess }),
+)
+
+// the settings of an upstream site's client, named e.g. -chesscom-timeout and
+// CHESS_ANALYZER_CHESSCOM_TIMEOUT after prefix
+func upstreamSettings(prefix string, site string, upstream func(c *config) *upstreamConfig) []setting {
+	env := "CHESS_ANALYZER_" + strings.ToUpper(prefix) + "_"
+	return []setting{
+		{prefix + "-base-url", env + "BASE_URL", site + " API base URL", func(c *config, value string) error {
+			upstream(c).BaseURL = value
+			return nil
+		}},
+		{prefix + "-user-agent", env + "USER_AGENT", "User-Agent sent to " + site, func(c *config, value string) error {
+			upstream(c).UserAgent = value
+			return nil
+		}},
+		{prefix + "-contact", env + "CONTACT", "contact details (e.g. email) added to the User-Agent", func(c *config, value string) error {
+			upstream(c).Contact = value
+			return nil
+		}},
+		{prefix + "-timeout", env + "TIMEOUT", "timeout for each " + site + " request, e.g. 30s", func(c *config, value string) error {
+			return upstream(c).Timeout.UnmarshalText([]byte(value))
+		}},
+		{prefix + "-max-retries", env + "MAX_RETRIES", "retries after a failed " + site + " request", func(c *config, value string) (err error) {
+			upstream(c).MaxRetries, err = strconv.Atoi(value)
+			return err
+		}},
+		{prefix + "-backoff", env + "BACKOFF", "delay before the first retry, doubled for each retry after", func(c *config, value string) error {
+			return upstream(c).Backoff.UnmarshalText([]byte(value))
+		}},
+		{prefix + "-max-backoff", env + "MAX_BACKOFF", "longest backoff between retries, a 429 asking for a longer wait fails at once", func(c *config, value string) error {
+			return upstream(c).MaxBackoff.UnmarshalText([]byte(value))
+		}},
+		{prefix + "-min-interval", env + "MIN_INTERVAL", "shortest time between " + site + " requests", func(c *config, value string) error {
+			return upstream(c).MinInterval.UnmarshalText([]byte(value))
+		}},
+		{prefix + "-mode", env + "MODE", "live, record (save every " + site + " response) or replay (serve only saved responses)", func(c *config, value string) error {
+			upstream(c).Mode = value
+			return nil
+		}},
+		{prefix + "-fixture-dir", env + "FIXTURE_DIR", "directory of saved " + site + " responses for record and replay", func(c *config, value string) error {
+			upstream(c).FixtureDir = value
+			return nil
+		}},
+	}
+}
+
+// parses "Name=Value,Name=Value" into a map
+func parseEngineOptions(s string) (options map[string]string, err error) {
+	options = make(map[string]string)
+	if s == "" {
+		return options, nil
+	}
+	for _, pair := range strings.Split(s, ",") {
+		name, value, ok := strings.Cut(pair, "=")
+		name = strings.TrimSpace(name)
+		if !ok || name == "" {
+			err = fmt.Errorf("invalid engine option %q, expected Name=Value", pair)
+			return nil, err
+		}
+		options[name] = strings.TrimSpace(value)
+	}
+	return options, nil
+}
+
+// builds the effective configuration from the config file, environment and flags
+func loadConfig(name string, args []string) (c config, rest []string, err error) {
+	c = defaultConfig()
+
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	configFile := fs.String("config", os.Getenv("CHESS_ANALYZER_CONFIG"), "YAML or JSON config file (env CHESS_ANALYZER_CONFIG)")
+	flagValues := make(map[string]*string)
+	for _, s := range settings {
+		flagValues[s.Flag] = fs.String(s.Flag, "", fmt.Sprintf("%s (env %s)", s.Usage, s.Env))
+	}
+	err = fs.Parse(args)
+	if err != nil {
+		return config{}, nil, err
+	}
+
+	// config file
+	if *configFile != "" {
+		err = c.readFile(*configFile)
+		if err != nil {
+			err = fmt.Errorf("c.readFile: %w", err)
+			return config{}, nil, WrapError(err)
+		}
+	}
+
+	// environment variables
+	for _, s := range settings {
+		value, ok := os.LookupEnv(s.Env)
+		if !ok {
+			continue
+		}
+		err = s.Set(&c, value)
+		if err != nil {
+			err = fmt.Errorf("%s: %w", s.Env, err)
+			return config{}, nil, WrapError(err)
+		}
+	}
+
+	// flags, only those explicitly set
+	fs.Visit(func(f *flag.Flag) {
+		for _, s := range settings {
+			if s.Flag == f.Name && err == nil {
+				err = s.Set(&c, *flagValues[s.Flag])
+				if err != nil {
+					err = fmt.Errorf("-%s: %w", s.Flag, err)
+				}
+			}
+		}
+	})
+	if err != nil {
+		return config{}, nil, WrapError(err)
+	}
+
+	// URLs are built as BaseURL + "/player/..."
+	c.ChessCom.BaseURL = strings.TrimSuffix(c.ChessCom.BaseURL, "/")
+	c.Lichess.BaseURL = strings.TrimSuffix(c.Lichess.BaseURL, "/")
+
+	err = c.validate()
+	if err != nil {
+		err = fmt.Errorf("c.validate: %w", err)
+		return config{}, nil, WrapError(err)
+	}
+
+	return c, fs.Args(), nil
+}
+
+func (c *config) readFile(path string) (err error) {
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		err = fmt.Errorf("os.ReadFile: %w", err)
+		return WrapError(err)
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(contents, c)
+		if err != nil {
+			err = fmt.Errorf("yaml.Unmarshal: %w", err)
+			return WrapError(err)
+		}
+	case ".json":
+		err = json.Unmarshal(contents, c)
+		if err != nil {
+			err = fmt.Errorf("json.Unmarshal: %w", err)
+			return WrapError(err)
+		}
+	default:
+		err = fmt.Errorf("unsupported config file extension %q, expected .yaml, .yml or .json", filepath.Ext(path))
+		return WrapError(err)
+	}
+
+	return nil
+}
+
+func (c *config) validate() (err error) {
+	var problems []string
+
+	if c.DataDir == "" {
+		problems = append(problems, "data_dir must not be empty")
+	}
+	if _, _, err := net.SplitHostPort(c.Listen); err != nil {
+		problems = append(problems, fmt.Sprintf("listen %q is not host:port", c.Listen))
+	}
+	if c.Engine.Path == "" {
+		problems = append(problems, "engine.path must not be empty")
+	}
+	if c.Search.MoveTime < 0 || c.Search.Depth < 0 {
+		problems = append(problems, "search.movetime and search.depth must not be negative")
+	}
+	if c.Search.MoveTime == 0 && c.Search.Depth == 0 {
+		problems = append(problems, "one of search.movetime or search.depth must be set")
+	}
+	if c.Workers < 1 {
+		problems = append(problems, "workers must be at least 1")
+	}
+	if c.MaxAnalyses < 0 {
+		problems = append(problems, "max_analyses must not be negative")
+	}
+	problems = append(problems, c.ChessCom.validate("chesscom")...)
+	problems = append(problems, c.Lichess.validate("lichess")...)
+
+	if len(problems) > 0 {
+		err = fmt.Errorf("invalid configuration: %s", strings.Join(problems, "; "))
+		return WrapError(err)
+	}
+	return nil
+}
+
+// the problems with an upstream site's settings, named after their key in the config file
+func (u *upstreamConfig) validate(key string) (problems []string) {
+	base, err := url.Parse(u.BaseURL)
+	if err != nil || (base.Scheme != "http" && base.Scheme != "https") || base.Host == "" {
+		problems = append(problems, fmt.Sprintf("%s.base_url %q is not an http(s) URL", key, u.BaseURL))
+	}
+	if u.UserAgent == "" {
+		problems = append(problems, fmt.Sprintf("%s.user_agent must not be empty", key))
+	}
+	if u.Timeout <= 0 || u.Backoff <= 0 {
+		problems = append(problems, fmt.Sprintf("%s.timeout and %s.backoff must be positive", key, key))
+	}
+	if u.MaxBackoff < u.Backoff {
+		problems = append(problems, fmt.Sprintf("%s.max_backoff must not be less than %s.backoff", key, key))
+	}
+	if u.MaxRetries < 0 || u.MinInterval < 0 {
+		problems = append(problems, fmt.Sprintf("%s.max_retries and %s.min_interval must not be negative", key, key))
+	}
+	switch u.Mode {
+	case "live":
+	case "record", "replay":
+		if u.FixtureDir == "" {
+			problems = append(problems, fmt.Sprintf("%s.fixture_dir must be set in %s mode", key, u.Mode))
+		}
+	default:
+		problems = append(problems, fmt.Sprintf("%s.mode %q is not live, record or replay", key, u.Mode))
+	}
+	return problems
+}
+
+// pretty-prints the configuration as indented JSON
+func (c *config) prettyPrint() (s string, err error) {
+	configJSON, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		err = fmt.Errorf("json.MarshalIndent: %w", err)
+		return "", WrapError(err)
+	}
+
+	s = string(configJSON)
+	return s, nil
+}
+
+// engine options in a stable order, so the engine is always configured the same way
+func (c *config) sortedEngineOptions() (names []string) {
+	for name := range c.Engine.Options {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLoadConfig(t *testing.T) {
+	configFile := filepath.Join(t.TempDir(), "config.yaml")
+	contents := "data_dir: /from/file\nlisten: \":1000\"\nworkers: 2\nsearch:\n  movetime: 250ms\n"
+	err := os.WriteFile(configFile, []byte(contents), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("flags override environment override file override defaults", func(t *testing.T) {
+		t.Setenv("CHESS_ANALYZER_CONFIG", configFile)
+		t.Setenv("CHESS_ANALYZER_LISTEN", ":2000")
+		t.Setenv("CHESS_ANALYZER_WORKERS", "3")
+
+		c, _, err := loadConfig("test", []string{"-workers", "4"})
+		if err != nil {
+			t.Fatalf("expected %v, got %v", nil, err)
+		}
+		if c.DataDir != "/from/file" {
+			t.Errorf("expected %v, got %v", "/from/file", c.DataDir)
+		}
+		if c.Listen != ":2000" {
+			t.Errorf("expected %v, got %v", ":2000", c.Listen)
+		}
+		if c.Workers != 4 {
+			t.Errorf("expected %v, got %v", 4, c.Workers)
+		}
+		if time.Duration(c.Search.MoveTime) != 250*time.Millisecond {
+			t.Errorf("expected %v, got %v", 250*time.Millisecond, time.Duration(c.Search.MoveTime))
+		}
+		if c.Engine.Path != "stockfish" {
+			t.Errorf("expected %v, got %v", "stockfish", c.Engine.Path)
+		}
+	})
+
+	t.Run("lichess has client settings of its own", func(t *testing.T) {
+		t.Setenv("CHESS_ANALYZER_LICHESS_MIN_INTERVAL", "1s")
+
+		c, _, err := loadConfig("test", []string{"-lichess-mode", "replay", "-lichess-fixture-dir", "/fixtures/lichess"})
+		if err != nil {
+			t.Fatalf("expected %v, got %v", nil, err)
+		}
+		if c.Lichess.Mode != "replay" || c.Lichess.FixtureDir != "/fixtures/lichess" || time.Duration(c.Lichess.MinInterval) != time.Second {
+			t.Errorf("expected %v, %v and %v, got %+v", "replay", "/fixtures/lichess", time.Second, c.Lichess)
+		}
+		if c.ChessCom.Mode != "live" || c.ChessCom.FixtureDir != "" || c.ChessCom.MinInterval != defaultConfig().ChessCom.MinInterval {
+			t.Errorf("expected the chesscom defaults, got %+v", c.ChessCom)
+		}
+	})
+
+	t.Run("invalid values are rejected", func(t *testing.T) {
+		tests := [][]string{
+			{"-workers", "0"},
+			{"-max-analyses", "-1"},
+			{"-listen", "24377"},
+			{"-chesscom-base-url", "ftp://api.chess.com"},
+			{"-search-movetime", "0s", "-search-depth", "0"},
+			{"-engine-options", "Threads"},
+			{"-chesscom-mode", "offline"},
+			{"-chesscom-mode", "replay"},
+			{"-lichess-base-url", "ftp://lichess.org"},
+			{"-lichess-mode", "replay"},
+		}
+
+		for _, args := range tests {
+			_, _, err := loadConfig("test", args)
+			if err == nil {
+				t.Errorf("expected error for %v, got %v", args, err)
+			}
+		}
+	})
+}
@@ -8,30 +8,48 @@ import (
 	"maps"
 	"os"
 	"path/filepath"
+	"sync"
+	"time"
 )
 
+// guards the table files
+// writers hold it exclusively, backups hold it shared to see a consistent snapshot
+var tablesMu sync.RWMutex
+
 // database struct
 type database struct {
-	TableName   string                 `json:"table_name"`
-	ContentType string                 `json:"contents"` // list or data
-	Data        map[string]interface{} `json:"data"`
+	TableName     string                 `json:"table_name"`
+	ContentType   string                 `json:"contents"` // list or data
+	SchemaVersion int                    `json:"schema_version"`
+	Data          map[string]interface{} `json:"data"`
 }
 
 // database creator function
 func newDatabase(contentType string, player string) (db database, err error) {
+	// the table must stay in the data directory, whatever a caller passed as the player
+	if !tablePlayerRegexp.MatchString(player) {
+		err = invalidInputError("invalid player %q for a table name", player)
+		return database{}, WrapError(err)
+	}
 	tableName := fmt.Sprintf("%s_%s.json", player, contentType)
 
 	db = database{
-		TableName:   tableName,
-		ContentType: contentType,
-		Data:        make(map[string]interface{}), // this needs to be refreshable
-		//                                            it's therefore intialized blank
-		//                                            and updated via
-		//                                            `readData()`
+		TableName:     tableName,
+		ContentType:   contentType,
+		SchemaVersion: schemaVersion,
+		Data:          make(map[string]interface{}), // this needs to be refreshable
+		//                                              it's therefore intialized blank
+		//                                              and updated via
+		//                                              `readData()`
 	}
 
 	err = db.readData()
 	if err != nil {
+		if errors.Is(err, errSchemaTooNew) {
+			// never hand out a table that could be overwritten with older data
+			err = fmt.Errorf("db.readData: %w", err)
+			return database{}, WrapError(err)
+		}
 		if errors.Is(err, fs.ErrNotExist) {
 			// If the file does not exist
 			fmt.Println(err)
@@ -43,12 +61,22 @@ func newDatabase(contentType string, player string) (db database, err error) {
 }
 
 func (db *database) getFilePath() string {
-	return filepath.Join("/var/lib/data", db.TableName)
+	return filepath.Join(appConfig.DataDir, db.TableName)
 }
 
 func (db *database) readData() (err error) {
-	// Initialize the data map
-	data := make(map[string]interface{})
+	tablesMu.RLock()
+	defer tablesMu.RUnlock()
+
+	return db.load()
+}
+
+// reads the table file into the database object, the caller must hold tablesMu
+func (db *database) load() (err error) {
+	defer dbReadSeconds.observeSince(time.Now(), db.ContentType)
+
+	// Initialize the raw map
+	raw := make(map[string]interface{})
 
 	// Open the file
 	file, err := os.Open(db.getFilePath())
@@ -66,42 +94,117 @@ func (db *database) readData() (err error) {
 
 	// Decode the JSON content
 	decoder := json.NewDecoder(file)
-	err = decoder.Decode(&data)
+	err = decoder.Decode(&raw)
 	if err != nil {
 		err = fmt.Errorf("decoder.Decode: %w", err)
 		return WrapError(err)
 	}
 
+	// Upgrade older tables in memory, they are persisted on the next write
+	version, data, err := decodeTable(raw)
+	if err != nil {
+		err = fmt.Errorf("decodeTable: %w", err)
+		return WrapError(err)
+	}
+
+	data, err = migrateData(db.ContentType, version, data)
+	if err != nil {
+		err = fmt.Errorf("migrateData: %w", err)
+		return WrapError(err)
+	}
+
+	db.SchemaVersion = version
 	db.Data = data
 
 	return nil
 }
 
 func (db *database) writeData(data map[string]interface{}) (err error) {
-	err = db.readData() // refresh the data in the database object
+	tablesMu.Lock()
+	defer tablesMu.Unlock()
+
+	err = db.load() // refresh the data in the database object
 	if err != nil {
-		err = fmt.Errorf("db.readData: %w", err)
+		if errors.Is(err, errSchemaTooNew) {
+			err = fmt.Errorf("db.load: %w", err)
+			return WrapError(err)
+		}
+		err = fmt.Errorf("db.load: %w", err)
 		// do not return an error
 	}
 
-	existingData := db.Data // read the data from the database object
-	maps.Copy(existingData, data)
+	maps.Copy(db.Data, data) // merge into the data in the database object
+
+	return db.save()
+}
+
+// removes keys from the table on disk
+func (db *database) deleteData(keys []string) (err error) {
+	tablesMu.Lock()
+	defer tablesMu.Unlock()
+
+	err = db.load() // refresh the data in the database object
+	if err != nil {
+		err = fmt.Errorf("db.load: %w", err)
+		return WrapError(err)
+	}
+
+	for _, key := range keys {
+		delete(db.Data, key)
+	}
+
+	return db.save()
+}
+
+// writes the whole database object to disk at the current schema version
+func (db *database) writeTable() (err error) {
+	tablesMu.Lock()
+	defer tablesMu.Unlock()
+
+	return db.save()
+}
+
+// writes the table file from the database object, the caller must hold tablesMu
+func (db *database) save() (err error) {
+	defer dbWriteSeconds.observeSince(time.Now(), db.ContentType)
+
+	db.SchemaVersion = schemaVersion
 
-	// Create the file on disk
-	file, err := os.Create(db.getFilePath())
+	// Write to a temporary file and rename it over the table,
+	// so a failed write never leaves a truncated table behind
+	file, err := os.CreateTemp(appConfig.DataDir, "."+db.TableName+".*")
 	if err != nil {
-		err = fmt.Errorf("os.Create: %w", err)
+		err = fmt.Errorf("os.CreateTemp: %w", err)
 		return WrapError(err)
 	}
+	defer os.Remove(file.Name()) // no-op once renamed
 	defer file.Close()
 
+	err = file.Chmod(0644)
+	if err != nil {
+		err = fmt.Errorf("file.Chmod: %w", err)
+		return WrapError(err)
+	}
+
 	encoder := json.NewEncoder(file)
 	encoder.SetIndent("", "  ")
-	err = encoder.Encode(existingData)
+	err = encoder.Encode(db)
 	if err != nil {
 		err = fmt.Errorf("encoder.Encode: %w", err)
 		return WrapError(err)
 	}
 
+	err = file.Close()
+	if err != nil {
+		err = fmt.Errorf("file.Close: %w", err)
+		return WrapError(err)
+	}
+
+	err = os.Rename(file.Name(), db.getFilePath())
+	if err != nil {
+		err = fmt.Errorf("os.Rename: %w", err)
+		return WrapError(err)
+	}
+
 	return nil
 }
@@ -0,0 +1,403 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"chess-analyzer/fakechesscom"
+
+	"github.com/notnil/chess"
+)
+
+// set in the environment of the test binary when it is started as a UCI engine
+const fakeEngineEnv = "CHESS_ANALYZER_TEST_FAKE_ENGINE"
+
+func TestMain(m *testing.M) {
+	if os.Getenv(fakeEngineEnv) == "1" {
+		runFakeEngine(os.Stdin, os.Stdout)
+		os.Exit(0)
+	}
+
+	// engines started by the tests are this binary, see fakeEngineConfig
+	os.Setenv(fakeEngineEnv, "1")
+	os.Exit(m.Run())
+}
+
+// a minimal UCI engine: the best move is always the first legal move in UCI order
+// (in Chess960, castling is never the best move)
+func runFakeEngine(in io.Reader, out io.Writer) {
+	variant := variantChess
+	pos := chess.StartingPosition()
+	scanner := bufio.NewScanner(in)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		switch fields[0] {
+		case "uci":
+			fmt.Fprintln(out, "id name fake")
+			fmt.Fprintln(out, "id author chess-analyzer")
+			fmt.Fprintln(out, "uciok")
+		case "setoption":
+			if strings.Join(fields[1:], " ") == "name UCI_Chess960 value true" {
+				variant = variantChess960
+			}
+		case "isready":
+			fmt.Fprintln(out, "readyok")
+		case "ucinewgame":
+			pos = chess.StartingPosition()
+		case "position":
+			pos = fakeEnginePosition(fields[1:], variant)
+		case "go":
+			moves := pos.ValidMoves()
+			if len(moves) == 0 {
+				fmt.Fprintln(out, "bestmove (none)")
+				continue
+			}
+			var uci []string
+			for _, move := range moves {
+				uci = append(uci, chess.UCINotation{}.Encode(pos, move))
+			}
+			// searchmoves restricts the search to the moves listed after it
+			for i, field := range fields {
+				if field == "searchmoves" {
+					uci = fields[i+1:]
+				}
+			}
+			sort.Strings(uci)
+			fmt.Fprintf(out, "info depth 1 score cp 0 nodes 1 time 1 pv %s\n", uci[0])
+			fmt.Fprintf(out, "bestmove %s\n", uci[0])
+		case "quit":
+			return
+		}
+	}
+}
+
+// parses the arguments of "position": startpos or fen, then optional moves
+func fakeEnginePosition(args []string, variant string) (pos *chess.Position) {
+	gp, _ := newGamePosition(variant, standardFEN)
+	if len(args) > 0 && args[0] == "fen" {
+		end := len(args)
+		for i, arg := range args {
+			if arg == "moves" {
+				end = i
+				break
+			}
+		}
+		fen, err := newGamePosition(variant, strings.Join(args[1:end], " "))
+		if err == nil {
+			gp = fen
+		}
+		args = args[end:]
+	}
+	if len(args) > 0 && args[0] == "moves" {
+		for _, s := range args[1:] {
+			move, err := gp.decodeMove(s)
+			if err != nil {
+				break
+			}
+			gp = gp.update(move)
+		}
+	}
+	return gp.position
+}
+
+// a config using the fake engine and the fake chess.com
+func fakeEngineConfig(t *testing.T, upstream *fakechesscom.Server) config {
+	c := defaultConfig()
+	c.DataDir = t.TempDir()
+	c.Engine.Path = os.Args[0]
+	c.Search.MoveTime = duration(time.Millisecond)
+	c.Workers = 2
+	if upstream != nil {
+		c.ChessCom.BaseURL = upstream.BaseURL()
+	}
+	for _, u := range []*upstreamConfig{&c.ChessCom, &c.Lichess} {
+		u.MinInterval = 0
+		u.MaxRetries = 1
+		u.Backoff = duration(time.Millisecond)
+		u.MaxBackoff = duration(10 * time.Millisecond)
+	}
+	return c
+}
+
+// makes a request to the API, returning the status and body
+func apiRequest(t *testing.T, method string, url string) (status int, body string) {
+	req, err := http.NewRequest(method, url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	validateAPIResponse(t, method, url, resp.StatusCode, resp.Header, data)
+	return resp.StatusCode, string(data)
+}
+
+func TestEndToEnd(t *testing.T) {
+	upstream := fakechesscom.New()
+	defer upstream.Close()
+	err := upstream.LoadFixtures("testdata")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	setConfig(fakeEngineConfig(t, upstream))
+	defer setConfig(defaultConfig())
+
+	api := httptest.NewServer(newRouter())
+	defer api.Close()
+
+	uuid := "8e1f2c3a-0001-11ef-8000-000000000001"
+
+	type testCase struct {
+		// Input Params
+		method string
+		path   string
+		// Expected Values
+		status int
+		body   string // the response must contain this
+	}
+
+	t.Run("refresh and analyze", func(t *testing.T) {
+		tests := []testCase{
+			{"GET", "/api/asdf", 200, "{}"},
+			{"POST", "/api/asdf", 200, "Archive List Updated"},
+			{"POST", "/api/asdf", 200, "Archive List Unchanged"},
+			{"GET", "/api/asdf", 200, `"2025-02": false`},
+			{"POST", "/api/asdf/2025-02", 200, "Archive Data Updated (3 games added)"},
+			{"POST", "/api/asdf/2025-02", 200, "Archive Data Unchanged"},
+			{"GET", "/api/asdf", 200, `"2025-02": true`},
+			{"GET", "/api/asdf/2025-02?analyzed=false", 200, `"total": 3`},
+			{"POST", "/api/asdf/2025-02/" + uuid, 200, "Result Updated"},
+			{"GET", "/api/asdf/2025-02?analyzed=true", 200, `"uuid": "` + uuid + `"`},
+			{"GET", "/api/asdf/2025-02/" + uuid, 200, `"uuid": "` + uuid + `"`},
+			{"GET", "/api/asdf/2025-02/" + uuid + ".pgn", 200, `[AnalysisEngine "fake"]`},
+			{"GET", "/api/asdf/2025-02/" + uuid + ".pgn", 200, "[%eval 0.00]"},
+			{"GET", "/api/asdf/2025-02.pgn", 200, `[Annotator "chess-analyzer"]`},
+			{"GET", "/api/asdf/2025-01.pgn", 404, "not stored"},
+			{"GET", "/api/asdf/search?result=draw", 200, "8e1f2c3a-0002-11ef-8000-000000000002"},
+			{"POST", "/api/nobody", 404, `"code":"not_found"`},
+		}
+
+		for _, test := range tests {
+			status, body := apiRequest(t, test.method, api.URL+test.path)
+			if status != test.status {
+				t.Errorf("%s %s: expected %v, got %v (%s)", test.method, test.path, test.status, status, body)
+			}
+			if !strings.Contains(body, test.body) {
+				t.Errorf("%s %s: expected %q in %q", test.method, test.path, test.body, body)
+			}
+		}
+	})
+
+	t.Run("games by UUID", func(t *testing.T) {
+		tests := []testCase{
+			{"GET", "/api/games/" + uuid, 200, `"uuid": "` + uuid + `"`},
+			{"GET", "/api/games/8e1f2c3a-0002-11ef-8000-000000000002", 200, `"date": "2025-02-10T12:00:00Z"`},
+			{"GET", "/api/games/" + uuid + ".pgn", 200, `[WhiteAccuracy "`},
+			{"POST", "/api/games/8e1f2c3a-0002-11ef-8000-000000000002", 200, "Result Updated"},
+			{"GET", "/api/asdf/2025-02?analyzed=true", 200, `"total": 2`},
+			{"GET", "/api/games/8e1f2c3a-0009-11ef-8000-000000000009", 404, "not found"},
+			{"GET", "/api/games/not-a-game", 400, "invalid game ID"},
+			// games is not a player
+			{"GET", "/api/games", 400, "reserved"},
+			{"GET", "/api/games/search", 400, "reserved"},
+			{"GET", "/api/games/2025-02/8e1f2c3a-0002-11ef-8000-000000000002", 400, "reserved"},
+		}
+
+		for _, test := range tests {
+			status, body := apiRequest(t, test.method, api.URL+test.path)
+			if status != test.status {
+				t.Errorf("%s %s: expected %v, got %v (%s)", test.method, test.path, test.status, status, body)
+			}
+			if !strings.Contains(body, test.body) {
+				t.Errorf("%s %s: expected %q in %q", test.method, test.path, test.body, body)
+			}
+		}
+	})
+
+	t.Run("path parameters", func(t *testing.T) {
+		tests := []testCase{
+			// players are looked up in lowercase
+			{"GET", "/api/ASDF/2025-02/" + uuid, 200, `"uuid": "` + uuid + `"`},
+			{"GET", "/api/asdf/2025-02/8e1f2c3a-0009-11ef-8000-000000000009", 404, "not found"},
+			{"GET", "/api/asdf/2025", 400, "expected YYYY-MM"},
+			{"GET", "/api/asdf/2025-13", 400, "the month must be between 01 and 12"},
+			{"GET", "/api/asdf/1999-01", 400, "the year must be between"},
+			{"GET", "/api/asdf/2025-02/not-a-uuid", 400, "invalid chess.com game ID"},
+			{"GET", "/api/..%2F..%2Fetc%2Fpasswd", 400, "invalid player"},
+			{"POST", "/api/a%2Fb/2025-02", 400, "invalid player"},
+		}
+
+		for _, test := range tests {
+			status, body := apiRequest(t, test.method, api.URL+test.path)
+			if status != test.status {
+				t.Errorf("%s %s: expected %v, got %v (%s)", test.method, test.path, test.status, status, body)
+			}
+			if !strings.Contains(body, test.body) {
+				t.Errorf("%s %s: expected %q in %q", test.method, test.path, test.body, body)
+			}
+		}
+	})
+
+	t.Run("ranges of months", func(t *testing.T) {
+		tests := []testCase{
+			{"GET", "/api/asdf/games?from=2024-11&to=2025-03", 200, `"total": 3`},
+			{"GET", "/api/asdf/games?from=2025-03", 200, `"total": 0`},
+			{"POST", "/api/asdf/games?from=2025-01&to=2025-02", 200, `"games_added": 0`},
+			{"GET", "/api/asdf/games?colour=black", 200, `"archives": [
+    "2025-01",
+    "2025-02"
+  ]`},
+			{"GET", "/api/asdf/games?colour=black", 200, `"total": 1`},
+			{"GET", "/api/asdf/games?from=2025-03&to=2025-01", 400, "is after"},
+			{"GET", "/api/asdf/games?to=2025-13", 400, "the month must be between 01 and 12"},
+			{"POST", "/api/asdf/games?from=2025", 400, "expected YYYY-MM"},
+			{"POST", "/api/asdf/games", 400, "needs from or to"},
+			{"GET", "/api/nobody/games", 404, "no archives of nobody are stored"},
+		}
+
+		for _, test := range tests {
+			status, body := apiRequest(t, test.method, api.URL+test.path)
+			if status != test.status {
+				t.Errorf("%s %s: expected %v, got %v (%s)", test.method, test.path, test.status, status, body)
+			}
+			if !strings.Contains(body, test.body) {
+				t.Errorf("%s %s: expected %q in %q", test.method, test.path, test.body, body)
+			}
+		}
+	})
+
+	t.Run("analysis is stored", func(t *testing.T) {
+		db, err := newDatabase("analysis", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		record, ok := db.Data[uuid].(map[string]interface{})
+		if !ok {
+			t.Fatalf("expected an analysis of %s, got %v", uuid, db.Data)
+		}
+		moves, _ := record["moves"].(map[string]interface{})
+		if len(moves) != 7 {
+			t.Errorf("expected %v, got %v", 7, len(moves))
+		}
+	})
+
+	t.Run("upstream errors", func(t *testing.T) {
+		// retried once, then reported
+		upstream.FailNext("/player/asdf/games/2025/01", http.StatusServiceUnavailable, 2)
+		status, _ := apiRequest(t, "POST", api.URL+"/api/asdf/2025-01")
+		if status != 502 {
+			t.Errorf("expected %v, got %v", 502, status)
+		}
+
+		// a single failure is absorbed by the retry
+		upstream.FailNext("/player/asdf/games/2025/01", http.StatusBadGateway, 1)
+		status, body := apiRequest(t, "POST", api.URL+"/api/asdf/2025-01")
+		if status != 200 {
+			t.Errorf("expected %v, got %v (%s)", 200, status, body)
+		}
+	})
+
+	t.Run("upstream latency", func(t *testing.T) {
+		c := appConfig
+		c.ChessCom.Timeout = duration(20 * time.Millisecond)
+		setConfig(c)
+		upstream.SetLatency(100 * time.Millisecond)
+		defer upstream.SetLatency(0)
+
+		status, _ := apiRequest(t, "POST", api.URL+"/api/asdf")
+		if status != 502 {
+			t.Errorf("expected %v, got %v", 502, status)
+		}
+	})
+
+	t.Run("requests made upstream", func(t *testing.T) {
+		seen := make(map[string]bool)
+		for _, path := range upstream.Requests() {
+			seen[path] = true
+		}
+		expected := map[string]bool{
+			"/pub/player/asdf/games/archives":   true,
+			"/pub/player/asdf/games/2025/01":    true,
+			"/pub/player/asdf/games/2025/02":    true,
+			"/pub/player/nobody/games/archives": true,
+		}
+		if !reflect.DeepEqual(seen, expected) {
+			t.Errorf("expected %v, got %v", expected, seen)
+		}
+	})
+}
+
+// the fake chess.com serves what it was given, with ETags
+func TestFakeChessCom(t *testing.T) {
+	upstream := fakechesscom.New()
+	defer upstream.Close()
+	err := upstream.LoadFixtures("testdata")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := defaultConfig().ChessCom
+	c.MinInterval = 0
+	c.MaxRetries = 0
+	client := newChessComClient(c)
+
+	type testCase struct {
+		// Input Params
+		path string
+		// Expected Values
+		key   string // a key of the response
+		isErr bool
+	}
+
+	t.Run("fake chess.com", func(t *testing.T) {
+		tests := []testCase{
+			{"/player/asdf", "username", false},
+			{"/player/ASDF/stats", "chess_rapid", false},
+			{"/player/asdf/games/archives", "archives", false},
+			{"/player/asdf/games/2025/02", "games", false},
+			{"/player/asdf/games/2024/02", "", true},
+			{"/player/nobody", "", true},
+		}
+
+		for _, test := range tests {
+			data, err := client.getJSON(upstream.BaseURL() + test.path)
+			if (err != nil) != test.isErr {
+				t.Errorf("%s: expected error %v, got %v", test.path, test.isErr, err)
+			}
+			if _, ok := data[test.key]; !test.isErr && !ok {
+				t.Errorf("%s: expected %q in %v", test.path, test.key, data)
+			}
+		}
+	})
+
+	var archives struct {
+		Archives []string `json:"archives"`
+	}
+	data, _ := client.getJSON(upstream.BaseURL() + "/player/asdf/games/archives")
+	raw, _ := json.Marshal(data)
+	json.Unmarshal(raw, &archives)
+	expected := []string{upstream.BaseURL() + "/player/asdf/games/2025/01", upstream.BaseURL() + "/player/asdf/games/2025/02"}
+	if !reflect.DeepEqual(archives.Archives, expected) {
+		t.Errorf("expected %v, got %v", expected, archives.Archives)
+	}
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+)
+
+// The kinds of error the API tells apart. An error is given its kind where it
+// happens, the kind survives fmt.Errorf("...: %w") and WrapError, and
+// appHandler answers with the kind's status and a JSON body. Errors of no
+// kind are internal errors.
+
+type errorKind string
+
+const (
+	kindInternal            errorKind = "internal"
+	kindNotFound            errorKind = "not_found"
+	kindInvalidInput        errorKind = "invalid_input"
+	kindUpstreamUnavailable errorKind = "upstream_unavailable"
+	kindEngineUnavailable   errorKind = "engine_unavailable"
+	kindConflict            errorKind = "conflict"
+)
+
+// the HTTP status each kind is answered with
+var kindStatus = map[errorKind]int{
+	kindInternal:            http.StatusInternalServerError,
+	kindNotFound:            http.StatusNotFound,
+	kindInvalidInput:        http.StatusBadRequest,
+	kindUpstreamUnavailable: http.StatusBadGateway,
+	kindEngineUnavailable:   http.StatusServiceUnavailable,
+	kindConflict:            http.StatusConflict,
+}
+
+// an error of a kind, its message is the one shown to API clients
+type kindError struct {
+	Kind errorKind
+	Err  error
+}
+
+func (e *kindError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *kindError) Unwrap() error {
+	return e.Err
+}
+
+// gives err a kind, unless it already has one: the kind closest to where the
+// error happened is the most precise
+func withKind(kind errorKind, err error) error {
+	var ke *kindError
+	if err == nil || errors.As(err, &ke) {
+		return err
+	}
+	return &kindError{Kind: kind, Err: err}
+}
+
+func notFoundError(format string, args ...interface{}) error {
+	return withKind(kindNotFound, fmt.Errorf(format, args...))
+}
+
+func invalidInputError(format string, args ...interface{}) error {
+	return withKind(kindInvalidInput, fmt.Errorf(format, args...))
+}
+
+func upstreamUnavailableError(format string, args ...interface{}) error {
+	return withKind(kindUpstreamUnavailable, fmt.Errorf(format, args...))
+}
+
+func engineUnavailableError(format string, args ...interface{}) error {
+	return withKind(kindEngineUnavailable, fmt.Errorf(format, args...))
+}
+
+func conflictError(format string, args ...interface{}) error {
+	return withKind(kindConflict, fmt.Errorf(format, args...))
+}
+
+// the kind of err, and the message of the error that was given the kind
+func errorKindOf(err error) (kind errorKind, message string) {
+	var ke *kindError
+	if errors.As(err, &ke) {
+		return ke.Kind, ke.Err.Error()
+	}
+	return kindInternal, err.Error()
+}
+
+// the body of every error response
+type apiError struct {
+	Code      errorKind `json:"code"`
+	Message   string    `json:"message"`
+	RequestID string    `json:"request_id"`
+}
+
+// writes the error response for err, internal errors only say so, the details
+// are in the log under the request ID
+func writeError(w http.ResponseWriter, requestID string, err error) {
+	kind, message := errorKindOf(err)
+	status := kindStatus[kind]
+	if kind == kindInternal {
+		message = http.StatusText(status)
+	}
+
+	errorLog.Printf("Request %s failed with %d: %v", requestID, status, err)
+
+	data, _ := json.Marshal(apiError{Code: kind, Message: message, RequestID: requestID})
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	w.Write(data)
+}
+
+// request IDs passed in by a client or proxy are kept when they are this tame
+var requestIDRegexp = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// the request ID of r, from the X-Request-ID header or a new random one
+func requestID(r *http.Request) string {
+	if id := r.Header.Get("X-Request-ID"); requestIDRegexp.MatchString(id) {
+		return id
+	}
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestErrorKindOf(t *testing.T) {
+	type testCase struct {
+		// Input Params
+		err error
+		// Expected Values
+		kind    errorKind
+		message string
+	}
+
+	notFound := notFoundError("game with UUID %s not found", "x")
+	tests := []testCase{
+		{fmt.Errorf("plain"), kindInternal, "plain"},
+		// the kind and its message survive wrapping and logging
+		{WrapError(fmt.Errorf("createResult: %w", WrapError(notFound))), kindNotFound, "game with UUID x not found"},
+		{invalidInputError("depth must not be negative, got %d", -1), kindInvalidInput, "depth must not be negative, got -1"},
+		// the kind given first is kept
+		{withKind(kindInvalidInput, fmt.Errorf("stageBackup: %w", conflictError("%w: found 3", errSchemaTooNew))), kindConflict, "schema version is newer than this binary supports: found 3"},
+		{upstreamError(&upstreamStatusError{URL: "u", StatusCode: http.StatusNotFound}), kindNotFound, "u: unexpected status 404 Not Found"},
+		{upstreamError(&upstreamStatusError{URL: "u", StatusCode: http.StatusServiceUnavailable}), kindUpstreamUnavailable, "u: unexpected status 503 Service Unavailable"},
+		{upstreamError(fmt.Errorf("timeout")), kindUpstreamUnavailable, "timeout"},
+		{engineUnavailableError("uci.New: %w", fmt.Errorf("no such file")), kindEngineUnavailable, "uci.New: no such file"},
+	}
+
+	for _, test := range tests {
+		kind, message := errorKindOf(test.err)
+		if kind != test.kind || message != test.message {
+			t.Errorf("%v: expected %v %q, got %v %q", test.err, test.kind, test.message, kind, message)
+		}
+	}
+
+	// the sentinel is still there for errors.Is
+	if err := conflictError("%w: found 3", errSchemaTooNew); !errors.Is(err, errSchemaTooNew) {
+		t.Errorf("expected %v in %v", errSchemaTooNew, err)
+	}
+}
+
+func TestAppHandlerErrors(t *testing.T) {
+	type testCase struct {
+		// Input Params
+		err       error
+		requestID string
+		// Expected Values
+		status  int
+		code    errorKind
+		message string
+	}
+
+	tests := []testCase{
+		{notFoundError("no profile stored for %s", "asdf"), "abc-123", 404, kindNotFound, "no profile stored for asdf"},
+		{invalidInputError("bad"), "", 400, kindInvalidInput, "bad"},
+		{upstreamUnavailableError("down"), "", 502, kindUpstreamUnavailable, "down"},
+		{engineUnavailableError("gone"), "", 503, kindEngineUnavailable, "gone"},
+		{conflictError("newer"), "", 409, kindConflict, "newer"},
+		// internal details stay in the log, and request IDs must be tame to be kept
+		{fmt.Errorf("os.Open: /data/secret"), "not tame\n", 500, kindInternal, "Internal Server Error"},
+	}
+
+	for _, test := range tests {
+		handler := appHandler(func(w http.ResponseWriter, r *http.Request) error {
+			return WrapError(fmt.Errorf("handler: %w", test.err))
+		})
+		req := httptest.NewRequest("GET", "/api/asdf", nil)
+		if test.requestID != "" {
+			req.Header.Set("X-Request-ID", test.requestID)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		var body apiError
+		err := json.Unmarshal(rec.Body.Bytes(), &body)
+		if err != nil {
+			t.Fatalf("%v: %v (%s)", test.err, err, rec.Body)
+		}
+		if rec.Code != test.status || body.Code != test.code || body.Message != test.message {
+			t.Errorf("%v: expected %v %v %q, got %v %v %q", test.err, test.status, test.code, test.message, rec.Code, body.Code, body.Message)
+		}
+		if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+			t.Errorf("%v: expected %v, got %v", test.err, "application/json", ct)
+		}
+
+		id := rec.Header().Get("X-Request-ID")
+		if id == "" || body.RequestID != id {
+			t.Errorf("%v: expected the request ID %q in the body, got %q", test.err, id, body.RequestID)
+		}
+		if test.requestID == "abc-123" && id != test.requestID {
+			t.Errorf("expected %v, got %v", test.requestID, id)
+		}
+		if test.requestID == "not tame\n" && id == test.requestID {
+			t.Errorf("expected a new request ID, got %q", id)
+		}
+	}
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Game listings as CSV or NDJSON, for spreadsheets and notebooks, with one
+// row per game from the player's point of view. The format is chosen by
+// ?format=, or failing that the Accept header, and defaults to JSON. Exports
+// are streamed: each row is built from the stored game and written to the
+// response before the next, rather than the listing being marshalled whole.
+// Unlike JSON pages, an export lists every matching game unless it is given
+// a limit, and then the cursor of the next page is in the X-Next-Cursor
+// header.
+
+const (
+	formatJSON   = "json"
+	formatCSV    = "csv"
+	formatNDJSON = "ndjson"
+)
+
+// the media types of the Accept header each format answers to, JSON being
+// what any type is served as
+var formatMediaTypes = map[string]string{
+	"application/json":     formatJSON,
+	"text/csv":             formatCSV,
+	"application/x-ndjson": formatNDJSON,
+	"application/ndjson":   formatNDJSON,
+	"application/*":        formatJSON,
+	"*/*":                  formatJSON,
+}
+
+var listingCSVHeader = []string{
+	"uuid", "source", "archive", "date", "colour", "opponent", "player_rating", "opponent_rating",
+	"result", "outcome", "time_class", "time_control", "rated", "eco", "opening", "analyzed",
+	"player_accuracy", "opponent_accuracy",
+	"player_inaccuracies", "player_mistakes", "player_blunders",
+	"opponent_inaccuracies", "opponent_mistakes", "opponent_blunders",
+}
+
+// reads the listing query of a request and the format it asks for
+func newListingRequest(r *http.Request) (q listingQuery, format string, err error) {
+	format, err = negotiateFormat(r)
+	if err != nil {
+		err = fmt.Errorf("negotiateFormat: %w", err)
+		return listingQuery{}, "", WrapError(err)
+	}
+
+	values := r.URL.Query()
+	q, err = newListingQuery(values)
+	if err != nil {
+		err = fmt.Errorf("newListingQuery: %w", err)
+		return listingQuery{}, "", WrapError(err)
+	}
+	if format != formatJSON && values.Get("limit") == "" {
+		q.Limit = 0
+	}
+
+	return q, format, nil
+}
+
+// the format of the response: ?format=, else the media type of the Accept
+// header with the highest q-value that has one, the first of equals, else JSON
+func negotiateFormat(r *http.Request) (format string, err error) {
+	if format = r.URL.Query().Get("format"); format != "" {
+		if format != formatJSON && format != formatCSV && format != formatNDJSON {
+			err = invalidInputError("format must be json, csv or ndjson, got %q", format)
+			return "", WrapError(err)
+		}
+		return format, nil
+	}
+
+	format = formatJSON
+	best := 0.0
+	for _, accepted := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(accepted))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if value, ok := params["q"]; ok {
+			q, err = strconv.ParseFloat(value, 64)
+			if err != nil {
+				continue
+			}
+		}
+		// q=0 means not acceptable
+		if f, ok := formatMediaTypes[mediaType]; ok && q > best {
+			format, best = f, q
+		}
+	}
+
+	return format, nil
+}
+
+// writes a listing in format, JSON pages as they always were
+func writeListing(w http.ResponseWriter, gl gameListing, format string) (err error) {
+	// the same URL answers in several formats
+	w.Header().Set("Vary", "Accept")
+
+	switch format {
+	case formatCSV:
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	case formatNDJSON:
+		w.Header().Set("Content-Type", "application/x-ndjson")
+	default:
+		err = gl.fillGames()
+		if err != nil {
+			err = fmt.Errorf("gl.fillGames: %w", err)
+			return WrapError(err)
+		}
+		data, err := gl.prettyPrint()
+		if err != nil {
+			err = fmt.Errorf("gl.prettyPrint: %w", err)
+			return WrapError(err)
+		}
+		// Write the JSON response
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(data))
+		return nil
+	}
+
+	if gl.NextCursor != "" {
+		w.Header().Set("X-Next-Cursor", gl.NextCursor)
+	}
+	if format == formatCSV {
+		err = writeListingCSV(w, gl)
+	} else {
+		err = writeListingNDJSON(w, gl)
+	}
+	if err != nil {
+		// the status and part of the body are sent, an error body would only
+		// corrupt the rows, so the error is logged and the response cut short
+		err = fmt.Errorf("writeListing(%s): %w", format, err)
+		WrapError(err)
+	}
+
+	return nil
+}
+
+func writeListingCSV(w io.Writer, gl gameListing) (err error) {
+	cw := csv.NewWriter(w)
+	err = cw.Write(listingCSVHeader)
+	if err != nil {
+		err = fmt.Errorf("cw.Write: %w", err)
+		return WrapError(err)
+	}
+	err = gl.eachGame(func(e gameListEntry) error {
+		err := cw.Write(e.csvRow())
+		if err != nil {
+			err = fmt.Errorf("cw.Write: %w", err)
+			return WrapError(err)
+		}
+		return nil
+	})
+	if err != nil {
+		err = fmt.Errorf("gl.eachGame: %w", err)
+		return WrapError(err)
+	}
+	cw.Flush()
+
+	err = cw.Error()
+	if err != nil {
+		err = fmt.Errorf("cw.Flush: %w", err)
+		return WrapError(err)
+	}
+	return nil
+}
+
+func writeListingNDJSON(w io.Writer, gl gameListing) (err error) {
+	// Encode ends every value with a newline
+	enc := json.NewEncoder(w)
+	err = gl.eachGame(func(e gameListEntry) error {
+		err := enc.Encode(e)
+		if err != nil {
+			err = fmt.Errorf("enc.Encode: %w", err)
+			return WrapError(err)
+		}
+		return nil
+	})
+	if err != nil {
+		err = fmt.Errorf("gl.eachGame: %w", err)
+		return WrapError(err)
+	}
+	return nil
+}
+
+// the CSV row of a game, in the order of listingCSVHeader, the analysis columns
+// are empty until the game is analyzed
+func (e gameListEntry) csvRow() []string {
+	number := func(f float64) string {
+		return strconv.FormatFloat(f, 'f', -1, 64)
+	}
+
+	row := []string{
+		e.UUID, e.Source, e.Archive, e.Date.UTC().Format(time.RFC3339), e.Colour, e.Opponent,
+		number(e.PlayerRating), number(e.OpponentRating),
+		e.Result, e.Outcome, e.TimeClass, e.TimeControl, strconv.FormatBool(e.Rated), e.ECO, e.Opening,
+		strconv.FormatBool(e.Analyzed),
+	}
+
+	if e.Accuracy == nil {
+		row = append(row, "", "")
+	} else if e.Colour == "black" {
+		row = append(row, number(e.Accuracy.Black), number(e.Accuracy.White))
+	} else {
+		row = append(row, number(e.Accuracy.White), number(e.Accuracy.Black))
+	}
+
+	if e.Classifications == nil {
+		return append(row, "", "", "", "", "", "")
+	}
+	player, opponent := e.Classifications.White, e.Classifications.Black
+	if e.Colour == "black" {
+		player, opponent = opponent, player
+	}
+	for _, counts := range []classificationCounts{player, opponent} {
+		row = append(row, strconv.Itoa(counts.Inaccuracies), strconv.Itoa(counts.Mistakes), strconv.Itoa(counts.Blunders))
+	}
+	return row
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestNegotiateFormat(t *testing.T) {
+	type testCase struct {
+		// Input Params
+		query  string
+		accept string
+		// Expected Values
+		format string
+		ok     bool
+	}
+
+	tests := []testCase{
+		{"", "", formatJSON, true},
+		{"", "*/*", formatJSON, true},
+		{"", "text/csv", formatCSV, true},
+		{"", "text/html, text/csv;q=0.9", formatCSV, true},
+		{"", "application/x-ndjson", formatNDJSON, true},
+		{"", "application/ndjson; charset=utf-8", formatNDJSON, true},
+		{"", "text/html", formatJSON, true},
+		// the highest q-value wins, then the first listed
+		{"", "text/csv;q=0.1, application/json", formatJSON, true},
+		{"", "application/json;q=0.5, application/x-ndjson;q=0.8", formatNDJSON, true},
+		{"", "text/csv, application/x-ndjson", formatCSV, true},
+		{"", "text/csv;q=0.5, */*", formatJSON, true},
+		{"", "text/csv;q=0", formatJSON, true},
+		// the query wins over the header
+		{"format=ndjson", "text/csv", formatNDJSON, true},
+		{"format=json", "text/csv", formatJSON, true},
+		{"format=xlsx", "", "", false},
+	}
+
+	for _, test := range tests {
+		r := httptest.NewRequest("GET", "/api/asdf/2025-02?"+test.query, nil)
+		if test.accept != "" {
+			r.Header.Set("Accept", test.accept)
+		}
+		format, err := negotiateFormat(r)
+		if (err == nil) != test.ok || format != test.format {
+			t.Errorf("%q %q: expected %q %v, got %q %v", test.query, test.accept, test.format, test.ok, format, err)
+		}
+	}
+}
+
+func TestListingExport(t *testing.T) {
+	appConfig.DataDir = t.TempDir()
+	defer func() { appConfig = defaultConfig() }()
+
+	ad := fixtureArchiveData(t, "asdf", "2025-02", "testdata/asdf_2025_02.json")
+	archiveDB, _ := newDatabase("archive_data", "asdf")
+	err := archiveDB.writeData(ad.ArchiveData)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// asdf is white in the first game: a mistake of theirs, two blunders and an inaccuracy of the opponent's
+	analysed := "8e1f2c3a-0001-11ef-8000-000000000001"
+	classified := func(classification string) map[string]interface{} {
+		return map[string]interface{}{"classification": classification}
+	}
+	db, _ := newDatabase("analysis", "")
+	err = db.writeData(map[string]interface{}{
+		analysed: map[string]interface{}{
+			"accuracy":  map[string]interface{}{"white": 0.75, "black": 0.5},
+			"evaluated": true,
+			"moves": map[string]interface{}{
+				"01.":   classified("best"),
+				"01...": classified("blunder"),
+				"02.":   classified("mistake"),
+				"02...": classified("inaccuracy"),
+				"03.":   classified("good"),
+				"03...": classified("blunder"),
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mux := newRouter()
+	get := func(target string, accept string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", target, nil)
+		if accept != "" {
+			r.Header.Set("Accept", accept)
+		}
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, r)
+		validateAPIResponse(t, "GET", target, w.Code, w.Header(), w.Body.Bytes())
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: expected %v, got %v (%s)", target, http.StatusOK, w.Code, w.Body)
+		}
+		return w
+	}
+
+	t.Run("csv", func(t *testing.T) {
+		w := get("/api/asdf/2025-02?sort=date", "text/csv")
+		if ct := w.Header().Get("Content-Type"); ct != "text/csv; charset=utf-8" {
+			t.Errorf("expected %v, got %v", "text/csv; charset=utf-8", ct)
+		}
+		rows, err := csv.NewReader(w.Body).ReadAll()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(rows) != 4 || strings.Join(rows[0], ",") != strings.Join(listingCSVHeader, ",") {
+			t.Fatalf("expected a header and %v rows, got %v", 3, rows)
+		}
+
+		column := func(row []string, name string) string {
+			for i, header := range listingCSVHeader {
+				if header == name {
+					return row[i]
+				}
+			}
+			return ""
+		}
+		expected := map[string]string{
+			"uuid": analysed, "colour": "white", "analyzed": "true",
+			"player_accuracy": "0.75", "opponent_accuracy": "0.5",
+			"player_inaccuracies": "0", "player_mistakes": "1", "player_blunders": "0",
+			"opponent_inaccuracies": "1", "opponent_mistakes": "0", "opponent_blunders": "2",
+		}
+		for name, value := range expected {
+			if actual := column(rows[1], name); actual != value {
+				t.Errorf("%s: expected %q, got %q", name, value, actual)
+			}
+		}
+		if actual := column(rows[2], "player_accuracy"); actual != "" {
+			t.Errorf("expected no accuracy before the analysis, got %q", actual)
+		}
+	})
+
+	t.Run("ndjson", func(t *testing.T) {
+		w := get("/api/asdf/games?format=ndjson&analyzed=true", "")
+		var entries []gameListEntry
+		scanner := bufio.NewScanner(w.Body)
+		for scanner.Scan() {
+			var e gameListEntry
+			err := json.Unmarshal(scanner.Bytes(), &e)
+			if err != nil {
+				t.Fatal(err)
+			}
+			entries = append(entries, e)
+		}
+		if len(entries) != 1 || entries[0].Classifications == nil || entries[0].Classifications.Black.Blunders != 2 {
+			t.Errorf("expected the analyzed game with %v blunders by black, got %+v", 2, entries)
+		}
+	})
+
+	t.Run("pages with a limit", func(t *testing.T) {
+		w := get("/api/asdf/2025-02?format=ndjson&limit=2", "")
+		if lines := strings.Count(w.Body.String(), "\n"); lines != 2 {
+			t.Errorf("expected %v lines, got %v", 2, lines)
+		}
+		cursor := w.Header().Get("X-Next-Cursor")
+		if cursor == "" {
+			t.Fatalf("expected a cursor")
+		}
+
+		w = get("/api/asdf/2025-02?format=ndjson&limit=2&cursor="+cursor, "")
+		if lines := strings.Count(w.Body.String(), "\n"); lines != 1 || w.Header().Get("X-Next-Cursor") != "" {
+			t.Errorf("expected the last line, got %q", w.Body)
+		}
+	})
+	t.Run("rows are written one at a time", func(t *testing.T) {
+		gl, err := newGameListing(ad, listingQuery{Sort: "date"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		// each row reaches the writer before the next is built, so a failed
+		// write stops the export
+		w := &failingWriter{n: 1}
+		err = writeListingNDJSON(w, gl)
+		if err == nil || w.writes != 2 {
+			t.Errorf("expected an error on the second of %v rows, got %v after %v writes", 3, err, w.writes)
+		}
+	})
+}
+
+// a writer that fails every write after the first n
+type failingWriter struct {
+	n      int
+	writes int
+}
+
+func (w *failingWriter) Write(p []byte) (int, error) {
+	w.writes++
+	if w.writes > w.n {
+		return 0, io.ErrClosedPipe
+	}
+	return len(p), nil
+}
@@ -0,0 +1,247 @@
+// Package fakechesscom is a local stand-in for the chess.com published-data API,
+// for tests that need an upstream without touching the network.
+//
+// It serves archive lists and monthly archives, player profiles and stats,
+// answers conditional requests with 304 like chess.com does, and can be told
+// to fail or slow down requests.
+package fakechesscom
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// the fake serves the API under this prefix, as api.chess.com does
+const basePath = "/pub"
+
+// Server is a running fake chess.com.
+type Server struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	archives map[string]map[string][]byte // player -> "YYYY/MM" -> archive JSON
+	profiles map[string][]byte            // player -> profile JSON
+	stats    map[string][]byte            // player -> stats JSON
+	faults   []fault
+	latency  time.Duration
+	requests []string
+}
+
+// a status returned instead of the real response
+type fault struct {
+	path      string // matched against the path below basePath, "" matches every path
+	status    int
+	remaining int // number of requests left to fail, -1 fails every request
+}
+
+// New starts a fake chess.com with no players.
+func New() *Server {
+	s := &Server{
+		archives: make(map[string]map[string][]byte),
+		profiles: make(map[string][]byte),
+		stats:    make(map[string][]byte),
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	return s
+}
+
+// BaseURL is the value to configure as the chess.com base URL.
+func (s *Server) BaseURL() string {
+	return s.URL + basePath
+}
+
+// AddArchive serves body as the games of a player's month, replacing any earlier body.
+func (s *Server) AddArchive(player string, year int, month time.Month, body []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	player = strings.ToLower(player)
+	if s.archives[player] == nil {
+		s.archives[player] = make(map[string][]byte)
+	}
+	s.archives[player][fmt.Sprintf("%d/%02d", year, int(month))] = body
+}
+
+// AddProfile serves body as a player's profile.
+func (s *Server) AddProfile(player string, body []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.profiles[strings.ToLower(player)] = body
+}
+
+// AddStats serves body as a player's stats.
+func (s *Server) AddStats(player string, body []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.stats[strings.ToLower(player)] = body
+}
+
+var fixtureName = regexp.MustCompile(`^(.+)_(\d{4})_(\d{2})\.json$`)
+
+// LoadFixtures serves every fixture in dir:
+// {player}_{YYYY}_{MM}.json archives, {player}_profile.json and {player}_stats.json.
+// Other files are ignored, archive lists are built from the archives.
+func (s *Server) LoadFixtures(dir string) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, ".json") {
+			continue
+		}
+		body, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			return err
+		}
+
+		if m := fixtureName.FindStringSubmatch(name); m != nil {
+			var year, month int
+			fmt.Sscanf(m[2], "%d", &year)
+			fmt.Sscanf(m[3], "%d", &month)
+			s.AddArchive(m[1], year, time.Month(month), body)
+		} else if player, ok := strings.CutSuffix(name, "_profile.json"); ok {
+			s.AddProfile(player, body)
+		} else if player, ok := strings.CutSuffix(name, "_stats.json"); ok {
+			s.AddStats(player, body)
+		}
+	}
+
+	return nil
+}
+
+// FailNext answers the next n requests for path (below /pub, e.g. "/player/asdf")
+// with status. An empty path matches every request, n < 0 fails forever.
+func (s *Server) FailNext(path string, status int, n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.faults = append(s.faults, fault{path: path, status: status, remaining: n})
+}
+
+// SetLatency delays every response by d.
+func (s *Server) SetLatency(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.latency = d
+}
+
+// Requests lists the path of every request received, in order.
+func (s *Server) Requests() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.requests...)
+}
+
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	s.requests = append(s.requests, r.URL.Path)
+	latency := s.latency
+	status := s.takeFault(strings.TrimPrefix(r.URL.Path, basePath))
+	s.mu.Unlock()
+
+	time.Sleep(latency)
+
+	if status != 0 {
+		writeError(w, status)
+		return
+	}
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed)
+		return
+	}
+
+	body, ok := s.lookup(r.URL.Path)
+	if !ok {
+		writeError(w, http.StatusNotFound)
+		return
+	}
+
+	// chess.com sends an ETag, and answers a matching If-None-Match with 304
+	sum := sha256.Sum256(body)
+	etag := `"` + hex.EncodeToString(sum[:8]) + `"`
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Content-Type", "application/json")
+	if r.Header.Get("If-None-Match") == etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Write(body)
+}
+
+// returns the status of the first fault matching path, the caller must hold mu
+func (s *Server) takeFault(path string) (status int) {
+	for i := range s.faults {
+		f := &s.faults[i]
+		if f.remaining == 0 || (f.path != "" && f.path != path) {
+			continue
+		}
+		if f.remaining > 0 {
+			f.remaining--
+		}
+		return f.status
+	}
+	return 0
+}
+
+// finds the body for a request path
+func (s *Server) lookup(path string) (body []byte, ok bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	rest, found := strings.CutPrefix(path, basePath+"/player/")
+	if !found {
+		return nil, false
+	}
+	parts := strings.Split(rest, "/")
+	player := strings.ToLower(parts[0])
+
+	switch {
+	case len(parts) == 1:
+		body, ok = s.profiles[player]
+	case len(parts) == 2 && parts[1] == "stats":
+		body, ok = s.stats[player]
+	case len(parts) == 3 && parts[1] == "games" && parts[2] == "archives":
+		archives, exists := s.archives[player]
+		if !exists {
+			return nil, false
+		}
+		months := make([]string, 0, len(archives))
+		for month := range archives {
+			months = append(months, month)
+		}
+		sort.Strings(months)
+		urls := make([]string, len(months))
+		for i, month := range months {
+			urls[i] = fmt.Sprintf("%s/player/%s/games/%s", s.BaseURL(), player, month)
+		}
+		body, _ = json.Marshal(map[string][]string{"archives": urls})
+		ok = true
+	case len(parts) == 4 && parts[1] == "games":
+		body, ok = s.archives[player][parts[2]+"/"+parts[3]]
+	}
+
+	return body, ok
+}
+
+// chess.com reports errors as JSON
+func writeError(w http.ResponseWriter, status int) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"code":    0,
+		"message": http.StatusText(status),
+	})
+}
@@ -0,0 +1,162 @@
+// Package fakelichess is a local stand-in for the lichess API,
+// for tests that need an upstream without touching the network.
+//
+// It serves user accounts and the user games export as NDJSON,
+// filtered by the since and until parameters like lichess does.
+package fakelichess
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Server is a running fake lichess.
+type Server struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	users    map[string][]byte   // player -> user JSON
+	games    map[string][][]byte // player -> one JSON game per entry
+	requests []string
+}
+
+// New starts a fake lichess with no players.
+func New() *Server {
+	s := &Server{
+		users: make(map[string][]byte),
+		games: make(map[string][][]byte),
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	return s
+}
+
+// BaseURL is the value to configure as the lichess base URL.
+func (s *Server) BaseURL() string {
+	return s.URL
+}
+
+// AddUser serves body as a player's account.
+func (s *Server) AddUser(player string, body []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.users[strings.ToLower(player)] = body
+}
+
+// AddGames serves each line of the NDJSON body as one of the player's games,
+// replacing any earlier games.
+func (s *Server) AddGames(player string, body []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var games [][]byte
+	scanner := bufio.NewScanner(bytes.NewReader(body))
+	scanner.Buffer(make([]byte, 64*1024), 4*1024*1024)
+	for scanner.Scan() {
+		if line := bytes.TrimSpace(scanner.Bytes()); len(line) > 0 {
+			games = append(games, append([]byte(nil), line...))
+		}
+	}
+	s.games[strings.ToLower(player)] = games
+}
+
+// LoadFixtures serves every fixture in dir:
+// {player}.json accounts and {player}.ndjson games.
+// Other files are ignored.
+func (s *Server) LoadFixtures(dir string) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		body, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return err
+		}
+
+		if player, ok := strings.CutSuffix(entry.Name(), ".ndjson"); ok {
+			s.AddGames(player, body)
+		} else if player, ok := strings.CutSuffix(entry.Name(), ".json"); ok {
+			s.AddUser(player, body)
+		}
+	}
+
+	return nil
+}
+
+// Requests lists the path and query of every request received, in order.
+func (s *Server) Requests() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.requests...)
+}
+
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requests = append(s.requests, r.URL.RequestURI())
+
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed)
+		return
+	}
+
+	if player, ok := strings.CutPrefix(r.URL.Path, "/api/user/"); ok {
+		body, ok := s.users[strings.ToLower(player)]
+		if !ok {
+			writeError(w, http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(body)
+		return
+	}
+
+	if player, ok := strings.CutPrefix(r.URL.Path, "/api/games/user/"); ok {
+		if _, ok := s.users[strings.ToLower(player)]; !ok {
+			writeError(w, http.StatusNotFound)
+			return
+		}
+		since, _ := strconv.ParseInt(r.URL.Query().Get("since"), 10, 64)
+		until, err := strconv.ParseInt(r.URL.Query().Get("until"), 10, 64)
+		if err != nil {
+			until = 1<<63 - 1
+		}
+
+		// lichess exports the most recent games first, the fixtures are kept that way
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		for _, game := range s.games[strings.ToLower(player)] {
+			var created struct {
+				CreatedAt int64 `json:"createdAt"`
+			}
+			json.Unmarshal(game, &created)
+			if created.CreatedAt >= since && created.CreatedAt <= until {
+				w.Write(game)
+				w.Write([]byte("\n"))
+			}
+		}
+		return
+	}
+
+	writeError(w, http.StatusNotFound)
+}
+
+// lichess reports errors as JSON
+func writeError(w http.ResponseWriter, status int) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"error": http.StatusText(status),
+	})
+}
@@ -0,0 +1,585 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// fsck walks every table and checks that:
+//   - the file is valid JSON with a readable schema version
+//   - archive lists and archive data have the expected shape
+//   - every analysis belongs to a game in some archive, or an imported game
+//   - every analysed move has valid FENs and SAN moves that lead to those FENs
+//   - accuracies are within [0, 1] and match the moves they were calculated from
+//   - every game index entry belongs to a game in the player's archives
+//   - the player part of every table name is lowercase
+//
+// With repair, tables of mixed-case players are renamed to lowercase,
+// unreadable files are moved to the quarantine directory,
+// bad records are moved to the table's `{player}_quarantine.json`,
+// wrong accuracies are recalculated and stale game indexes are rebuilt.
+
+const quarantineDir = "quarantine"
+
+// players are looked up in lowercase, so these tables are never read
+const mixedCaseProblem = "player name is not lowercase"
+
+type fsckProblem struct {
+	Table    string `json:"table"`
+	Key      string `json:"key,omitempty"`
+	Problem  string `json:"problem"`
+	Repaired bool   `json:"repaired"`
+}
+
+type fsckReport struct {
+	TablesChecked  int           `json:"tables_checked"`
+	RecordsChecked int           `json:"records_checked"`
+	Problems       []fsckProblem `json:"problems"`
+}
+
+// the number of problems not repaired
+func (report *fsckReport) outstanding() (n int) {
+	for _, p := range report.Problems {
+		if !p.Repaired {
+			n++
+		}
+	}
+	return n
+}
+
+func (report *fsckReport) prettyPrint() (s string, err error) {
+	reportJSON, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		err = fmt.Errorf("json.MarshalIndent: %w", err)
+		return "", WrapError(err)
+	}
+
+	s = string(reportJSON)
+	return s, nil
+}
+
+var moveKeyRegexp = regexp.MustCompile(`^\d{2,}\.(\.\.)?$`)
+
+func runFsck(repair bool) (report fsckReport, err error) {
+	report = fsckReport{Problems: []fsckProblem{}}
+
+	if repair {
+		renamed, err := normalizeTableNames()
+		for _, tableName := range renamed {
+			report.Problems = append(report.Problems, fsckProblem{Table: tableName, Problem: mixedCaseProblem, Repaired: true})
+		}
+		if err != nil {
+			err = fmt.Errorf("normalizeTableNames: %w", err)
+			return fsckReport{}, WrapError(err)
+		}
+	}
+
+	tableNames, err := listTables()
+	if err != nil {
+		err = fmt.Errorf("listTables: %w", err)
+		return fsckReport{}, WrapError(err)
+	}
+
+	// first pass: every file must decode
+	tables := make(map[string]database)
+	for _, tableName := range tableNames {
+		report.TablesChecked++
+		player, contentType, _ := parseTableName(tableName)
+		if player != strings.ToLower(player) {
+			report.Problems = append(report.Problems, fsckProblem{Table: tableName, Problem: mixedCaseProblem})
+		}
+
+		db, err := readTableFile(tableName, contentType)
+		if err != nil {
+			problem := fsckProblem{Table: tableName, Problem: err.Error()}
+			if repair {
+				problem.Repaired = quarantineFile(tableName) == nil
+			}
+			report.Problems = append(report.Problems, problem)
+			continue
+		}
+		tables[tableName] = db
+	}
+
+	// second pass: archives, collecting every known game
+	knownUUIDs := make(map[string]string) // uuid -> owner, see gameOwner
+	for tableName, db := range tables {
+		player, contentType, _ := parseTableName(tableName)
+		site, base := sourceOfContentType(contentType)
+		switch base {
+		case "archive_list":
+			report.checkArchiveList(tableName, site, db)
+		case "archive_data":
+			for uuid := range report.checkArchiveData(tableName, db) {
+				knownUUIDs[uuid] = gameOwner(site, player)
+			}
+		case "imported":
+			for uuid := range report.checkImported(tableName, db) {
+				knownUUIDs[uuid] = importedSource
+			}
+		}
+	}
+
+	// third pass: records that refer to games
+	uuidIndexChecked := false
+	for tableName, db := range tables {
+		player, contentType, _ := parseTableName(tableName)
+		site, base := sourceOfContentType(contentType)
+		switch base {
+		case "analysis":
+			report.checkAnalyses(tableName, db, knownUUIDs, repair)
+		case "game_index":
+			report.checkGameIndex(tableName, site, player, db, knownUUIDs, repair)
+		case "uuid_index":
+			report.checkUUIDIndex(tableName, db, knownUUIDs, repair)
+			uuidIndexChecked = true
+		}
+	}
+	// games stored before the UUID index existed are missing from it
+	if !uuidIndexChecked && len(knownUUIDs) > 0 {
+		report.checkUUIDIndex("_uuid_index.json", database{Data: map[string]interface{}{}}, knownUUIDs, repair)
+	}
+
+	sort.Slice(report.Problems, func(i, j int) bool {
+		if report.Problems[i].Table == report.Problems[j].Table {
+			return report.Problems[i].Key < report.Problems[j].Key
+		}
+		return report.Problems[i].Table < report.Problems[j].Table
+	})
+
+	return report, nil
+}
+
+// reads and migrates a table file, returning any decode error
+func readTableFile(tableName string, contentType string) (db database, err error) {
+	tablesMu.RLock()
+	contents, err := os.ReadFile(filepath.Join(appConfig.DataDir, tableName))
+	tablesMu.RUnlock()
+	if err != nil {
+		err = fmt.Errorf("os.ReadFile: %w", err)
+		return database{}, WrapError(err)
+	}
+
+	raw := make(map[string]interface{})
+	err = json.Unmarshal(contents, &raw)
+	if err != nil {
+		err = fmt.Errorf("invalid JSON: %w", err)
+		return database{}, WrapError(err)
+	}
+
+	version, data, err := decodeTable(raw)
+	if err != nil {
+		err = fmt.Errorf("decodeTable: %w", err)
+		return database{}, WrapError(err)
+	}
+
+	data, err = migrateData(contentType, version, data)
+	if err != nil {
+		err = fmt.Errorf("migrateData: %w", err)
+		return database{}, WrapError(err)
+	}
+
+	db = database{TableName: tableName, ContentType: contentType, SchemaVersion: version, Data: data}
+	return db, nil
+}
+
+// the player whose archives hold a game, qualified by source as player names are per source
+func gameOwner(site gameSource, player string) string {
+	return site.name() + "/" + player
+}
+
+func (report *fsckReport) checkArchiveList(tableName string, site gameSource, db database) {
+	report.RecordsChecked++
+
+	archives, ok := db.Data["archives"].([]interface{})
+	if !ok {
+		report.Problems = append(report.Problems, fsckProblem{Table: tableName, Key: "archives", Problem: "'archives' key not found or is not []interface{}"})
+		return
+	}
+	for _, archive := range archives {
+		_, err := site.archiveKey(archive)
+		if err != nil {
+			report.Problems = append(report.Problems, fsckProblem{Table: tableName, Key: "archives", Problem: err.Error()})
+		}
+	}
+}
+
+// checks each archive month and returns the UUIDs of its games
+func (report *fsckReport) checkArchiveData(tableName string, db database) (uuids map[string]bool) {
+	uuids = make(map[string]bool)
+
+	for key, value := range db.Data {
+		report.RecordsChecked++
+
+		if _, err := time.Parse("2006-01", key); err != nil {
+			report.Problems = append(report.Problems, fsckProblem{Table: tableName, Key: key, Problem: "key is not an archive in the format YYYY-MM"})
+		}
+
+		games, ok := value.([]interface{})
+		if !ok {
+			report.Problems = append(report.Problems, fsckProblem{Table: tableName, Key: key, Problem: "games are not []interface{}"})
+			continue
+		}
+		for i, game := range games {
+			gameMap, ok := game.(map[string]interface{})
+			if !ok {
+				report.Problems = append(report.Problems, fsckProblem{Table: tableName, Key: key, Problem: fmt.Sprintf("game %d is not a map[string]interface{}", i)})
+				continue
+			}
+			uuid, ok := gameMap["uuid"].(string)
+			if !ok || uuid == "" {
+				report.Problems = append(report.Problems, fsckProblem{Table: tableName, Key: key, Problem: fmt.Sprintf("game %d has no uuid", i)})
+				continue
+			}
+			uuids[uuid] = true
+		}
+	}
+
+	return uuids
+}
+
+// checks each imported game and returns their UUIDs
+func (report *fsckReport) checkImported(tableName string, db database) (uuids map[string]bool) {
+	uuids = make(map[string]bool)
+
+	for key, value := range db.Data {
+		report.RecordsChecked++
+
+		gameMap, ok := value.(map[string]interface{})
+		if !ok {
+			report.Problems = append(report.Problems, fsckProblem{Table: tableName, Key: key, Problem: "game is not a map[string]interface{}"})
+			continue
+		}
+		if uuid, _ := gameMap["uuid"].(string); uuid != key {
+			report.Problems = append(report.Problems, fsckProblem{Table: tableName, Key: key, Problem: fmt.Sprintf("game uuid %q does not match its key", uuid)})
+			continue
+		}
+		uuids[key] = true
+	}
+
+	return uuids
+}
+
+func (report *fsckReport) checkAnalyses(tableName string, db database, knownUUIDs map[string]string, repair bool) {
+	player, _, _ := parseTableName(tableName)
+
+	var quarantined []string
+	recalculated := make(map[string]interface{})
+
+	for uuid, record := range db.Data {
+		report.RecordsChecked++
+
+		problems, accuracy := checkAnalysisRecord(uuid, record, knownUUIDs)
+		if len(problems) > 0 {
+			for _, problem := range problems {
+				report.Problems = append(report.Problems, fsckProblem{Table: tableName, Key: uuid, Problem: problem, Repaired: repair})
+			}
+			quarantined = append(quarantined, uuid)
+			continue
+		}
+
+		if accuracy != nil {
+			report.Problems = append(report.Problems, fsckProblem{
+				Table:    tableName,
+				Key:      uuid,
+				Problem:  fmt.Sprintf("accuracy does not match moves, expected white %.4f black %.4f", accuracy["white"], accuracy["black"]),
+				Repaired: repair,
+			})
+			recordMap := record.(map[string]interface{})
+			recordMap["accuracy"] = accuracy
+			recalculated[uuid] = recordMap
+		}
+	}
+
+	if !repair {
+		return
+	}
+
+	err := quarantineRecords(tableName, player, db, quarantined)
+	if err != nil {
+		report.markUnrepaired(tableName, quarantined, err)
+	}
+
+	if len(recalculated) > 0 {
+		live, err := newDatabase("analysis", player)
+		if err == nil {
+			err = live.writeData(recalculated)
+		}
+		if err != nil {
+			keys := make([]string, 0, len(recalculated))
+			for uuid := range recalculated {
+				keys = append(keys, uuid)
+			}
+			report.markUnrepaired(tableName, keys, err)
+		}
+	}
+}
+
+// checks one analysis record, returning its problems
+// and the recalculated accuracy if the stored one is wrong
+func checkAnalysisRecord(uuid string, record interface{}, knownUUIDs map[string]string) (problems []string, accuracy map[string]float64) {
+	if _, ok := knownUUIDs[uuid]; !ok {
+		problems = append(problems, "analysis does not belong to a game in any archive")
+	}
+
+	recordMap, ok := record.(map[string]interface{})
+	if !ok {
+		return append(problems, "analysis is not a map[string]interface{}"), nil
+	}
+	moves, ok := recordMap["moves"].(map[string]interface{})
+	if !ok {
+		return append(problems, "'moves' key not found or is not a map[string]interface{}"), nil
+	}
+	storedAccuracy, ok := recordMap["accuracy"].(map[string]interface{})
+	if !ok {
+		return append(problems, "'accuracy' key not found or is not a map[string]interface{}"), nil
+	}
+
+	// analyses stored before variants were recorded are standard chess
+	recordVariant, _ := recordMap["variant"].(string)
+	variant, err := parseVariant(recordVariant)
+	if err != nil {
+		return append(problems, err.Error()), nil
+	}
+
+	evaluated, _ := recordMap["evaluated"].(bool)
+
+	hits := map[string]int{}
+	totals := map[string]int{}
+	for key, move := range moves {
+		if !moveKeyRegexp.MatchString(key) {
+			problems = append(problems, fmt.Sprintf("move %q: key is not in the format 01. or 01...", key))
+			continue
+		}
+		hit, err := checkAnalysedMove(move, variant, evaluated)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("move %q: %s", key, err.Error()))
+			continue
+		}
+
+		colour := "white"
+		if len(key) > 3 && key[len(key)-3:] == "..." {
+			colour = "black"
+		}
+		totals[colour]++
+		if hit {
+			hits[colour]++
+		}
+	}
+
+	expected := make(map[string]float64)
+	mismatch := false
+	for _, colour := range []string{"white", "black"} {
+		stored, ok := storedAccuracy[colour].(float64)
+		if !ok || math.IsNaN(stored) || stored < 0 || stored > 1 {
+			problems = append(problems, fmt.Sprintf("%s accuracy %v is not within [0, 1]", colour, storedAccuracy[colour]))
+			continue
+		}
+		if totals[colour] == 0 {
+			expected[colour] = stored
+			continue
+		}
+		expected[colour] = float64(hits[colour]) / float64(totals[colour])
+		if math.Abs(expected[colour]-stored) > 1e-9 {
+			mismatch = true
+		}
+	}
+
+	if len(problems) > 0 || !mismatch {
+		return problems, nil
+	}
+	return nil, expected
+}
+
+// checks one analysed move and reports whether the best move was played,
+// the move of an evaluated analysis must have an eval and a classification
+func checkAnalysedMove(move interface{}, variant string, evaluated bool) (hit bool, err error) {
+	moveMap, ok := move.(map[string]interface{})
+	if !ok {
+		return false, fmt.Errorf("move is not a map[string]interface{}")
+	}
+	if _, ok := moveMap["eval"].(map[string]interface{}); evaluated && !ok {
+		return false, fmt.Errorf("eval is missing from an evaluated analysis")
+	}
+	if _, ok := moveMap["classification"].(string); evaluated && !ok {
+		return false, fmt.Errorf("classification is missing from an evaluated analysis")
+	}
+
+	preFEN, _ := moveMap["pre"].(string)
+	pre, err := newGamePosition(variant, preFEN)
+	if err != nil {
+		return false, fmt.Errorf("pre: invalid FEN %q", preFEN)
+	}
+
+	posts := make(map[string]string)
+	for _, which := range []string{"actual", "best"} {
+		whichMap, ok := moveMap[which].(map[string]interface{})
+		if !ok {
+			return false, fmt.Errorf("%s is not a map[string]interface{}", which)
+		}
+		san, _ := whichMap["move"].(string)
+		post, _ := whichMap["post"].(string)
+
+		m, err := pre.decodeMove(san)
+		if err != nil {
+			return false, fmt.Errorf("%s: invalid SAN %q for %s", which, san, preFEN)
+		}
+		if _, err := newGamePosition(variant, post); err != nil {
+			return false, fmt.Errorf("%s: invalid FEN %q", which, post)
+		}
+		if expected := pre.update(m).fen(); expected != post {
+			return false, fmt.Errorf("%s: %s does not lead to %q", which, san, post)
+		}
+		posts[which] = post
+	}
+
+	return posts["actual"] == posts["best"], nil
+}
+
+func (report *fsckReport) checkGameIndex(tableName string, site gameSource, player string, db database, knownUUIDs map[string]string, repair bool) {
+	games, _ := db.Data["games"].(map[string]interface{})
+	owner := gameOwner(site, player)
+
+	stale := false
+	for uuid := range games {
+		report.RecordsChecked++
+		if knownUUIDs[uuid] != owner {
+			report.Problems = append(report.Problems, fsckProblem{Table: tableName, Key: uuid, Problem: "indexed game is not in the player's archives"})
+			stale = true
+		}
+	}
+	// and the other way round
+	for uuid, o := range knownUUIDs {
+		if o != owner {
+			continue
+		}
+		if _, ok := games[uuid]; !ok {
+			report.Problems = append(report.Problems, fsckProblem{Table: tableName, Key: uuid, Problem: "archived game is missing from the index"})
+			stale = true
+		}
+	}
+
+	if !stale || !repair {
+		return
+	}
+
+	_, err := reindexPlayer(site, player)
+	for i := range report.Problems {
+		if report.Problems[i].Table == tableName {
+			report.Problems[i].Repaired = err == nil
+		}
+	}
+}
+
+// a game stored by two players is known under one of them only, so the UUID
+// index is only checked for games that are not stored at all, or not indexed
+func (report *fsckReport) checkUUIDIndex(tableName string, db database, knownUUIDs map[string]string, repair bool) {
+	stale := false
+	for uuid := range db.Data {
+		report.RecordsChecked++
+		if _, ok := knownUUIDs[uuid]; !ok {
+			report.Problems = append(report.Problems, fsckProblem{Table: tableName, Key: uuid, Problem: "indexed game is not stored"})
+			stale = true
+		}
+	}
+	for uuid := range knownUUIDs {
+		if _, ok := db.Data[uuid]; !ok {
+			report.Problems = append(report.Problems, fsckProblem{Table: tableName, Key: uuid, Problem: "stored game is missing from the UUID index"})
+			stale = true
+		}
+	}
+
+	if !stale || !repair {
+		return
+	}
+
+	_, err := rebuildUUIDIndex()
+	for i := range report.Problems {
+		if report.Problems[i].Table == tableName {
+			report.Problems[i].Repaired = err == nil
+		}
+	}
+}
+
+// flags problems for keys whose repair failed
+func (report *fsckReport) markUnrepaired(tableName string, keys []string, err error) {
+	failed := make(map[string]bool)
+	for _, key := range keys {
+		failed[key] = true
+	}
+	for i := range report.Problems {
+		p := &report.Problems[i]
+		if p.Table == tableName && failed[p.Key] {
+			p.Repaired = false
+			p.Problem = fmt.Sprintf("%s (repair failed: %s)", p.Problem, err.Error())
+		}
+	}
+}
+
+// moves an unreadable table file into the quarantine directory
+func quarantineFile(tableName string) (err error) {
+	tablesMu.Lock()
+	defer tablesMu.Unlock()
+
+	dir := filepath.Join(appConfig.DataDir, quarantineDir)
+	err = os.MkdirAll(dir, 0755)
+	if err != nil {
+		err = fmt.Errorf("os.MkdirAll: %w", err)
+		return WrapError(err)
+	}
+
+	target := filepath.Join(dir, fmt.Sprintf("%s.%s", tableName, time.Now().UTC().Format("20060102T150405Z")))
+	err = os.Rename(filepath.Join(appConfig.DataDir, tableName), target)
+	if err != nil {
+		err = fmt.Errorf("os.Rename: %w", err)
+		return WrapError(err)
+	}
+
+	return nil
+}
+
+// moves records out of a table into the player's quarantine table
+func quarantineRecords(tableName string, player string, db database, keys []string) (err error) {
+	if len(keys) == 0 {
+		return nil
+	}
+
+	records := make(map[string]interface{})
+	for _, key := range keys {
+		records[fmt.Sprintf("%s/%s", tableName, key)] = map[string]interface{}{
+			"quarantined": time.Now().UTC(),
+			"record":      db.Data[key],
+		}
+	}
+
+	quarantine, err := newDatabase("quarantine", player)
+	if err != nil {
+		err = fmt.Errorf("newDatabase: %w", err)
+		return WrapError(err)
+	}
+	err = quarantine.writeData(records)
+	if err != nil {
+		err = fmt.Errorf("quarantine.writeData: %w", err)
+		return WrapError(err)
+	}
+
+	live, err := newDatabase(db.ContentType, player)
+	if err != nil {
+		err = fmt.Errorf("newDatabase: %w", err)
+		return WrapError(err)
+	}
+	err = live.deleteData(keys)
+	if err != nil {
+		err = fmt.Errorf("live.deleteData: %w", err)
+		return WrapError(err)
+	}
+
+	return nil
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/notnil/chess"
+)
+
+// builds an analysis record where every move was the best move
+func perfectAnalysis(t *testing.T, pgn string) map[string]interface{} {
+	_, plies, err := replayPGN(pgn, variantChess, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	moves := make(map[string]interface{})
+	for i, ply := range plies {
+		key := fmt.Sprintf("%02d.", i/2+1)
+		if ply.pre.position.Turn() == chess.Black {
+			key = fmt.Sprintf("%02d...", i/2+1)
+		}
+		played := map[string]interface{}{
+			"move": ply.pre.encodeSAN(ply.move),
+			"post": ply.post.fen(),
+		}
+		moves[key] = map[string]interface{}{"pre": ply.pre.fen(), "actual": played, "best": played}
+	}
+
+	return map[string]interface{}{"moves": moves, "accuracy": map[string]interface{}{"white": 1.0, "black": 1.0}}
+}
+
+func TestFsck(t *testing.T) {
+	appConfig.DataDir = t.TempDir()
+	defer func() { appConfig = defaultConfig() }()
+
+	ad := fixtureArchiveData(t, "asdf", "2025-02", "testdata/asdf_2025_02.json")
+	archiveDB, _ := newDatabase("archive_data", "asdf")
+	err := archiveDB.writeData(ad.ArchiveData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = reindexPlayer(chessComSource, "asdf")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	game := ad.ArchiveData["2025-02"].([]interface{})[0].(map[string]interface{})
+	good := perfectAnalysis(t, game["pgn"].(string))
+	wrongAccuracy := perfectAnalysis(t, game["pgn"].(string))
+	wrongAccuracy["accuracy"] = map[string]interface{}{"white": 1.0, "black": 0.0}
+	badSAN := perfectAnalysis(t, game["pgn"].(string))
+	badSAN["moves"].(map[string]interface{})["01."].(map[string]interface{})["best"] = map[string]interface{}{"move": "Ke5", "post": "x"}
+
+	analysisDB, _ := newDatabase("analysis", "")
+	err = analysisDB.writeData(map[string]interface{}{
+		"8e1f2c3a-0001-11ef-8000-000000000001": good,
+		"8e1f2c3a-0002-11ef-8000-000000000002": wrongAccuracy,
+		"8e1f2c3a-0003-11ef-8000-000000000003": badSAN,
+		"00000000-0000-0000-0000-000000000000": good,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	uuidDB, _ := newDatabase("uuid_index", "")
+	err = uuidDB.writeData(map[string]interface{}{
+		"00000000-0000-0000-0000-000000000001": map[string]interface{}{"source": "chess.com", "player": "asdf", "archive": "2025-01"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(filepath.Join(appConfig.DataDir, "broken_archive_list.json"), []byte("{"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("check reports problems", func(t *testing.T) {
+		report, err := runFsck(false)
+		if err != nil {
+			t.Fatalf("expected %v, got %v", nil, err)
+		}
+
+		expected := map[string]bool{
+			"broken_archive_list.json":                              true,
+			"_analysis.json/8e1f2c3a-0002-11ef-8000-000000000002":   true,
+			"_analysis.json/8e1f2c3a-0003-11ef-8000-000000000003":   true,
+			"_analysis.json/00000000-0000-0000-0000-000000000000":   true,
+			"_uuid_index.json/00000000-0000-0000-0000-000000000001": true,
+		}
+		for _, p := range report.Problems {
+			key := p.Table
+			if p.Key != "" {
+				key += "/" + p.Key
+			}
+			if !expected[key] {
+				t.Errorf("unexpected problem %v", p)
+			}
+			delete(expected, key)
+		}
+		for key := range expected {
+			t.Errorf("expected a problem for %v", key)
+		}
+		if report.outstanding() != len(report.Problems) {
+			t.Errorf("expected %v, got %v", len(report.Problems), report.outstanding())
+		}
+	})
+
+	t.Run("repair quarantines and recalculates", func(t *testing.T) {
+		report, err := runFsck(true)
+		if err != nil {
+			t.Fatalf("expected %v, got %v", nil, err)
+		}
+		if report.outstanding() != 0 {
+			t.Errorf("expected %v, got %v", 0, report.outstanding())
+		}
+
+		report, err = runFsck(false)
+		if err != nil {
+			t.Fatalf("expected %v, got %v", nil, err)
+		}
+		if len(report.Problems) != 0 {
+			t.Errorf("expected no problems after repair, got %v", report.Problems)
+		}
+
+		analysisDB, _ := newDatabase("analysis", "")
+		accuracy := analysisDB.Data["8e1f2c3a-0002-11ef-8000-000000000002"].(map[string]interface{})["accuracy"].(map[string]interface{})
+		if accuracy["black"] != 1.0 {
+			t.Errorf("expected %v, got %v", 1.0, accuracy["black"])
+		}
+		quarantine, _ := newDatabase("quarantine", "")
+		if len(quarantine.Data) != 2 {
+			t.Errorf("expected %v, got %v", 2, len(quarantine.Data))
+		}
+	})
+}
+
+func TestCheckAnalysisRecordEvaluated(t *testing.T) {
+	type testCase struct {
+		// Input Params
+		name      string
+		evaluated bool
+		withEvals bool
+		// Expected Values
+		problems int
+	}
+
+	ad := fixtureArchiveData(t, "asdf", "2025-02", "testdata/asdf_2025_02.json")
+	pgn := ad.ArchiveData["2025-02"].([]interface{})[0].(map[string]interface{})["pgn"].(string)
+	uuid := "8e1f2c3a-0001-11ef-8000-000000000001"
+	knownUUIDs := map[string]string{uuid: "asdf"}
+
+	tests := []testCase{
+		{"stored before moves were evaluated", false, false, 0},
+		{"evaluated", true, true, 0},
+		{"evaluated without evals", true, false, 1},
+	}
+
+	for _, test := range tests {
+		record := perfectAnalysis(t, pgn)
+		record["evaluated"] = test.evaluated
+		moves := record["moves"].(map[string]interface{})
+		for _, move := range moves {
+			if test.withEvals {
+				move.(map[string]interface{})["eval"] = map[string]interface{}{"best": map[string]interface{}{"cp": 0.0}}
+				move.(map[string]interface{})["classification"] = "best"
+			}
+		}
+
+		problems, _ := checkAnalysisRecord(uuid, record, knownUUIDs)
+		if test.problems == 0 && len(problems) != 0 || test.problems > 0 && len(problems) != len(moves) {
+			t.Errorf("%s: expected a problem per move if any, got %v", test.name, problems)
+		}
+	}
+}
@@ -3,3 +3,5 @@ module chess-analyzer
 go 1.23.5
 
 require github.com/notnil/chess v1.10.0
+
+require gopkg.in/yaml.v3 v3.0.1
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/notnil/chess/uci"
+)
+
+// Liveness and readiness, for the kubelet's probes. /healthz answers as long
+// as the process serves requests. /readyz checks what requests depend on: a
+// data directory tables can be written to and an engine that starts and
+// answers isready, and an analysis slot free for the next analysis, and
+// answers 503 with the checks that failed when any does. The engine is started
+// at most every readyEngineCacheTTL, as probes come every few seconds.
+
+// how long the engine has to start and answer isready, a variable for the tests
+var readyEngineTimeout = 5 * time.Second
+
+// how long the result of the engine check is reused
+const readyEngineCacheTTL = 30 * time.Second
+
+// the last engine check, guarded so that one engine is started at a time
+var engineCheck struct {
+	sync.Mutex
+	path      string // appConfig.Engine.Path when checked
+	checkedAt time.Time
+	check     readinessCheck
+}
+
+// analyses running now, each holding appConfig.Workers engines or one for a position
+var runningAnalyses atomic.Int64
+
+// takes one of appConfig.MaxAnalyses slots for an analysis of kind, game or
+// position, until done is called with its error. With every slot taken the
+// analysis is refused rather than queued, as the client would time out first.
+// A MaxAnalyses of 0 refuses nothing.
+func startAnalysis(kind string) (done func(err error), err error) {
+	for {
+		running := runningAnalyses.Load()
+		if appConfig.MaxAnalyses > 0 && running >= int64(appConfig.MaxAnalyses) {
+			analyses.add(1, kind, "refused")
+			err = engineUnavailableError("%d of %d analyses running, try again later", running, appConfig.MaxAnalyses)
+			return nil, WrapError(err)
+		}
+		if runningAnalyses.CompareAndSwap(running, running+1) {
+			break
+		}
+	}
+
+	return func(err error) {
+		runningAnalyses.Add(-1)
+		state := "completed"
+		if err != nil {
+			state = "failed"
+		}
+		analyses.add(1, kind, state)
+	}, nil
+}
+
+type readinessCheck struct {
+	OK     bool   `json:"ok"`
+	Detail string `json:"detail"` // what was found, or why the check failed
+}
+
+type readinessReport struct {
+	Ready  bool                      `json:"ready"`
+	Checks map[string]readinessCheck `json:"checks"`
+}
+
+// runs every readiness check
+func checkReadiness() (report readinessReport) {
+	report = readinessReport{
+		Ready: true,
+		Checks: map[string]readinessCheck{
+			"data_dir": checkDataDir(),
+			"engine":   checkEngine(),
+			"analyses": checkAnalyses(),
+		},
+	}
+	for _, check := range report.Checks {
+		report.Ready = report.Ready && check.OK
+	}
+	return report
+}
+
+// writes a file to the data directory the way tables are written
+func checkDataDir() readinessCheck {
+	file, err := os.CreateTemp(appConfig.DataDir, ".readyz.*")
+	if err != nil {
+		return readinessCheck{Detail: fmt.Sprintf("os.CreateTemp: %v", err)}
+	}
+	defer os.Remove(file.Name())
+	defer file.Close()
+
+	_, err = file.WriteString("ready")
+	if err == nil {
+		err = file.Close()
+	}
+	if err != nil {
+		return readinessCheck{Detail: fmt.Sprintf("write: %v", err)}
+	}
+	return readinessCheck{OK: true, Detail: appConfig.DataDir + " is writable"}
+}
+
+// checks the engine at most once per readyEngineCacheTTL, probes in between
+// get the last result rather than starting an engine each
+func checkEngine() readinessCheck {
+	engineCheck.Lock()
+	defer engineCheck.Unlock()
+
+	if engineCheck.path == appConfig.Engine.Path && time.Since(engineCheck.checkedAt) < readyEngineCacheTTL {
+		return engineCheck.check
+	}
+	engineCheck.path = appConfig.Engine.Path
+	engineCheck.check = probeEngine()
+	engineCheck.checkedAt = time.Now()
+	return engineCheck.check
+}
+
+// starts the engine with the configured options and waits for it to answer
+// isready, killing it once it has, or once readyEngineTimeout has passed
+func probeEngine() readinessCheck {
+	ctx, cancel := context.WithTimeout(context.Background(), readyEngineTimeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, appConfig.Engine.Path)
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return readinessCheck{Detail: fmt.Sprintf("cmd.StdinPipe: %v", err)}
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return readinessCheck{Detail: fmt.Sprintf("cmd.StdoutPipe: %v", err)}
+	}
+	err = cmd.Start()
+	if err != nil {
+		return readinessCheck{Detail: fmt.Sprintf("cmd.Start: %v", err)}
+	}
+	defer cmd.Wait()
+	defer cmd.Process.Kill()
+
+	commands := []string{uci.CmdUCI.String()}
+	for _, name := range appConfig.sortedEngineOptions() {
+		commands = append(commands, uci.CmdSetOption{Name: name, Value: appConfig.Engine.Options[name]}.String())
+	}
+	commands = append(commands, uci.CmdIsReady.String())
+	_, err = fmt.Fprintln(stdin, strings.Join(commands, "\n"))
+	if err != nil {
+		return readinessCheck{Detail: fmt.Sprintf("write: %v", err)}
+	}
+
+	// the output ends when the engine exits or is killed on timeout
+	name := appConfig.Engine.Path
+	scanner := bufio.NewScanner(stdout)
+	for scanner.Scan() {
+		if id, ok := strings.CutPrefix(scanner.Text(), "id name "); ok {
+			name = id
+		}
+		if strings.TrimSpace(scanner.Text()) == "readyok" {
+			return readinessCheck{OK: true, Detail: name + " is ready"}
+		}
+	}
+	if ctx.Err() != nil {
+		return readinessCheck{Detail: fmt.Sprintf("%s did not answer isready within %s", appConfig.Engine.Path, readyEngineTimeout)}
+	}
+	return readinessCheck{Detail: fmt.Sprintf("%s exited without answering isready", appConfig.Engine.Path)}
+}
+
+// whether an analysis slot is free, see startAnalysis. A saturated instance
+// is unready, so that new analyses go to another one, and refuses those that
+// still reach it.
+func checkAnalyses() readinessCheck {
+	running := runningAnalyses.Load()
+	if appConfig.MaxAnalyses == 0 {
+		return readinessCheck{OK: true, Detail: fmt.Sprintf("%d analyses running, no limit", running)}
+	}
+	detail := fmt.Sprintf("%d of %d analyses running", running, appConfig.MaxAnalyses)
+	if running >= int64(appConfig.MaxAnalyses) {
+		return readinessCheck{Detail: detail + ", further analyses are refused"}
+	}
+	return readinessCheck{OK: true, Detail: detail}
+}
+
+// pretty-prints the report as indented JSON
+func (report *readinessReport) prettyPrint() (s string, err error) {
+	reportJSON, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		err = fmt.Errorf("json.MarshalIndent: %w", err)
+		return "", WrapError(err)
+	}
+
+	s = string(reportJSON)
+	return s, nil
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReadyz(t *testing.T) {
+	type testCase struct {
+		// Input Params
+		name  string
+		setup func(c *config) (cleanup func())
+		// Expected Values
+		status int
+		failed string // the check that fails, if any
+	}
+
+	tests := []testCase{
+		{"ready", func(c *config) func() { return func() {} }, 200, ""},
+		{"data dir missing", func(c *config) func() {
+			c.DataDir = filepath.Join(c.DataDir, "missing")
+			return func() {}
+		}, 503, "data_dir"},
+		{"no engine", func(c *config) func() {
+			c.Engine.Path = filepath.Join(t.TempDir(), "stockfish")
+			return func() {}
+		}, 503, "engine"},
+		{"analyses saturated", func(c *config) func() {
+			c.MaxAnalyses = 2
+			return takeAnalysisSlots(t, 2)
+		}, 503, "analyses"},
+		{"no analysis limit", func(c *config) func() {
+			c.MaxAnalyses = 0
+			return takeAnalysisSlots(t, 8)
+		}, 200, ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := fakeEngineConfig(t, nil)
+			cleanup := test.setup(&c)
+			defer cleanup()
+			setConfig(c)
+			defer setConfig(defaultConfig())
+
+			r := httptest.NewRequest("GET", "/readyz", nil)
+			w := httptest.NewRecorder()
+			newRouter().ServeHTTP(w, r)
+			validateAPIResponse(t, "GET", "/readyz", w.Code, w.Header(), w.Body.Bytes())
+			if w.Code != test.status {
+				t.Fatalf("expected %v, got %v (%s)", test.status, w.Code, w.Body)
+			}
+
+			var report readinessReport
+			err := json.Unmarshal(w.Body.Bytes(), &report)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for name, check := range report.Checks {
+				if check.OK == (name == test.failed) {
+					t.Errorf("%s: expected ok %v, got %+v", name, name != test.failed, check)
+				}
+			}
+		})
+	}
+
+	t.Run("analyses over the limit are refused", func(t *testing.T) {
+		c := fakeEngineConfig(t, nil)
+		c.MaxAnalyses = 1
+		setConfig(c)
+		defer setConfig(defaultConfig())
+		defer takeAnalysisSlots(t, 1)()
+
+		body := `{"fen": "7k/R7/5K2/8/8/8/8/8 b - - 0 1"}`
+		r := httptest.NewRequest("POST", "/api/position", strings.NewReader(body))
+		w := httptest.NewRecorder()
+		newRouter().ServeHTTP(w, r)
+		validateAPIResponse(t, "POST", "/api/position", w.Code, w.Header(), w.Body.Bytes())
+		if w.Code != http.StatusServiceUnavailable || !strings.Contains(w.Body.String(), "1 of 1 analyses running") {
+			t.Errorf("expected %v, got %v %q", http.StatusServiceUnavailable, w.Code, w.Body)
+		}
+	})
+
+	t.Run("hung engine is killed", func(t *testing.T) {
+		c := fakeEngineConfig(t, nil)
+		dir := t.TempDir()
+		c.Engine.Path = filepath.Join(dir, "stockfish")
+		script := "#!/bin/sh\necho $$ > " + filepath.Join(dir, "pid") + "\nexec sleep 60\n"
+		err := os.WriteFile(c.Engine.Path, []byte(script), 0755)
+		if err != nil {
+			t.Fatal(err)
+		}
+		setConfig(c)
+		defer setConfig(defaultConfig())
+		defer func(timeout time.Duration) { readyEngineTimeout = timeout }(readyEngineTimeout)
+		readyEngineTimeout = 100 * time.Millisecond
+
+		check := checkEngine()
+		if check.OK || !strings.Contains(check.Detail, "did not answer") {
+			t.Errorf("expected a timeout, got %+v", check)
+		}
+		pid, err := os.ReadFile(filepath.Join(dir, "pid"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := os.Stat("/proc/" + strings.TrimSpace(string(pid))); err == nil {
+			t.Errorf("expected engine %s to be killed", pid)
+		}
+
+		// the result is reused until it expires
+		if cached := checkEngine(); cached != check {
+			t.Errorf("expected %+v, got %+v", check, cached)
+		}
+	})
+
+	t.Run("healthz", func(t *testing.T) {
+		r := httptest.NewRequest("GET", "/healthz", nil)
+		w := httptest.NewRecorder()
+		newRouter().ServeHTTP(w, r)
+		validateAPIResponse(t, "GET", "/healthz", w.Code, w.Header(), w.Body.Bytes())
+		if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "OK") {
+			t.Errorf("expected %v OK, got %v %q", http.StatusOK, w.Code, w.Body)
+		}
+	})
+}
+
+// takes n analysis slots, returning a func that gives them back
+func takeAnalysisSlots(t *testing.T, n int) (release func()) {
+	var done []func(error)
+	for range n {
+		d, err := startAnalysis("position")
+		if err != nil {
+			t.Fatal(err)
+		}
+		done = append(done, d)
+	}
+	return func() {
+		for _, d := range done {
+			d(nil)
+		}
+	}
+}
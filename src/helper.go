@@ -1,12 +1,10 @@
 package main
 
 import (
-	"encoding/json"
 	"fmt"
-	"io"
-	"net/http"
-	"strconv"
+	"net/url"
 	"strings"
+	"sync"
 	"time"
 	"unicode"
 
@@ -14,45 +12,11 @@ import (
 	"github.com/notnil/chess/uci"
 )
 
-// queries a URL and returns the data as a Golang map
-func queryAPI(url string) (data map[string]interface{}, err error) {
-
-	// Get all data from URL
-	resp, err := http.Get(url)
-	if err != nil {
-		err = fmt.Errorf("http.Get: %w", err)
-		return nil, WrapError(err)
-	}
-
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		err = fmt.Errorf("resp.StatusCode: %d", resp.StatusCode)
-		return nil, WrapError(err)
-	}
-
-	// Get the body of the response from the ReaderCloser interface into a Go variable 'body'
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		err = fmt.Errorf("io.ReadAll: %w", err)
-		return nil, WrapError(err)
-	}
-
-	// Convert the JSON data within 'body' to a Golang map in the 'data' var
-	err = json.Unmarshal(body, &data)
-	if err != nil {
-		err = fmt.Errorf("json.Unmarshal: %w", err)
-		return nil, WrapError(err)
-	}
-
-	return data, WrapError(err)
-}
-
 // archiveData + uuid to result
 func createResultFromArchiveDataAndUUID(ad archiveData, uuid string) (r result, err error) {
 	games, ok := ad.ArchiveData[ad.Key].([]interface{})
 	if !ok {
-		err = fmt.Errorf("key not found or is not a []interface{}")
+		err = notFoundError("archive %s of %s is not stored", ad.Key, ad.Player)
 		return result{}, WrapError(err)
 	}
 
@@ -74,70 +38,80 @@ func createResultFromArchiveDataAndUUID(ad archiveData, uuid string) (r result,
 		}
 	}
 
-	err = fmt.Errorf("game with UUID %s not found", uuid)
+	err = notFoundError("game with UUID %s not found", uuid)
 	return result{}, WrapError(err)
-	// todo: better error handling in case uuid not in archiveData
 }
 
-func moveHistoryToAnalysis(mh []*chess.MoveHistory) (a analysis, err error) {
+func moveHistoryToAnalysis(plies []gamePly) (a analysis, err error) {
 	moves := make(map[string]interface{})
 
-	turnIncrement := 0
 	whiteBestMoveHit := 0
 	whiteBestMoveMiss := 0
 	blackBestMoveHit := 0
 	blackBestMoveMiss := 0
-	for _, mh := range mh {
+	// every position before a move, then the final position unless the game is over on the board,
+	// so that the search after a move is the search before the next one
+	positions := make([]gamePosition, len(plies))
+	for i, ply := range plies {
+		positions[i] = ply.pre
+	}
+	if len(plies) > 0 && len(plies[len(plies)-1].post.position.ValidMoves()) > 0 {
+		positions = append(positions, plies[len(plies)-1].post)
+	}
+	searches, engineName, err := bestMovesFromPositions(positions)
+	if err != nil {
+		err = fmt.Errorf("bestMovesFromPositions: %w", err)
+		return analysis{}, WrapError(err)
+	}
+
+	keys := analysisMoveKeys(plies)
+	for i, ply := range plies {
 		// for each move
-		bestMove, err := bestMoveFromPosition(mh.PrePosition)
-		if err != nil {
-			err = fmt.Errorf("bestMoveFromPosition: %w", err)
-			return analysis{}, WrapError(err)
+		bestMove := searches[i].move
+		bestMoveAlgebraic := ply.pre.encodeSAN(bestMove)
+		bestMovePostFEN := ply.pre.update(bestMove).fen()
+		actualPostFEN := ply.post.fen()
+		turnString := keys[i]
+
+		eval := moveEval{Best: searches[i].eval}
+		if i+1 < len(searches) {
+			eval.Actual = &searches[i+1].eval
+		} else if ply.post.position.Status() == chess.Stalemate {
+			eval.Actual = &evaluation{}
 		}
-		bestMoveAlgebraic := chess.AlgebraicNotation{}.Encode(mh.PrePosition, bestMove)
-		bestMovePost := mh.PrePosition.Update(bestMove)
-		bestMovePostFEN := bestMovePost.String()
-
-		var turnString string
-		if mh.PrePosition.Turn() == chess.White {
-			// if it's white's turn, use a single dot in the turnString and increment white's hit/miss counters
-			turnIncrement++
-			turnString = fmt.Sprintf("%02d.", turnIncrement)
-
-			if mh.PostPosition.String() == bestMovePost.String() {
+		// positions are compared by FEN, not by pointer: comparing Black's
+		// *chess.Position pointers never matched, so analyses stored before
+		// this was fixed count every Black move as a miss and give Black 0 accuracy
+		hit := actualPostFEN == bestMovePostFEN
+
+		if ply.pre.position.Turn() == chess.White {
+			// if it's white's turn, increment white's hit/miss counters
+			if hit {
 				// if actual position after the move equals best position after the move
 				whiteBestMoveHit++
-				fmt.Println("White HIT the best move. Total:", whiteBestMoveHit)
 			} else {
 				whiteBestMoveMiss++
-				fmt.Println("White MISSED the best move. Total:", whiteBestMoveMiss)
 			}
-
-			fmt.Println(turnString, "  (White)")
 		} else {
-			// if it's black's turn, use three dots in the turnString and increment black's hit/miss counters
-			turnString = fmt.Sprintf("%02d...", turnIncrement)
-
-			if mh.PostPosition == bestMovePost {
+			// if it's black's turn, increment black's hit/miss counters
+			if hit {
 				// if actual position after the move equals best position after the move
 				blackBestMoveHit++
-				fmt.Println("Black HIT the best move. Total:", blackBestMoveHit)
 			} else {
 				blackBestMoveMiss++
-				fmt.Println("Black MISSED the best move. Total:", blackBestMoveMiss)
 			}
-
-			fmt.Println(turnString, "(Black)")
 		}
 
 		moves[turnString] = make(map[string]interface{})
-		moves[turnString].(map[string]interface{})["pre"] = mh.PrePosition.String()
+		moves[turnString].(map[string]interface{})["pre"] = ply.pre.fen()
 		moves[turnString].(map[string]interface{})["actual"] = make(map[string]string)
-		moves[turnString].(map[string]interface{})["actual"].(map[string]string)["move"] = chess.AlgebraicNotation{}.Encode(mh.PrePosition, mh.Move)
-		moves[turnString].(map[string]interface{})["actual"].(map[string]string)["post"] = mh.PostPosition.String()
+		moves[turnString].(map[string]interface{})["actual"].(map[string]string)["move"] = ply.pre.encodeSAN(ply.move)
+		moves[turnString].(map[string]interface{})["actual"].(map[string]string)["post"] = actualPostFEN
 		moves[turnString].(map[string]interface{})["best"] = make(map[string]string)
 		moves[turnString].(map[string]interface{})["best"].(map[string]string)["move"] = bestMoveAlgebraic
 		moves[turnString].(map[string]interface{})["best"].(map[string]string)["post"] = bestMovePostFEN
+		moves[turnString].(map[string]interface{})["eval"] = eval
+		moves[turnString].(map[string]interface{})["classification"] = classifyMove(hit, ply.pre.position.Turn(), eval)
 	}
 
 	whiteAccuracy := float64(whiteBestMoveHit) / float64(whiteBestMoveHit+whiteBestMoveMiss)
@@ -147,55 +121,234 @@ func moveHistoryToAnalysis(mh []*chess.MoveHistory) (a analysis, err error) {
 		Moves:         moves,
 		WhiteAccuracy: whiteAccuracy,
 		BlackAccuracy: blackAccuracy,
+		Engine: &analysisEngine{
+			Name:     engineName,
+			MoveTime: appConfig.Search.MoveTime,
+			Depth:    appConfig.Search.Depth,
+		},
+		Evaluated: true,
 	}
 
 	return a, nil
 }
 
-func pgnToMoveHistory(pgn string) (moveHistory []*chess.MoveHistory, err error) {
-	chesspgn, err := chess.PGN(strings.NewReader(pgn))
-	if err != nil {
-		err = fmt.Errorf("chess.PGN: %w", err)
-		return nil, WrapError(err)
+// the keys of the moves of an analysis: 01. for White's first move, 01... for Black's
+func analysisMoveKeys(plies []gamePly) (keys []string) {
+	turnIncrement := 0
+	for _, ply := range plies {
+		if ply.pre.position.Turn() == chess.White {
+			// a single dot for white
+			turnIncrement++
+			keys = append(keys, fmt.Sprintf("%02d.", turnIncrement))
+		} else {
+			// three dots for black, a game from a position with Black to move starts at 01...
+			if turnIncrement == 0 {
+				turnIncrement++
+			}
+			keys = append(keys, fmt.Sprintf("%02d...", turnIncrement))
+		}
 	}
+	return keys
+}
 
-	game := chess.NewGame(chesspgn)
-	moveHistory = game.MoveHistory()
-	return moveHistory, nil
+// the evaluations either side of a move, from White's point of view
+type moveEval struct {
+	Best   evaluation  `json:"best"`             // before the move, with the best move played
+	Actual *evaluation `json:"actual,omitempty"` // after the move, absent after checkmate
 }
 
-func bestMoveFromPosition(pos *chess.Position) (move *chess.Move, err error) {
-	eng, err := uci.New("stockfish")
+// how much worse than the best move a move can be, in centipawns, before it is classified
+const (
+	inaccuracyLoss = 50
+	mistakeLoss    = 100
+	blunderLoss    = 300
+)
+
+// classifies a move by the centipawns it loses against the best move:
+// best / good / inaccuracy / mistake / blunder
+func classifyMove(hit bool, turn chess.Color, eval moveEval) string {
+	if hit || eval.Actual == nil {
+		// nothing is better than checkmate
+		return "best"
+	}
+	loss := moverCentipawns(eval.Best, turn) - moverCentipawns(*eval.Actual, turn)
+	switch {
+	case loss >= blunderLoss:
+		return "blunder"
+	case loss >= mistakeLoss:
+		return "mistake"
+	case loss >= inaccuracyLoss:
+		return "inaccuracy"
+	}
+	return "good"
+}
+
+// an evaluation in centipawns for the side to move, capped so that a won position
+// stays won: giving up mate for a large advantage loses nothing
+func moverCentipawns(e evaluation, turn chess.Color) int {
+	const limit = 1000
+	cp := max(min(e.CP, limit), -limit)
+	if e.Mate > 0 {
+		cp = limit
+	} else if e.Mate < 0 {
+		cp = -limit
+	}
+	if turn == chess.Black {
+		return -cp
+	}
+	return cp
+}
+
+// starts a UCI engine configured from appConfig, for the rules of a variant
+func newEngine(variant string) (eng *uci.Engine, err error) {
+	eng, err = uci.New(appConfig.Engine.Path)
 	if err != nil {
-		err = fmt.Errorf("uci.New: %w", err)
+		err = engineUnavailableError("uci.New: %w", err)
 		return nil, WrapError(err)
-		// panic(err)
 	}
 
-	defer eng.Close()
-
+	cmds := []uci.Cmd{uci.CmdUCI}
+	for _, name := range appConfig.sortedEngineOptions() {
+		cmds = append(cmds, uci.CmdSetOption{Name: name, Value: appConfig.Engine.Options[name]})
+	}
+	if variant == variantChess960 {
+		cmds = append(cmds, uci.CmdSetOption{Name: "UCI_Chess960", Value: "true"})
+	}
 	// initialize uci with new game
-	err = eng.Run(uci.CmdUCI, uci.CmdIsReady, uci.CmdUCINewGame)
+	cmds = append(cmds, uci.CmdIsReady, uci.CmdUCINewGame)
+
+	err = eng.Run(cmds...)
 	if err != nil {
-		err = fmt.Errorf("eng.Run: %w", err)
+		eng.Close()
+		err = engineUnavailableError("eng.Run: %w", err)
 		return nil, WrapError(err)
-		// panic(err)
 	}
 
-	cmdPos := uci.CmdPosition{Position: pos}
-	// cmdGo := uci.CmdGo{Depth: 5}
-	cmdGo := uci.CmdGo{MoveTime: time.Second * 1}
+	return eng, nil
+}
 
+func bestMoveFromPosition(eng *uci.Engine, gp gamePosition) (search positionSearch, err error) {
+	cmdGo := uci.CmdGo{
+		MoveTime: time.Duration(appConfig.Search.MoveTime),
+		Depth:    appConfig.Search.Depth,
+	}
+
+	results, err := searchPosition(eng, gp, cmdGo)
+	if err != nil {
+		err = fmt.Errorf("searchPosition: %w", err)
+		return positionSearch{}, WrapError(err)
+	}
+
+	// the engine's move carries no tags (captures, checks, castling), the legal move does
+	move, err := gp.decodeMove(chess.UCINotation{}.Encode(gp.position, results.BestMove))
+	if err != nil {
+		err = fmt.Errorf("gp.decodeMove: %w", err)
+		return positionSearch{}, WrapError(err)
+	}
+
+	return positionSearch{move: move, eval: whiteEvaluation(gp.position.Turn(), results.Info.Score)}, nil
+}
+
+// the "position" command for any FEN, uci.CmdPosition can only send what the
+// chess package writes, which has no Chess960 castling rights
+type cmdPositionFEN string
+
+func (cmd cmdPositionFEN) String() string {
+	return "position fen " + string(cmd)
+}
+
+func (cmdPositionFEN) ProcessResponse(*uci.Engine) error {
+	return nil
+}
+
+// runs one search of a position, the results always hold a best move
+func searchPosition(eng *uci.Engine, gp gamePosition, cmdGo uci.CmdGo) (results uci.SearchResults, err error) {
+	cmdPos := cmdPositionFEN(gp.fen())
+
+	start := time.Now()
 	err = eng.Run(cmdPos, cmdGo)
+	engineSearchSeconds.observeSince(start)
 	if err != nil {
-		err = fmt.Errorf("eng.Run: %w", err)
-		return nil, WrapError(err)
-		// panic(err)
+		engineSearchErrors.add(1)
+		err = engineUnavailableError("eng.Run: %w", err)
+		return uci.SearchResults{}, WrapError(err)
+	}
+
+	results = eng.SearchResults()
+	if results.BestMove == nil {
+		err = engineUnavailableError("engine returned no best move for %s", gp.fen())
+		return uci.SearchResults{}, WrapError(err)
+	}
+
+	return results, nil
+}
+
+// the best move of a position and its evaluation
+type positionSearch struct {
+	move *chess.Move
+	eval evaluation
+}
+
+// finds the best move for each position of a game, spread across appConfig.Workers engines,
+// and the name the engine gives itself
+func bestMovesFromPositions(positions []gamePosition) (searches []positionSearch, engineName string, err error) {
+	done, err := startAnalysis("game")
+	if err != nil {
+		err = fmt.Errorf("startAnalysis: %w", err)
+		return nil, "", WrapError(err)
+	}
+	defer func() { done(err) }()
+
+	searches = make([]positionSearch, len(positions))
+	errs := make([]error, len(positions))
+	names := make([]string, min(appConfig.Workers, len(positions)))
+
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for w := range names {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			eng, err := newEngine(positions[0].variant)
+			if err != nil {
+				// drain this worker's share so the others can finish
+				for i := range indexes {
+					errs[i] = fmt.Errorf("newEngine: %w", err)
+				}
+				return
+			}
+			defer eng.Close()
+			names[w] = eng.ID()["name"]
+
+			for i := range indexes {
+				searches[i], errs[i] = bestMoveFromPosition(eng, positions[i])
+			}
+		}()
 	}
 
-	move = eng.SearchResults().BestMove
+	queuedPositions.Add(int64(len(positions)))
+	for i := range positions {
+		indexes <- i
+		queuedPositions.Add(-1) // a worker has it
+	}
+	close(indexes)
+	wg.Wait()
 
-	return move, nil
+	for _, err := range errs {
+		if err != nil {
+			err = fmt.Errorf("bestMoveFromPosition: %w", err)
+			return nil, "", WrapError(err)
+		}
+	}
+	for _, name := range names {
+		if name != "" {
+			engineName = name
+			break
+		}
+	}
+
+	return searches, engineName, nil
 }
 
 func epochToTime(epoch float64) time.Time {
@@ -210,16 +363,29 @@ func epochToTime(epoch float64) time.Time {
 
 func extractFromArchiveURL(archiveDataUrl string) (player string, yearStr string, monthStr string, err error) {
 	// expected format of archiveDataUrl string:
-	//   https://api.chess.com/pub/player/PLAYERNAME/games/2025/02
-	//                                    ^^^^^^^^^^       ^^^^ ^^
-	//                                    player           year month
-	//   ^^^^^   ^^^^^^^^^^^^^ ^^^ ^^^^^^ ^^^^^^^^^^ ^^^^^ ^^^^ ^^
-	//   0       2             3   4      5          6     7    8
-	parts := strings.Split(archiveDataUrl, "/")
+	//   {base URL}/player/PLAYERNAME/games/2025/02
+	// the base URL may carry a path of its own (e.g. https://api.chess.com/pub),
+	// so the segments are read from the end of the path
+	u, err := url.Parse(archiveDataUrl)
+	if err != nil {
+		err = fmt.Errorf("url.Parse: %w", err)
+		return "", "", "", WrapError(err)
+	}
 
-	player = parts[5]
-	yearStr = parts[7]
-	monthStr = parts[8]
+	parts := strings.Split(strings.Trim(u.Path, "/"), "/")
+	if len(parts) < 5 || parts[len(parts)-5] != "player" || parts[len(parts)-3] != "games" {
+		err = fmt.Errorf("invalid archive URL: %s", archiveDataUrl)
+		return "", "", "", WrapError(err)
+	}
+
+	player = parts[len(parts)-4]
+	yearStr = parts[len(parts)-2]
+	monthStr = parts[len(parts)-1]
+
+	if player == "" {
+		err = fmt.Errorf("invalid archive URL: %s", archiveDataUrl)
+		return "", "", "", WrapError(err)
+	}
 
 	// Check if yearStr is a four-digit string
 	if len(yearStr) != 4 || !isDigitsOnly(yearStr) {
@@ -236,32 +402,6 @@ func extractFromArchiveURL(archiveDataUrl string) (player string, yearStr string
 	return player, yearStr, monthStr, nil
 }
 
-func archiveToYearMonth(archive string) (year int, month time.Month, err error) {
-	// Split the archive string to get year and month
-	parts := strings.Split(archive, "-")
-	if len(parts) != 2 {
-		fmt.Println("Error: invalid archive format. Expected format 'YYYY-MM'.")
-		return 0, 0, WrapError(err)
-	}
-
-	// Parse year
-	year, err = strconv.Atoi(parts[0])
-	if err != nil {
-		err = fmt.Errorf("strconv.Atoi: %w", err)
-		return 0, 0, WrapError(err)
-	}
-
-	// Parse month
-	monthInt, err := strconv.Atoi(parts[1])
-	if err != nil {
-		fmt.Println("Error parsing month:", err)
-		return
-	}
-	month = time.Month(monthInt)
-
-	return year, month, WrapError(err)
-}
-
 // Helper function to check if a string contains only digits
 func isDigitsOnly(s string) bool {
 	for _, r := range s {
@@ -2,7 +2,8 @@ package main
 
 import (
 	"testing"
-	"time"
+
+	"github.com/notnil/chess"
 )
 
 func TestExtractFromArchiveURL(t *testing.T) {
@@ -21,6 +22,8 @@ func TestExtractFromArchiveURL(t *testing.T) {
 			{"https://api.chess.com/pub/player/asdf/games/2020/08", "asdf", "2020", "08", nil},
 			{"https://api.chess.com/pub/player/1234/games/2021/09", "1234", "2021", "09", nil},
 			{"https://api.chess.com/pub/player/4f5g/games/2022/10", "4f5g", "2022", "10", nil},
+			{"http://127.0.0.1:8080/player/asdf/games/2023/11", "asdf", "2023", "11", nil},
+			{"https://example.com/a/b/pub/player/asdf/games/2024/12/", "asdf", "2024", "12", nil},
 		}
 
 		for _, test := range tests {
@@ -41,32 +44,26 @@ func TestExtractFromArchiveURL(t *testing.T) {
 	})
 }
 
-func TestArchiveToYearMonth(t *testing.T) {
+func TestExtractFromArchiveURLInvalid(t *testing.T) {
 	type testCase struct {
 		// Input Params
-		archive string
-		// Expected Values
-		year  int
-		month time.Month
-		err   error
+		archiveDataUrl string
 	}
 
-	t.Run("archive to year and month", func(t *testing.T) {
+	t.Run("invalid archive URLs", func(t *testing.T) {
 		tests := []testCase{
-			{"2020-05", 2020, 5, nil},
-			{"2021-06", 2021, 6, nil},
+			{"https://api.chess.com/pub/player/asdf/games/archives"},
+			{"https://api.chess.com/pub/player/asdf"},
+			{"https://api.chess.com/pub/player/asdf/games/20/08"},
+			{"https://api.chess.com/pub/player/asdf/games/2020/8"},
+			{"https://api.chess.com/pub/club/asdf/games/2020/08"},
+			{"games/2020/08"},
 		}
 
 		for _, test := range tests {
-			actualYear, actualMonth, actualErr := archiveToYearMonth(test.archive)
-			if actualYear != test.year {
-				t.Errorf("expected %v, got %v", test.year, actualYear)
-			}
-			if actualMonth != test.month {
-				t.Errorf("expected %v, got %v", test.month, actualMonth)
-			}
-			if actualErr != test.err {
-				t.Errorf("expected %v, got %v", test.err, actualErr)
+			_, _, _, actualErr := extractFromArchiveURL(test.archiveDataUrl)
+			if actualErr == nil {
+				t.Errorf("%s: expected an error, got %v", test.archiveDataUrl, actualErr)
 			}
 		}
 	})
@@ -97,3 +94,42 @@ func TestIsDigitsOnly(t *testing.T) {
 		}
 	})
 }
+
+func TestClassifyMove(t *testing.T) {
+	cp := func(cp int) *evaluation { return &evaluation{CP: cp} }
+	mate := func(mate int) *evaluation { return &evaluation{Mate: mate} }
+
+	type testCase struct {
+		// Input Params
+		hit    bool
+		turn   chess.Color
+		best   evaluation
+		actual *evaluation
+		// Expected Values
+		classification string
+	}
+
+	t.Run("classify move", func(t *testing.T) {
+		tests := []testCase{
+			{true, chess.White, *cp(30), cp(-500), "best"},
+			{false, chess.White, *cp(30), nil, "best"},
+			{false, chess.White, *cp(30), cp(0), "good"},
+			{false, chess.White, *cp(30), cp(-20), "inaccuracy"},
+			{false, chess.White, *cp(30), cp(-100), "mistake"},
+			{false, chess.White, *cp(30), cp(-300), "blunder"},
+			{false, chess.Black, *cp(-30), cp(300), "blunder"},
+			{false, chess.Black, *cp(-30), cp(-20), "good"},
+			// a won position stays won
+			{false, chess.White, *mate(3), cp(1500), "good"},
+			{false, chess.White, *cp(100), mate(-2), "blunder"},
+			{false, chess.Black, *mate(-1), mate(-4), "good"},
+		}
+
+		for _, test := range tests {
+			actual := classifyMove(test.hit, test.turn, moveEval{Best: test.best, Actual: test.actual})
+			if actual != test.classification {
+				t.Errorf("%+v: expected %v, got %v", test, test.classification, actual)
+			}
+		}
+	})
+}
@@ -0,0 +1,372 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/notnil/chess"
+)
+
+// Games uploaded as PGN (over the board games, tournament bulletins) are kept
+// in the imported table, keyed by an ID derived from their tags and moves,
+// so uploading the same game twice stores it once.
+// They are stored in the chess.com game JSON shape, like every other game,
+// so results and analysis work on them unchanged.
+
+// the source recorded on imported games
+const importedSource = "imported"
+
+type importedGame struct {
+	Index int    `json:"index"` // position in the upload, from 0
+	UUID  string `json:"uuid,omitempty"`
+	White string `json:"white,omitempty"`
+	Black string `json:"black,omitempty"`
+	Added bool   `json:"added"` // false if already imported or invalid
+	Error string `json:"error,omitempty"`
+}
+
+type importReport struct {
+	Games   []importedGame `json:"games"`
+	Added   int            `json:"added"`
+	Skipped int            `json:"skipped"` // already imported
+	Invalid int            `json:"invalid"`
+}
+
+func (ir *importReport) prettyPrint() (s string, err error) {
+	data, err := json.MarshalIndent(ir, "", "  ")
+	if err != nil {
+		err = fmt.Errorf("json.MarshalIndent: %w", err)
+		return "", WrapError(err)
+	}
+	return string(data), nil
+}
+
+// imports every game in a single or multi-game PGN
+// invalid games are reported and skipped, the others are stored
+func importPGN(text string) (report importReport, err error) {
+	report = importReport{Games: []importedGame{}}
+
+	db, err := newDatabase("imported", "")
+	if err != nil {
+		err = fmt.Errorf("newDatabase: %w", err)
+		return importReport{}, WrapError(err)
+	}
+
+	added := make(map[string]interface{})
+	for i, pgn := range splitPGN(text) {
+		ig := importedGame{Index: i}
+
+		gameMap, err := pgnToGameMap(pgn)
+		if err != nil {
+			ig.Error = err.Error()
+			report.Invalid++
+			report.Games = append(report.Games, ig)
+			continue
+		}
+		ig.UUID = gameMap["uuid"].(string)
+		ig.White = gameMap["white"].(map[string]interface{})["username"].(string)
+		ig.Black = gameMap["black"].(map[string]interface{})["username"].(string)
+
+		_, stored := db.Data[ig.UUID]
+		_, seen := added[ig.UUID]
+		if stored || seen {
+			report.Skipped++
+		} else {
+			ig.Added = true
+			added[ig.UUID] = gameMap
+			report.Added++
+		}
+		report.Games = append(report.Games, ig)
+	}
+
+	if len(added) > 0 {
+		err = db.writeData(added)
+		if err != nil {
+			err = fmt.Errorf("db.writeData: %w", err)
+			return importReport{}, WrapError(err)
+		}
+
+		uuids := make([]string, 0, len(added))
+		for uuid := range added {
+			uuids = append(uuids, uuid)
+		}
+		err = indexImportedGames(uuids)
+		if err != nil {
+			err = fmt.Errorf("indexImportedGames: %w", err)
+			return importReport{}, WrapError(err)
+		}
+	}
+
+	return report, nil
+}
+
+// splits a multi-game PGN into the text of each game
+// a game ends where a tag line follows a blank line after its movetext,
+// so movetext and comments that start with [ stay in their game
+func splitPGN(text string) (pgns []string) {
+	var current strings.Builder
+	inMoves, inComment, afterBlank := false, false, false
+
+	flush := func() {
+		if pgn := strings.TrimSpace(current.String()); pgn != "" {
+			pgns = append(pgns, pgn+"\n")
+		}
+		current.Reset()
+		inMoves = false
+	}
+
+	scanner := bufio.NewScanner(strings.NewReader(text))
+	scanner.Buffer(make([]byte, 64*1024), 4*1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		isTag := !inComment && pgnTagRegexp.MatchString(line)
+		if isTag && inMoves && afterBlank {
+			flush()
+		}
+		if line != "" && !isTag {
+			inMoves = true
+			inComment = endsInComment(line, inComment)
+		}
+		afterBlank = line == "" && !inComment
+		current.WriteString(line + "\n")
+	}
+	flush()
+
+	return pgns
+}
+
+// reports whether a movetext line ends inside a {} comment
+// a ; comment runs to the end of the line and hides any brace in it
+func endsInComment(line string, inComment bool) bool {
+	for _, r := range line {
+		switch {
+		case inComment && r == '}':
+			inComment = false
+		case !inComment && r == '{':
+			inComment = true
+		case !inComment && r == ';':
+			return false
+		}
+	}
+	return inComment
+}
+
+// parses a single game PGN into the chess.com game JSON shape
+func pgnToGameMap(pgn string) (gameMap map[string]interface{}, err error) {
+	tags, sans, outcome, err := importedMoves(pgn)
+	if err != nil {
+		err = fmt.Errorf("importedMoves: %w", err)
+		return nil, WrapError(err)
+	}
+	if len(sans) == 0 {
+		err = fmt.Errorf("game has no moves")
+		return nil, WrapError(err)
+	}
+
+	tag := func(name string) string {
+		for _, tp := range tags {
+			if tp.Key == name && !strings.Contains(tp.Value, "?") {
+				return tp.Value
+			}
+		}
+		return ""
+	}
+	variant, _ := parseVariant(tag("Variant"))
+
+	whiteResult, blackResult := "unfinished", "unfinished"
+	switch outcome {
+	case chess.WhiteWon:
+		whiteResult, blackResult = "win", "lose"
+	case chess.BlackWon:
+		whiteResult, blackResult = "lose", "win"
+	case chess.Draw:
+		whiteResult, blackResult = "agreed", "agreed"
+	}
+
+	timeControl := tag("TimeControl")
+	if timeControl == "" {
+		timeControl = "-"
+	}
+
+	url := ""
+	if site := tag("Site"); strings.HasPrefix(site, "http://") || strings.HasPrefix(site, "https://") {
+		url = site
+	}
+
+	gameMap = map[string]interface{}{
+		"uuid":         importedGameID(tags, sans, outcome),
+		"url":          url,
+		"pgn":          pgn,
+		"end_time":     float64(pgnGameTime(tag).Unix()),
+		"time_class":   timeClassFromTimeControl(timeControl),
+		"time_control": timeControl,
+		"rated":        false,
+		"rules":        variant,
+		"white":        importedPlayer(tag("White"), tag("WhiteElo"), whiteResult),
+		"black":        importedPlayer(tag("Black"), tag("BlackElo"), blackResult),
+		"source":       importedSource,
+	}
+
+	return gameMap, nil
+}
+
+// replays a PGN under the rules of its Variant tag, returning its tags, its moves in SAN and its outcome
+// standard games are read by the chess package, as they were before variants, so their IDs are unchanged
+func importedMoves(pgn string) (tags []*chess.TagPair, sans []string, outcome chess.Outcome, err error) {
+	tags, _, outcome = parsePGN(pgn)
+	variant := ""
+	for _, tp := range tags {
+		if tp.Key == "Variant" {
+			variant = tp.Value
+		}
+	}
+	variant, err = parseVariant(variant)
+	if err != nil {
+		err = fmt.Errorf("parseVariant: %w", err)
+		return nil, nil, "", WrapError(err)
+	}
+
+	if variant == variantChess {
+		chesspgn, err := chess.PGN(strings.NewReader(pgn))
+		if err != nil {
+			err = fmt.Errorf("chess.PGN: %w", err)
+			return nil, nil, "", WrapError(err)
+		}
+		game := chess.NewGame(chesspgn)
+		for _, mh := range game.MoveHistory() {
+			sans = append(sans, chess.AlgebraicNotation{}.Encode(mh.PrePosition, mh.Move))
+		}
+		return game.TagPairs(), sans, game.Outcome(), nil
+	}
+
+	_, plies, err := replayPGN(pgn, variant, "")
+	if err != nil {
+		err = fmt.Errorf("replayPGN: %w", err)
+		return nil, nil, "", WrapError(err)
+	}
+	for _, ply := range plies {
+		sans = append(sans, ply.pre.encodeSAN(ply.move))
+	}
+	return tags, sans, outcome, nil
+}
+
+// a stable ID from the game's tags, moves and outcome,
+// independent of whitespace, comments and tag order in the PGN text
+func importedGameID(tagPairs []*chess.TagPair, sans []string, outcome chess.Outcome) string {
+	tags := make([]string, 0, len(tagPairs))
+	for _, tp := range tagPairs {
+		tags = append(tags, tp.Key+"="+tp.Value)
+	}
+	sort.Strings(tags)
+
+	sum := sha256.Sum256([]byte(strings.Join(tags, "\n") + "\n\n" + strings.Join(sans, " ") + " " + outcome.String()))
+	return hex.EncodeToString(sum[:16])
+}
+
+func importedPlayer(name string, elo string, result string) (p map[string]interface{}) {
+	if name == "" {
+		name = "?"
+	}
+	rating, _ := strconv.ParseFloat(elo, 64)
+	return map[string]interface{}{
+		"username": name,
+		"uuid":     "",
+		"rating":   rating,
+		"result":   result,
+	}
+}
+
+// the time the game was played from its date tags, the zero Unix time if they are missing
+func pgnGameTime(tag func(string) string) time.Time {
+	date := tag("UTCDate")
+	if date == "" {
+		date = tag("Date")
+	}
+	day, err := time.Parse("2006.01.02", date)
+	if err != nil {
+		return time.Unix(0, 0)
+	}
+
+	clock := tag("UTCTime")
+	if clock == "" {
+		clock = tag("StartTime")
+	}
+	if t, err := time.Parse("2006.01.02 15:04:05", date+" "+clock); err == nil {
+		return t
+	}
+	return day
+}
+
+// classifies a PGN TimeControl the way chess.com does, by the estimated game length
+// e.g. "180+2" -> "blitz", "1/86400" -> "daily", "40/7200:3600" -> "rapid"
+func timeClassFromTimeControl(timeControl string) string {
+	if strings.HasPrefix(timeControl, "1/") {
+		return "daily"
+	}
+
+	// the first period of a multi-period control, e.g. "40/7200" of "40/7200:3600"
+	period, _, _ := strings.Cut(timeControl, ":")
+	if _, seconds, found := strings.Cut(period, "/"); found {
+		period = seconds
+	}
+	baseStr, incrementStr, _ := strings.Cut(period, "+")
+	base, err := strconv.Atoi(baseStr)
+	if err != nil {
+		return ""
+	}
+	increment, _ := strconv.Atoi(incrementStr)
+
+	switch estimated := base + 40*increment; {
+	case estimated < 180:
+		return "bullet"
+	case estimated < 600:
+		return "blitz"
+	default:
+		return "rapid"
+	}
+}
+
+// reads an imported game
+func readImportedGame(uuid string) (gameMap map[string]interface{}, err error) {
+	db, err := newDatabase("imported", "")
+	if err != nil {
+		err = fmt.Errorf("newDatabase: %w", err)
+		return nil, WrapError(err)
+	}
+
+	gameMap, ok := db.Data[uuid].(map[string]interface{})
+	if !ok {
+		err = notFoundError("imported game with UUID %s not found", uuid)
+		return nil, WrapError(err)
+	}
+
+	return gameMap, nil
+}
+
+// lists the imported games, and whether each has been analyzed
+func listImported() (present map[string]bool, err error) {
+	db, err := newDatabase("imported", "")
+	if err != nil {
+		err = fmt.Errorf("newDatabase: %w", err)
+		return nil, WrapError(err)
+	}
+	analyses, err := newDatabase("analysis", "")
+	if err != nil {
+		err = fmt.Errorf("newDatabase: %w", err)
+		return nil, WrapError(err)
+	}
+
+	present = make(map[string]bool)
+	for uuid := range db.Data {
+		_, present[uuid] = analyses.Data[uuid]
+	}
+
+	return present, nil
+}
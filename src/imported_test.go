@@ -0,0 +1,185 @@
+package main
+
+import (
+	"io"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestImportPGN(t *testing.T) {
+	appConfig.DataDir = t.TempDir()
+	defer func() { appConfig = defaultConfig() }()
+
+	contents, err := os.ReadFile("testdata/bulletin.pgn")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := importPGN(string(contents))
+	if err != nil {
+		t.Fatalf("expected %v, got %v", nil, err)
+	}
+	if report.Added != 2 || report.Skipped != 0 || report.Invalid != 1 {
+		t.Errorf("expected 2 added, 0 skipped, 1 invalid, got %+v", report)
+	}
+	if report.Games[2].Error == "" {
+		t.Errorf("expected an error for the illegal move, got %+v", report.Games[2])
+	}
+
+	// the same games with different whitespace and comments are the same games
+	reformatted := strings.ReplaceAll(string(contents), "{Black misses the threat} ", "")
+	reformatted = strings.ReplaceAll(reformatted, "\n3. Nc3", " 3. Nc3")
+	report, err = importPGN(reformatted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Added != 0 || report.Skipped != 2 {
+		t.Errorf("expected 0 added, 2 skipped, got %+v", report)
+	}
+
+	gameMap, err := readImportedGame(report.Games[0].UUID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := NewResult(gameMap)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Source != "imported" || r.PlayerWhite.Username != "Smith, A" || r.PlayerWhite.Rating != 1850 || r.MoveCount != 4 || r.TimeClass != "rapid" {
+		t.Errorf("unexpected result %+v", r)
+	}
+}
+
+func TestTimeClassFromTimeControl(t *testing.T) {
+	type testCase struct {
+		// Input Params
+		timeControl string
+		// Expected Values
+		timeClass string
+	}
+
+	t.Run("time class from time control", func(t *testing.T) {
+		tests := []testCase{
+			{"60", "bullet"},
+			{"120+1", "bullet"},
+			{"180+2", "blitz"},
+			{"600", "rapid"},
+			{"1/86400", "daily"},
+			{"40/7200:3600", "rapid"},
+			{"-", ""},
+		}
+
+		for _, test := range tests {
+			actual := timeClassFromTimeControl(test.timeControl)
+			if actual != test.timeClass {
+				t.Errorf("%s: expected %v, got %v", test.timeControl, test.timeClass, actual)
+			}
+		}
+	})
+}
+
+func TestSplitPGN(t *testing.T) {
+	type testCase struct {
+		// Input Params
+		name string
+		text string
+		// Expected Values
+		games int
+	}
+
+	tags := "[Event \"Casual\"]\n[Result \"*\"]\n\n"
+	t.Run("split pgn", func(t *testing.T) {
+		tests := []testCase{
+			{"one game", tags + "1. e4 e5 *\n", 1},
+			{"two games", tags + "1. e4 e5 *\n\n" + tags + "1. d4 d5 *\n", 2},
+			{"clock comment at the start of a line", tags + "1. e4 {\n[%clk 0:05:00]} e5 *\n", 1},
+			{"tag-like line in a comment after a blank line", tags + "1. e4 {a note\n\n[Event \"quoted\"]} e5 *\n", 1},
+			{"tag-like line right after movetext", tags + "1. e4 e5\n[Event \"Casual\"]\n2. Nf3 *\n", 1},
+			{"brace in a rest of line comment", tags + "1. e4 ; {\n\n" + tags + "1. d4 *\n", 2},
+		}
+
+		for _, test := range tests {
+			actual := splitPGN(test.text)
+			if len(actual) != test.games {
+				t.Errorf("%s: expected %v games, got %v: %q", test.name, test.games, len(actual), actual)
+			}
+		}
+	})
+}
+
+func TestImportedEndToEnd(t *testing.T) {
+	setConfig(fakeEngineConfig(t, nil))
+	defer setConfig(defaultConfig())
+
+	api := httptest.NewServer(newRouter())
+	defer api.Close()
+
+	contents, err := os.ReadFile("testdata/bulletin.pgn")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pgn := strings.SplitAfter(string(contents), "1-0\n")[0]
+	resp, err := api.Client().Post(api.URL+"/api/pgn", "application/x-chess-pgn", strings.NewReader(pgn))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	validateAPIResponse(t, "POST", api.URL+"/api/pgn", resp.StatusCode, resp.Header, data)
+	if resp.StatusCode != 200 {
+		t.Fatalf("expected %v, got %v", 200, resp.StatusCode)
+	}
+
+	present, err := listImported()
+	if err != nil || len(present) != 1 {
+		t.Fatalf("expected one imported game, got %v (%v)", present, err)
+	}
+	var uuid string
+	for uuid = range present {
+	}
+
+	type testCase struct {
+		// Input Params
+		method string
+		path   string
+		// Expected Values
+		status int
+		body   string // the response must contain this
+	}
+
+	t.Run("analyze imported", func(t *testing.T) {
+		tests := []testCase{
+			{"GET", "/api/pgn/games", 200, `"` + uuid + `": false`},
+			{"POST", "/api/pgn/games/" + uuid, 200, "Result Updated"},
+			{"GET", "/api/pgn/games", 200, `"` + uuid + `": true`},
+			{"GET", "/api/pgn/games/" + uuid, 200, `"source": "imported"`},
+			{"GET", "/api/pgn/games/" + uuid + ".pgn", 200, `[Annotator "chess-analyzer"]`},
+			{"GET", "/api/games/" + uuid, 200, `"source": "imported"`},
+			{"GET", "/api/pgn/games/" + strings.Repeat("0", 32), 404, "not found"},
+			{"GET", "/api/pgn/games/nothere", 400, "invalid imported game ID"},
+		}
+
+		for _, test := range tests {
+			status, body := apiRequest(t, test.method, api.URL+test.path)
+			if status != test.status {
+				t.Errorf("%s %s: expected %v, got %v (%s)", test.method, test.path, test.status, status, body)
+			}
+			if !strings.Contains(body, test.body) {
+				t.Errorf("%s %s: expected %q in %q", test.method, test.path, test.body, body)
+			}
+		}
+	})
+
+	report, err := runFsck(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Problems) != 0 {
+		t.Errorf("expected no problems, got %+v", report.Problems)
+	}
+}
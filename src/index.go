@@ -0,0 +1,521 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"maps"
+	"net/url"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The game index holds a summary of every stored game for a player,
+// plus inverted indexes from each queryable field to the game UUIDs.
+// It is rebuilt for a month whenever that month's archive is refreshed,
+// so queries never need to decode the archive data.
+
+// summary of a single game from the indexed player's point of view
+type gameSummary struct {
+	UUID           string    `json:"uuid"`
+	Source         string    `json:"source"`  // chess.com / lichess
+	Archive        string    `json:"archive"` // YYYY-MM
+	Date           time.Time `json:"date"`
+	Colour         string    `json:"colour"` // white / black
+	Opponent       string    `json:"opponent"`
+	PlayerRating   float64   `json:"player_rating"`
+	OpponentRating float64   `json:"opponent_rating"`
+	Result         string    `json:"result"`  // win / draw / loss
+	Outcome        string    `json:"outcome"` // chess.com result code, e.g. resigned
+	TimeClass      string    `json:"time_class"`
+	TimeControl    string    `json:"time_control"`
+	Rated          bool      `json:"rated"`
+	ECO            string    `json:"eco"`
+	Opening        string    `json:"opening"`
+}
+
+type gameIndex struct {
+	Games       map[string]gameSummary `json:"games"`
+	ByOpponent  map[string][]string    `json:"by_opponent"`
+	ByDate      map[string][]string    `json:"by_date"` // YYYY-MM-DD
+	ByTimeClass map[string][]string    `json:"by_time_class"`
+	ByResult    map[string][]string    `json:"by_result"`
+	ByECO       map[string][]string    `json:"by_eco"`
+	ByRated     map[string][]string    `json:"by_rated"` // "true" / "false"
+}
+
+// chess.com result codes that end the game in a draw
+var drawResults = map[string]bool{
+	"agreed":             true,
+	"repetition":         true,
+	"stalemate":          true,
+	"insufficient":       true,
+	"50move":             true,
+	"timevsinsufficient": true,
+}
+
+// creates a summary from a chess.com game map, from the point of view of player
+func newGameSummary(player string, archive string, gameMap map[string]interface{}) (gs gameSummary, err error) {
+	whiteMap, okWhite := gameMap["white"].(map[string]interface{})
+	blackMap, okBlack := gameMap["black"].(map[string]interface{})
+	uuid, okUUID := gameMap["uuid"].(string)
+	if !okWhite || !okBlack || !okUUID {
+		err = fmt.Errorf("game is missing 'uuid', 'white' or 'black'")
+		return gameSummary{}, WrapError(err)
+	}
+
+	playerMap, opponentMap := whiteMap, blackMap
+	colour := "white"
+	if whiteUsername, _ := whiteMap["username"].(string); !strings.EqualFold(whiteUsername, player) {
+		playerMap, opponentMap = blackMap, whiteMap
+		colour = "black"
+	}
+
+	outcome, _ := playerMap["result"].(string)
+	result := "loss"
+	if outcome == "win" {
+		result = "win"
+		outcome, _ = opponentMap["result"].(string)
+	} else if drawResults[outcome] {
+		result = "draw"
+	}
+
+	endTime, _ := gameMap["end_time"].(float64)
+	pgn, _ := gameMap["pgn"].(string)
+
+	gs = gameSummary{
+		UUID:    uuid,
+		Archive: archive,
+		Date:    epochToTime(endTime).UTC(),
+		Colour:  colour,
+		Result:  result,
+		Outcome: outcome,
+		ECO:     pgnTag(pgn, "ECO"),
+		Opening: openingFromECOURL(pgnTag(pgn, "ECOUrl")),
+	}
+	if gs.Opening == "" {
+		// Lichess and imported games name the opening in a tag of its own
+		gs.Opening = pgnTag(pgn, "Opening")
+	}
+	gs.Opponent, _ = opponentMap["username"].(string)
+	gs.PlayerRating, _ = playerMap["rating"].(float64)
+	gs.OpponentRating, _ = opponentMap["rating"].(float64)
+	gs.Source = gameSourceName(gameMap)
+	gs.TimeClass, _ = gameMap["time_class"].(string)
+	gs.TimeControl, _ = gameMap["time_control"].(string)
+	gs.Rated, _ = gameMap["rated"].(bool)
+
+	return gs, nil
+}
+
+var pgnTagRegexp = regexp.MustCompile(`(?m)^\[(\w+)\s+"((?:[^"\\]|\\.)*)"\]`)
+
+// returns the value of a PGN header tag, or "" if absent
+func pgnTag(pgn string, name string) string {
+	for _, match := range pgnTagRegexp.FindAllStringSubmatch(pgn, -1) {
+		if match[1] == name {
+			return strings.ReplaceAll(match[2], `\"`, `"`)
+		}
+	}
+	return ""
+}
+
+// extracts the opening name from a chess.com ECOUrl tag
+// e.g. ".../openings/Scandinavian-Defense-Mieses-Kotrc-Variation" -> "Scandinavian Defense Mieses Kotrc Variation"
+func openingFromECOURL(ecoURL string) string {
+	u, err := url.Parse(ecoURL)
+	if err != nil || ecoURL == "" {
+		return ""
+	}
+	parts := strings.Split(strings.Trim(u.Path, "/"), "/")
+	return strings.ReplaceAll(parts[len(parts)-1], "-", " ")
+}
+
+func newGameIndex() gameIndex {
+	return gameIndex{Games: make(map[string]gameSummary)}
+}
+
+// reads a player's game index from the database
+func readGameIndex(site gameSource, player string) (gi gameIndex, err error) {
+	db, err := newDatabase(site.contentType("game_index"), player)
+	if err != nil {
+		err = fmt.Errorf("newDatabase: %w", err)
+		return gameIndex{}, WrapError(err)
+	}
+
+	gi, err = gameIndexFromData(db.Data)
+	if err != nil {
+		err = fmt.Errorf("gameIndexFromData: %w", err)
+		return gameIndex{}, WrapError(err)
+	}
+
+	return gi, nil
+}
+
+// round trips through JSON to get from the table's map to the typed index
+func gameIndexFromData(data map[string]interface{}) (gi gameIndex, err error) {
+	dataJSON, err := json.Marshal(data)
+	if err != nil {
+		err = fmt.Errorf("json.Marshal: %w", err)
+		return gameIndex{}, WrapError(err)
+	}
+	gi = newGameIndex()
+	err = json.Unmarshal(dataJSON, &gi)
+	if err != nil {
+		err = fmt.Errorf("json.Unmarshal: %w", err)
+		return gameIndex{}, WrapError(err)
+	}
+	if gi.Games == nil {
+		gi.Games = make(map[string]gameSummary)
+	}
+
+	return gi, nil
+}
+
+// rebuilds the inverted indexes and round trips through JSON to the table's map
+func (gi *gameIndex) toData() (data map[string]interface{}, err error) {
+	gi.rebuild()
+
+	indexJSON, err := json.Marshal(gi)
+	if err != nil {
+		err = fmt.Errorf("json.Marshal: %w", err)
+		return nil, WrapError(err)
+	}
+	data = make(map[string]interface{})
+	err = json.Unmarshal(indexJSON, &data)
+	if err != nil {
+		err = fmt.Errorf("json.Unmarshal: %w", err)
+		return nil, WrapError(err)
+	}
+
+	return data, nil
+}
+
+// writes a player's game index to the database, rebuilding the inverted indexes
+func (gi *gameIndex) write(site gameSource, player string) (err error) {
+	data, err := gi.toData()
+	if err != nil {
+		err = fmt.Errorf("gi.toData: %w", err)
+		return WrapError(err)
+	}
+
+	db, err := newDatabase(site.contentType("game_index"), player)
+	if err != nil {
+		err = fmt.Errorf("newDatabase: %w", err)
+		return WrapError(err)
+	}
+
+	err = db.writeData(data)
+	if err != nil {
+		err = fmt.Errorf("db.writeData: %w", err)
+		return WrapError(err)
+	}
+
+	return nil
+}
+
+// recomputes the inverted indexes from Games
+func (gi *gameIndex) rebuild() {
+	gi.ByOpponent = make(map[string][]string)
+	gi.ByDate = make(map[string][]string)
+	gi.ByTimeClass = make(map[string][]string)
+	gi.ByResult = make(map[string][]string)
+	gi.ByECO = make(map[string][]string)
+	gi.ByRated = make(map[string][]string)
+
+	uuids := make([]string, 0, len(gi.Games))
+	for uuid := range gi.Games {
+		uuids = append(uuids, uuid)
+	}
+	sort.Strings(uuids)
+
+	for _, uuid := range uuids {
+		gs := gi.Games[uuid]
+		gi.ByOpponent[strings.ToLower(gs.Opponent)] = append(gi.ByOpponent[strings.ToLower(gs.Opponent)], uuid)
+		gi.ByDate[gs.Date.Format(time.DateOnly)] = append(gi.ByDate[gs.Date.Format(time.DateOnly)], uuid)
+		gi.ByTimeClass[gs.TimeClass] = append(gi.ByTimeClass[gs.TimeClass], uuid)
+		gi.ByResult[gs.Result] = append(gi.ByResult[gs.Result], uuid)
+		gi.ByECO[gs.ECO] = append(gi.ByECO[gs.ECO], uuid)
+		gi.ByRated[strconv.FormatBool(gs.Rated)] = append(gi.ByRated[strconv.FormatBool(gs.Rated)], uuid)
+	}
+}
+
+// replaces the games of one archive month with the games in ad
+func (gi *gameIndex) updateArchive(ad archiveData) (err error) {
+	for uuid, gs := range gi.Games {
+		if gs.Archive == ad.Key {
+			delete(gi.Games, uuid)
+		}
+	}
+
+	games, ok := ad.ArchiveData[ad.Key].([]interface{})
+	if !ok {
+		err = fmt.Errorf("key not found or is not []interface{}")
+		return WrapError(err)
+	}
+
+	for _, game := range games {
+		gameMap, ok := game.(map[string]interface{})
+		if !ok {
+			err = fmt.Errorf("game is not a map[string]interface{}")
+			return WrapError(err)
+		}
+
+		gs, err := newGameSummary(ad.Player, ad.Key, gameMap)
+		if err != nil {
+			err = fmt.Errorf("newGameSummary: %w", err)
+			return WrapError(err)
+		}
+		gi.Games[gs.UUID] = gs
+	}
+
+	return nil
+}
+
+// updates the player's game index after an archive month has been refreshed
+// the index is read, updated and written under one lock, so that concurrent
+// refreshes of different months of a player do not drop each other's games
+func updateGameIndex(ad archiveData) (err error) {
+	db, err := newDatabase(ad.Site.contentType("game_index"), ad.Player)
+	if err != nil {
+		err = fmt.Errorf("newDatabase: %w", err)
+		return WrapError(err)
+	}
+
+	tablesMu.Lock()
+	defer tablesMu.Unlock()
+
+	err = db.load() // refresh the data in the database object
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		err = fmt.Errorf("db.load: %w", err)
+		return WrapError(err)
+	}
+
+	gi, err := gameIndexFromData(db.Data)
+	if err != nil {
+		err = fmt.Errorf("gameIndexFromData: %w", err)
+		return WrapError(err)
+	}
+
+	err = gi.updateArchive(ad)
+	if err != nil {
+		err = fmt.Errorf("gi.updateArchive: %w", err)
+		return WrapError(err)
+	}
+
+	data, err := gi.toData()
+	if err != nil {
+		err = fmt.Errorf("gi.toData: %w", err)
+		return WrapError(err)
+	}
+	maps.Copy(db.Data, data)
+
+	err = db.save()
+	if err != nil {
+		err = fmt.Errorf("db.save: %w", err)
+		return WrapError(err)
+	}
+
+	return nil
+}
+
+// rebuilds every player's game index from the stored archive data
+func reindexAll() (err error) {
+	tableNames, err := listTables()
+	if err != nil {
+		err = fmt.Errorf("listTables: %w", err)
+		return WrapError(err)
+	}
+
+	for _, tableName := range tableNames {
+		player, contentType, _ := parseTableName(tableName)
+		site, base := sourceOfContentType(contentType)
+		if base != "archive_data" {
+			continue
+		}
+
+		indexed, err := reindexPlayer(site, player)
+		if err != nil {
+			err = fmt.Errorf("reindexPlayer(%s): %w", player, err)
+			return WrapError(err)
+		}
+		fmt.Printf("%s (%s): indexed %d games\n", player, site.name(), indexed)
+	}
+
+	indexed, err := rebuildUUIDIndex()
+	if err != nil {
+		err = fmt.Errorf("rebuildUUIDIndex: %w", err)
+		return WrapError(err)
+	}
+	fmt.Printf("UUID index: indexed %d games\n", indexed)
+
+	return nil
+}
+
+// rebuilds a player's game index from their stored archive data
+func reindexPlayer(site gameSource, player string) (indexed int, err error) {
+	db, err := newDatabase(site.contentType("archive_data"), player)
+	if err != nil {
+		err = fmt.Errorf("newDatabase: %w", err)
+		return 0, WrapError(err)
+	}
+
+	gi := newGameIndex()
+	for key := range db.Data {
+		year, month, err := archiveToYearMonth(key)
+		if err != nil {
+			err = fmt.Errorf("archiveToYearMonth: %w", err)
+			return 0, WrapError(err)
+		}
+		ad := archiveData{
+			Site:        site,
+			Player:      player,
+			Year:        year,
+			Month:       month,
+			Key:         key,
+			ArchiveData: map[string]interface{}{key: db.Data[key]},
+		}
+		err = gi.updateArchive(ad)
+		if err != nil {
+			err = fmt.Errorf("gi.updateArchive(%s): %w", key, err)
+			return 0, WrapError(err)
+		}
+		err = updateUUIDIndex(ad)
+		if err != nil {
+			err = fmt.Errorf("updateUUIDIndex(%s): %w", key, err)
+			return 0, WrapError(err)
+		}
+	}
+
+	err = gi.write(site, player)
+	if err != nil {
+		err = fmt.Errorf("gi.write: %w", err)
+		return 0, WrapError(err)
+	}
+
+	return len(gi.Games), nil
+}
+
+// gameQuery selects games from the index, empty fields match everything
+type gameQuery struct {
+	Opponent  string
+	TimeClass string
+	Result    string
+	ECO       string
+	Rated     string    // "true" / "false"
+	From      time.Time // inclusive
+	To        time.Time // exclusive
+}
+
+// parses a gameQuery from URL query parameters
+func newGameQuery(values url.Values) (q gameQuery, err error) {
+	q = gameQuery{
+		Opponent:  strings.ToLower(values.Get("opponent")),
+		TimeClass: values.Get("time_class"),
+		Result:    values.Get("result"),
+		ECO:       values.Get("eco"),
+		Rated:     values.Get("rated"),
+	}
+
+	if q.Rated != "" && q.Rated != "true" && q.Rated != "false" {
+		err = invalidInputError("rated must be true or false, got %q", q.Rated)
+		return gameQuery{}, WrapError(err)
+	}
+	if q.Result != "" && q.Result != "win" && q.Result != "draw" && q.Result != "loss" {
+		err = invalidInputError("result must be win, draw or loss, got %q", q.Result)
+		return gameQuery{}, WrapError(err)
+	}
+	if from := values.Get("from"); from != "" {
+		q.From, err = time.Parse(time.DateOnly, from)
+		if err != nil {
+			err = invalidInputError("from: time.Parse: %w", err)
+			return gameQuery{}, WrapError(err)
+		}
+	}
+	if to := values.Get("to"); to != "" {
+		q.To, err = time.Parse(time.DateOnly, to)
+		if err != nil {
+			err = invalidInputError("to: time.Parse: %w", err)
+			return gameQuery{}, WrapError(err)
+		}
+		q.To = q.To.AddDate(0, 0, 1) // the whole of the `to` day is included
+	}
+
+	return q, nil
+}
+
+// returns the games matching q, most recent first
+func (gi *gameIndex) query(q gameQuery) (games []gameSummary) {
+	// each set filter narrows the candidates via its inverted index
+	var candidates []string
+	narrow := func(uuids []string) {
+		if candidates == nil {
+			candidates = slices.Clone(uuids)
+			if candidates == nil {
+				candidates = []string{}
+			}
+			return
+		}
+		keep := make(map[string]bool, len(uuids))
+		for _, uuid := range uuids {
+			keep[uuid] = true
+		}
+		candidates = slices.DeleteFunc(candidates, func(uuid string) bool {
+			return !keep[uuid]
+		})
+	}
+
+	if q.Opponent != "" {
+		narrow(gi.ByOpponent[q.Opponent])
+	}
+	if q.TimeClass != "" {
+		narrow(gi.ByTimeClass[q.TimeClass])
+	}
+	if q.Result != "" {
+		narrow(gi.ByResult[q.Result])
+	}
+	if q.ECO != "" {
+		narrow(gi.ByECO[q.ECO])
+	}
+	if q.Rated != "" {
+		narrow(gi.ByRated[q.Rated])
+	}
+	if !q.From.IsZero() || !q.To.IsZero() {
+		var inRange []string
+		for date, uuids := range gi.ByDate {
+			day, err := time.Parse(time.DateOnly, date)
+			if err != nil {
+				continue
+			}
+			if (q.From.IsZero() || !day.Before(q.From)) && (q.To.IsZero() || day.Before(q.To)) {
+				inRange = append(inRange, uuids...)
+			}
+		}
+		narrow(inRange)
+	}
+
+	if candidates == nil {
+		// no filters, every game matches
+		for uuid := range gi.Games {
+			candidates = append(candidates, uuid)
+		}
+	}
+
+	games = make([]gameSummary, 0, len(candidates))
+	for _, uuid := range candidates {
+		games = append(games, gi.Games[uuid])
+	}
+	sort.Slice(games, func(i, j int) bool {
+		if games[i].Date.Equal(games[j].Date) {
+			return games[i].UUID < games[j].UUID
+		}
+		return games[i].Date.After(games[j].Date)
+	})
+
+	return games
+}
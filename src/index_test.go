@@ -0,0 +1,135 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"net/url"
+	"os"
+	"sync"
+	"testing"
+)
+
+// loads a fixture archive month as archiveData
+func fixtureArchiveData(t *testing.T, player string, key string, path string) archiveData {
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	apiData := make(map[string]interface{})
+	err = json.Unmarshal(contents, &apiData)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return archiveData{
+		Player:      player,
+		Key:         key,
+		ArchiveData: map[string]interface{}{key: apiData["games"]},
+	}
+}
+
+func TestGameIndexQuery(t *testing.T) {
+	gi := newGameIndex()
+	err := gi.updateArchive(fixtureArchiveData(t, "asdf", "2025-02", "testdata/asdf_2025_02.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	gi.rebuild()
+
+	type testCase struct {
+		// Input Params
+		query string
+		// Expected Values
+		uuids []string
+	}
+
+	t.Run("query game index", func(t *testing.T) {
+		tests := []testCase{
+			{"", []string{"8e1f2c3a-0003-11ef-8000-000000000003", "8e1f2c3a-0002-11ef-8000-000000000002", "8e1f2c3a-0001-11ef-8000-000000000001"}},
+			{"opponent=OPPONENT1", []string{"8e1f2c3a-0003-11ef-8000-000000000003", "8e1f2c3a-0001-11ef-8000-000000000001"}},
+			{"opponent=opponent1&result=win", []string{"8e1f2c3a-0001-11ef-8000-000000000001"}},
+			{"result=draw", []string{"8e1f2c3a-0002-11ef-8000-000000000002"}},
+			{"time_class=rapid&rated=true&eco=B01", []string{"8e1f2c3a-0003-11ef-8000-000000000003"}},
+			{"from=2025-02-04&to=2025-02-10", []string{"8e1f2c3a-0002-11ef-8000-000000000002"}},
+			{"time_class=bullet", []string{}},
+		}
+
+		for _, test := range tests {
+			values, _ := url.ParseQuery(test.query)
+			q, err := newGameQuery(values)
+			if err != nil {
+				t.Fatalf("expected %v, got %v", nil, err)
+			}
+
+			actual := gi.query(q)
+			if len(actual) != len(test.uuids) {
+				t.Errorf("%s: expected %v games, got %v", test.query, len(test.uuids), len(actual))
+				continue
+			}
+			for i := range actual {
+				if actual[i].UUID != test.uuids[i] {
+					t.Errorf("%s: expected %v, got %v", test.query, test.uuids[i], actual[i].UUID)
+				}
+			}
+		}
+	})
+
+	t.Run("summary is from the player's point of view", func(t *testing.T) {
+		gs := gi.Games["8e1f2c3a-0002-11ef-8000-000000000002"]
+		if gs.Colour != "black" {
+			t.Errorf("expected %v, got %v", "black", gs.Colour)
+		}
+		if gs.Opponent != "Opponent2" {
+			t.Errorf("expected %v, got %v", "Opponent2", gs.Opponent)
+		}
+		if gs.PlayerRating != 1190 {
+			t.Errorf("expected %v, got %v", 1190, gs.PlayerRating)
+		}
+		if gs.Opening != "Queens Pawn Opening Zukertort Variation" {
+			t.Errorf("expected %v, got %v", "Queens Pawn Opening Zukertort Variation", gs.Opening)
+		}
+	})
+}
+
+func TestUpdateGameIndexConcurrently(t *testing.T) {
+	appConfig.DataDir = t.TempDir()
+	defer func() { appConfig = defaultConfig() }()
+
+	fixture := fixtureArchiveData(t, "asdf", "2025-02", "testdata/asdf_2025_02.json")
+	games := fixture.ArchiveData["2025-02"].([]interface{})
+
+	// every month holds the fixture's games under UUIDs of its own
+	var wg sync.WaitGroup
+	errs := make([]error, 12)
+	for month := range 12 {
+		key := fmt.Sprintf("2024-%02d", month+1)
+		monthGames := make([]interface{}, len(games))
+		for i, game := range games {
+			g := maps.Clone(game.(map[string]interface{}))
+			g["uuid"] = fmt.Sprintf("%s-%d", key, i)
+			monthGames[i] = g
+		}
+		ad := archiveData{Site: chessComSource, Player: "asdf", Key: key, ArchiveData: map[string]interface{}{key: monthGames}}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[month] = updateGameIndex(ad)
+		}()
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	gi, err := readGameIndex(chessComSource, "asdf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(gi.Games) != 12*len(games) {
+		t.Errorf("expected %v, got %v", 12*len(games), len(gi.Games))
+	}
+}
@@ -0,0 +1,326 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"time"
+)
+
+// Lichess has no monthly archives: the archive list is every month from the
+// account's creation to now, and a month's games are the games export of the
+// user filtered to games created in that month.
+// Exported games are converted to the chess.com game JSON shape on the way in.
+//
+// Requests to lichess are made by a chessComClient of their own, with the
+// lichess settings (timeout, retries, rate limit, record and replay).
+
+// the client used for all lichess requests, set up from appConfig by setConfig
+var lichess = newLichessClient(defaultConfig().Lichess)
+
+var lichessSource gameSource = lichessGameSource{}
+
+func newLichessClient(c upstreamConfig) *chessComClient {
+	client := newChessComClient(c)
+	client.source = lichessSource.name()
+	client.accept = "application/x-ndjson" // the games export is PGN by default
+	return client
+}
+
+// queries a URL answering with newline delimited JSON, one map per line
+func (c *chessComClient) getNDJSON(url string) (data []map[string]interface{}, err error) {
+	resp, err := c.get(url, cacheValidators{})
+	if err != nil {
+		err = fmt.Errorf("c.get: %w", err)
+		return nil, WrapError(err)
+	}
+
+	data = []map[string]interface{}{}
+	scanner := bufio.NewScanner(bytes.NewReader(resp.Body))
+	scanner.Buffer(make([]byte, 64*1024), 4*1024*1024) // a game with its PGN can be long
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		item := make(map[string]interface{})
+		err = json.Unmarshal(line, &item)
+		if err != nil {
+			err = fmt.Errorf("json.Unmarshal: %w", err)
+			return nil, WrapError(err)
+		}
+		data = append(data, item)
+	}
+	err = scanner.Err()
+	if err != nil {
+		err = fmt.Errorf("scanner.Err: %w", err)
+		return nil, WrapError(err)
+	}
+
+	return data, nil
+}
+
+type lichessGameSource struct{}
+
+func (lichessGameSource) name() string {
+	return "lichess"
+}
+
+func (lichessGameSource) contentType(base string) string {
+	return "lichess_" + base
+}
+
+func (lichessGameSource) archiveListURL(player string) string {
+	return fmt.Sprintf("%s/api/user/%s", appConfig.Lichess.BaseURL, url.PathEscape(player))
+}
+
+// builds the archive list from the account's creation date, it changes every month
+func (lichessGameSource) fetchArchiveList(url string, haveCopy bool) (data map[string]interface{}, modified bool, err error) {
+	user, err := lichess.getJSON(url)
+	if err != nil {
+		err = fmt.Errorf("lichess.getJSON: %w", err)
+		return nil, false, WrapError(err)
+	}
+	createdAt, ok := user["createdAt"].(float64)
+	if !ok {
+		err = fmt.Errorf("'createdAt' key not found or is not a number")
+		return nil, false, WrapError(err)
+	}
+
+	archives := []interface{}{}
+	created := time.UnixMilli(int64(createdAt)).UTC()
+	now := time.Now().UTC()
+	for month := time.Date(created.Year(), created.Month(), 1, 0, 0, 0, 0, time.UTC); !month.After(now); month = month.AddDate(0, 1, 0) {
+		archives = append(archives, month.Format("2006-01"))
+	}
+
+	data = map[string]interface{}{
+		"archives":   archives,
+		"created_at": created,
+	}
+	return data, true, nil
+}
+
+// the archive list holds the YYYY-MM keys themselves
+func (lichessGameSource) archiveKey(archive interface{}) (key string, err error) {
+	key, ok := archive.(string)
+	if !ok {
+		err = fmt.Errorf("archive is not a string")
+		return "", WrapError(err)
+	}
+	if _, err := time.Parse("2006-01", key); err != nil {
+		err = fmt.Errorf("invalid archive key: %s", key)
+		return "", WrapError(err)
+	}
+	return key, nil
+}
+
+// the games export of the games created during the month
+func (lichessGameSource) archiveDataURL(player string, year int, month time.Month) string {
+	since := time.Date(year, month, 1, 0, 0, 0, 0, time.UTC)
+	until := since.AddDate(0, 1, 0).Add(-time.Millisecond) // until is inclusive
+
+	query := url.Values{}
+	query.Set("since", fmt.Sprint(since.UnixMilli()))
+	query.Set("until", fmt.Sprint(until.UnixMilli()))
+	query.Set("pgnInJson", "true")
+	query.Set("opening", "true")
+	query.Set("clocks", "true")
+
+	return fmt.Sprintf("%s/api/games/user/%s?%s", appConfig.Lichess.BaseURL, url.PathEscape(player), query.Encode())
+}
+
+// lichess does not answer conditional requests, so every month is fetched in full
+func (lichessGameSource) fetchArchiveData(url string, haveCopy bool) (games interface{}, modified bool, err error) {
+	exported, err := lichess.getNDJSON(url)
+	if err != nil {
+		err = fmt.Errorf("lichess.getNDJSON: %w", err)
+		return nil, false, WrapError(err)
+	}
+
+	converted := []interface{}{}
+	for _, game := range exported {
+		gameMap, ok, err := lichessGameToChessCom(game)
+		if err != nil {
+			err = fmt.Errorf("lichessGameToChessCom: %w", err)
+			return nil, false, WrapError(err)
+		}
+		if ok {
+			converted = append(converted, gameMap)
+		}
+	}
+
+	// there are no validators, but the sync needs to know when the month was fetched
+	cache, err := newDatabase("http_cache", "")
+	if err != nil {
+		err = fmt.Errorf("newDatabase: %w", err)
+		return nil, false, WrapError(err)
+	}
+	err = recordFetch(cache, url, cacheValidators{})
+	if err != nil {
+		err = fmt.Errorf("recordFetch: %w", err)
+		return nil, false, WrapError(err)
+	}
+
+	return converted, true, nil
+}
+
+func (lichessGameSource) validGameID(id string) bool {
+	return lichessGameIDRegexp.MatchString(id)
+}
+
+// lichess game statuses of games that never finished, which are not stored
+var lichessUnfinished = map[string]bool{
+	"created":       true,
+	"started":       true,
+	"aborted":       true,
+	"noStart":       true,
+	"unknownFinish": true,
+}
+
+// the chess.com result code of the loser, by lichess game status
+var lichessLossResults = map[string]string{
+	"mate":       "checkmated",
+	"resign":     "resigned",
+	"outoftime":  "timeout",
+	"timeout":    "abandoned", // lichess "timeout" is leaving the game
+	"cheat":      "lose",
+	"variantEnd": "lose",
+}
+
+// the chess.com result code of both players, by lichess game status
+var lichessDrawResults = map[string]string{
+	"draw":      "agreed", // lichess does not say how the draw came about
+	"stalemate": "stalemate",
+	"outoftime": "timevsinsufficient",
+}
+
+// the chess.com time class of each lichess speed
+var lichessTimeClasses = map[string]string{
+	"ultraBullet":    "bullet",
+	"bullet":         "bullet",
+	"blitz":          "blitz",
+	"rapid":          "rapid",
+	"classical":      "classical",
+	"correspondence": "daily",
+}
+
+// converts a game of the lichess games export to the chess.com game JSON shape
+// ok is false for games that never finished
+func lichessGameToChessCom(game map[string]interface{}) (gameMap map[string]interface{}, ok bool, err error) {
+	id, _ := game["id"].(string)
+	status, _ := game["status"].(string)
+	pgn, _ := game["pgn"].(string)
+	if id == "" || pgn == "" {
+		err = fmt.Errorf("game is missing 'id' or 'pgn'")
+		return nil, false, WrapError(err)
+	}
+	if lichessUnfinished[status] {
+		return nil, false, nil
+	}
+
+	players, _ := game["players"].(map[string]interface{})
+	whiteMap, _ := players["white"].(map[string]interface{})
+	blackMap, _ := players["black"].(map[string]interface{})
+	if whiteMap == nil || blackMap == nil {
+		err = fmt.Errorf("game %s is missing 'players'", id)
+		return nil, false, WrapError(err)
+	}
+	white := lichessPlayerToChessCom(whiteMap)
+	black := lichessPlayerToChessCom(blackMap)
+
+	switch winner, _ := game["winner"].(string); winner {
+	case "white":
+		white["result"], black["result"] = "win", lossResult(status)
+	case "black":
+		white["result"], black["result"] = lossResult(status), "win"
+	default:
+		draw, ok := lichessDrawResults[status]
+		if !ok {
+			draw = "agreed"
+		}
+		white["result"], black["result"] = draw, draw
+	}
+
+	speed, _ := game["speed"].(string)
+	timeClass, ok := lichessTimeClasses[speed]
+	if !ok {
+		timeClass = speed
+	}
+
+	// lichess times are in milliseconds
+	lastMoveAt, _ := game["lastMoveAt"].(float64)
+	createdAt, _ := game["createdAt"].(float64)
+	if lastMoveAt == 0 {
+		lastMoveAt = createdAt
+	}
+
+	rules := "chess"
+	if variant, _ := game["variant"].(string); variant != "" && variant != "standard" {
+		rules = variant
+	}
+	rated, _ := game["rated"].(bool)
+
+	gameMap = map[string]interface{}{
+		"uuid":         id,
+		"url":          fmt.Sprintf("https://lichess.org/%s", id),
+		"pgn":          pgn,
+		"end_time":     float64(int64(lastMoveAt) / 1000),
+		"time_class":   timeClass,
+		"time_control": lichessTimeControl(game),
+		"rated":        rated,
+		"rules":        rules,
+		"white":        white,
+		"black":        black,
+	}
+	// Chess960 and from position games
+	if initialFen, ok := game["initialFen"].(string); ok && initialFen != "" {
+		gameMap["initial_setup"] = initialFen
+	}
+
+	return gameMap, true, nil
+}
+
+func lossResult(status string) string {
+	if result, ok := lichessLossResults[status]; ok {
+		return result
+	}
+	return "lose"
+}
+
+// converts one side of a lichess game to a chess.com player map, without its result
+func lichessPlayerToChessCom(side map[string]interface{}) (p map[string]interface{}) {
+	rating, _ := side["rating"].(float64)
+	p = map[string]interface{}{
+		"username": "Anonymous",
+		"uuid":     "",
+		"rating":   rating,
+	}
+
+	if user, ok := side["user"].(map[string]interface{}); ok {
+		p["username"], _ = user["name"].(string)
+		p["uuid"], _ = user["id"].(string)
+	} else if aiLevel, ok := side["aiLevel"].(float64); ok {
+		p["username"] = fmt.Sprintf("Stockfish level %d", int(aiLevel))
+	}
+
+	return p
+}
+
+// the chess.com time control of a lichess game, e.g. "180+2", "600" or "1/86400"
+func lichessTimeControl(game map[string]interface{}) string {
+	if clock, ok := game["clock"].(map[string]interface{}); ok {
+		initial, _ := clock["initial"].(float64)
+		increment, _ := clock["increment"].(float64)
+		if increment == 0 {
+			return fmt.Sprintf("%d", int(initial))
+		}
+		return fmt.Sprintf("%d+%d", int(initial), int(increment))
+	}
+	if days, ok := game["daysPerTurn"].(float64); ok {
+		return fmt.Sprintf("1/%d", int(days)*86400)
+	}
+	return "-"
+}
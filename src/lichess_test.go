@@ -0,0 +1,186 @@
+package main
+
+import (
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"chess-analyzer/fakelichess"
+)
+
+func TestLichessGameToChessCom(t *testing.T) {
+	game := func(winner string, status string) map[string]interface{} {
+		g := map[string]interface{}{
+			"id":         "abcd1234",
+			"rated":      true,
+			"variant":    "standard",
+			"speed":      "correspondence",
+			"createdAt":  float64(1738576800000),
+			"lastMoveAt": float64(1738577400000),
+			"status":     status,
+			"players": map[string]interface{}{
+				"white": map[string]interface{}{"user": map[string]interface{}{"name": "asdf", "id": "asdf"}, "rating": float64(1500)},
+				"black": map[string]interface{}{"aiLevel": float64(3)},
+			},
+			"daysPerTurn": float64(2),
+			"pgn":         "[Event \"Casual game\"]\n\n1. e4 e5 *\n",
+		}
+		if winner != "" {
+			g["winner"] = winner
+		}
+		return g
+	}
+
+	type testCase struct {
+		// Input Params
+		winner string
+		status string
+		// Expected Values
+		ok          bool
+		whiteResult string
+		blackResult string
+	}
+
+	t.Run("lichess results", func(t *testing.T) {
+		tests := []testCase{
+			{"white", "mate", true, "win", "checkmated"},
+			{"black", "resign", true, "resigned", "win"},
+			{"black", "outoftime", true, "timeout", "win"},
+			{"white", "timeout", true, "win", "abandoned"},
+			{"", "outoftime", true, "timevsinsufficient", "timevsinsufficient"},
+			{"", "stalemate", true, "stalemate", "stalemate"},
+			{"", "draw", true, "agreed", "agreed"},
+			{"", "aborted", false, "", ""},
+		}
+
+		for _, test := range tests {
+			gameMap, ok, err := lichessGameToChessCom(game(test.winner, test.status))
+			if err != nil {
+				t.Fatalf("%s: expected %v, got %v", test.status, nil, err)
+			}
+			if ok != test.ok {
+				t.Errorf("%s: expected %v, got %v", test.status, test.ok, ok)
+			}
+			if !ok {
+				continue
+			}
+			white := gameMap["white"].(map[string]interface{})
+			black := gameMap["black"].(map[string]interface{})
+			if white["result"] != test.whiteResult || black["result"] != test.blackResult {
+				t.Errorf("%s: expected %v/%v, got %v/%v", test.status, test.whiteResult, test.blackResult, white["result"], black["result"])
+			}
+		}
+	})
+
+	gameMap, _, _ := lichessGameToChessCom(game("white", "mate"))
+	expected := map[string]interface{}{
+		"uuid":         "abcd1234",
+		"url":          "https://lichess.org/abcd1234",
+		"end_time":     float64(1738577400),
+		"time_class":   "daily",
+		"time_control": "1/172800",
+		"rules":        "chess",
+	}
+	for key, value := range expected {
+		if gameMap[key] != value {
+			t.Errorf("%s: expected %v, got %v", key, value, gameMap[key])
+		}
+	}
+	if username := gameMap["black"].(map[string]interface{})["username"]; username != "Stockfish level 3" {
+		t.Errorf("expected %v, got %v", "Stockfish level 3", username)
+	}
+
+	_, _, err := lichessGameToChessCom(map[string]interface{}{"id": "abcd1234"})
+	if err == nil {
+		t.Errorf("expected an error, got %v", err)
+	}
+}
+
+func TestLichessEndToEnd(t *testing.T) {
+	upstream := fakelichess.New()
+	defer upstream.Close()
+	err := upstream.LoadFixtures("testdata/lichess")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := fakeEngineConfig(t, nil)
+	c.Lichess.BaseURL = upstream.BaseURL()
+	setConfig(c)
+	defer setConfig(defaultConfig())
+
+	api := httptest.NewServer(newRouter())
+	defer api.Close()
+
+	type testCase struct {
+		// Input Params
+		method string
+		path   string
+		// Expected Values
+		status int
+		body   string // the response must contain this
+	}
+
+	t.Run("refresh and analyze", func(t *testing.T) {
+		tests := []testCase{
+			{"POST", "/api/lichess/asdf/archives", 200, "Archive List Updated"},
+			{"GET", "/api/lichess/asdf/archives", 200, `"2024-12": false`},
+			{"POST", "/api/lichess/asdf/2025-02", 200, "Archive Data Updated (2 games added)"},
+			{"POST", "/api/lichess/asdf/2025-02", 200, "Archive Data Updated (0 games added)"},
+			{"GET", "/api/lichess/asdf/archives", 200, `"2025-02": true`},
+			{"GET", "/api/lichess/asdf/2025-02?analyzed=false", 200, `"total": 2`},
+			{"POST", "/api/lichess/asdf/2025-02/LiGame02", 200, "Result Updated"},
+			{"GET", "/api/lichess/asdf/2025-02?analyzed=true", 200, `"uuid": "LiGame02"`},
+			{"GET", "/api/lichess/asdf/2025-02/LiGame02", 200, `"source": "lichess"`},
+			{"GET", "/api/lichess/asdf/games?from=2025-01&to=2025-02", 200, `"uuid": "LiGame02"`},
+			{"GET", "/api/games/LiGame03", 200, `"source": "lichess"`},
+			{"GET", "/api/lichess/asdf/search?result=loss", 200, `"opponent": "Opponent2"`},
+			{"POST", "/api/lichess/nobody/archives", 404, "404"},
+			// chess.com tables are separate
+			{"GET", "/api/asdf/2025-02", 404, "not stored"},
+		}
+
+		for _, test := range tests {
+			status, body := apiRequest(t, test.method, api.URL+test.path)
+			if status != test.status {
+				t.Errorf("%s %s: expected %v, got %v (%s)", test.method, test.path, test.status, status, body)
+			}
+			if !strings.Contains(body, test.body) {
+				t.Errorf("%s %s: expected %q in %q", test.method, test.path, test.body, body)
+			}
+		}
+	})
+
+	t.Run("sync", func(t *testing.T) {
+		report, err := syncPlayer(lichessSource, "asdf", time.Now())
+		if err != nil {
+			t.Fatal(err)
+		}
+		// 2025-02 is stored, every other month from the account's creation is fetched
+		checked := strings.Join(report.ArchivesChecked, " ")
+		if !strings.Contains(checked, "2025-01") || strings.Contains(checked, "2025-02") {
+			t.Errorf("expected 2025-01 and not 2025-02 in %v", report.ArchivesChecked)
+		}
+		if report.GamesAdded != 1 {
+			t.Errorf("expected %v, got %v", 1, report.GamesAdded)
+		}
+	})
+
+	t.Run("tables", func(t *testing.T) {
+		for _, tableName := range []string{"asdf_lichess_archive_list.json", "asdf_lichess_archive_data.json", "asdf_lichess_game_index.json"} {
+			if _, err := os.Stat(appConfig.DataDir + "/" + tableName); err != nil {
+				t.Errorf("expected %s, got %v", tableName, err)
+			}
+		}
+
+		report, err := runFsck(false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(report.Problems) != 0 {
+			t.Errorf("expected no problems, got %+v", report.Problems)
+		}
+	})
+}
@@ -0,0 +1,405 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The games of one archive month, as listed by GET /api/{player}/{archive},
+// or of every stored month in a range, as listed by GET /api/{player}/games:
+// a summary of every game from the player's point of view, whether it has
+// been analyzed, filtered, sorted and split into pages. Pages are chained
+// by a cursor holding the sort key and UUID of the last game listed, so a
+// refresh between two pages neither repeats nor skips games. Only the sort
+// key of each matching game is kept while sorting, its entry is built when
+// the page is written, one game at a time.
+
+const (
+	defaultListingLimit = 50
+	maxListingLimit     = 500
+)
+
+// the fields a listing can be sorted by, a leading "-" sorts in descending order
+var listingSortKeys = map[string]func(e gameListEntry) string{
+	"date":            func(e gameListEntry) string { return e.Date.UTC().Format(time.RFC3339) },
+	"opponent":        func(e gameListEntry) string { return strings.ToLower(e.Opponent) },
+	"player_rating":   func(e gameListEntry) string { return fmt.Sprintf("%012.2f", e.PlayerRating) },
+	"opponent_rating": func(e gameListEntry) string { return fmt.Sprintf("%012.2f", e.OpponentRating) },
+}
+
+// the accuracy of both sides, between 0 and 1, as stored by the analysis
+type analysisAccuracy struct {
+	White float64 `json:"white"`
+	Black float64 `json:"black"`
+}
+
+// the inaccuracies, mistakes and blunders of one side, see classifyMove
+type classificationCounts struct {
+	Inaccuracies int `json:"inaccuracies"`
+	Mistakes     int `json:"mistakes"`
+	Blunders     int `json:"blunders"`
+}
+
+type analysisClassifications struct {
+	White classificationCounts `json:"white"`
+	Black classificationCounts `json:"black"`
+}
+
+type gameListEntry struct {
+	gameSummary
+	Analyzed        bool                     `json:"analyzed"`
+	Accuracy        *analysisAccuracy        `json:"accuracy,omitempty"`        // only once analyzed
+	Classifications *analysisClassifications `json:"classifications,omitempty"` // only for analyses that classify moves
+}
+
+type gameListing struct {
+	Player     string          `json:"player"`
+	Archive    string          `json:"archive,omitempty"`     // a single month
+	From       string          `json:"from,omitempty"`        // a range of months
+	To         string          `json:"to,omitempty"`          //
+	Archives   []string        `json:"archives,omitempty"`    // the stored months of the range
+	Total      int             `json:"total"`                 // games matching the filters, on every page
+	Games      []gameListEntry `json:"games"`                 // filled by fillGames, for JSON pages
+	NextCursor string          `json:"next_cursor,omitempty"` // absent on the last page
+
+	db   database     // the analysis db the entries are built with
+	rows []listingRow // the games of the page, in order
+}
+
+// a game that matches the query, as its sort key and the stored game it is
+// listed from
+type listingRow struct {
+	key     string
+	uuid    string
+	archive string
+	game    map[string]interface{}
+}
+
+// listingQuery selects, orders and pages the games of a listing, empty filters match everything
+type listingQuery struct {
+	TimeClass string
+	Rated     string // "true" / "false"
+	Result    string // win / draw / loss
+	Colour    string // white / black
+	Analyzed  string // "true" / "false"
+	Opponent  string // lowercase
+	Sort      string // a listingSortKeys key
+	Desc      bool
+	Limit     int // 0 lists every game, for exports
+	After     *listingCursor
+}
+
+// where the previous page ended
+type listingCursor struct {
+	Sort string `json:"s"` // the sort the cursor was made for, with its "-"
+	Key  string `json:"k"`
+	UUID string `json:"u"`
+}
+
+// parses a listingQuery from URL query parameters
+func newListingQuery(values url.Values) (q listingQuery, err error) {
+	q = listingQuery{
+		TimeClass: values.Get("time_class"),
+		Rated:     values.Get("rated"),
+		Result:    values.Get("result"),
+		Colour:    values.Get("colour"),
+		Analyzed:  values.Get("analyzed"),
+		Opponent:  strings.ToLower(values.Get("opponent")),
+		Sort:      "date",
+		Desc:      true, // newest first, as the search lists them
+		Limit:     defaultListingLimit,
+	}
+
+	switch {
+	case q.Rated != "" && q.Rated != "true" && q.Rated != "false":
+		err = invalidInputError("rated must be true or false, got %q", q.Rated)
+	case q.Result != "" && q.Result != "win" && q.Result != "draw" && q.Result != "loss":
+		err = invalidInputError("result must be win, draw or loss, got %q", q.Result)
+	case q.Colour != "" && q.Colour != "white" && q.Colour != "black":
+		err = invalidInputError("colour must be white or black, got %q", q.Colour)
+	case q.Analyzed != "" && q.Analyzed != "true" && q.Analyzed != "false":
+		err = invalidInputError("analyzed must be true or false, got %q", q.Analyzed)
+	}
+	if err != nil {
+		return listingQuery{}, WrapError(err)
+	}
+
+	if sortBy := values.Get("sort"); sortBy != "" {
+		q.Sort, q.Desc = strings.TrimPrefix(sortBy, "-"), strings.HasPrefix(sortBy, "-")
+		if _, ok := listingSortKeys[q.Sort]; !ok {
+			err = invalidInputError("sort must be one of date, opponent, player_rating or opponent_rating, optionally after \"-\", got %q", sortBy)
+			return listingQuery{}, WrapError(err)
+		}
+	}
+
+	if limit := values.Get("limit"); limit != "" {
+		q.Limit, err = strconv.Atoi(limit)
+		if err != nil || q.Limit < 1 || q.Limit > maxListingLimit {
+			err = invalidInputError("limit must be between 1 and %d, got %q", maxListingLimit, limit)
+			return listingQuery{}, WrapError(err)
+		}
+	}
+
+	if cursor := values.Get("cursor"); cursor != "" {
+		q.After, err = decodeListingCursor(cursor)
+		if err != nil {
+			err = fmt.Errorf("decodeListingCursor: %w", err)
+			return listingQuery{}, WrapError(err)
+		}
+		if q.After.Sort != q.sortParam() {
+			err = invalidInputError("the cursor is for sort=%s, not sort=%s", q.After.Sort, q.sortParam())
+			return listingQuery{}, WrapError(err)
+		}
+	}
+
+	return q, nil
+}
+
+// the sort as it is written in the query, e.g. "-date"
+func (q listingQuery) sortParam() string {
+	if q.Desc {
+		return "-" + q.Sort
+	}
+	return q.Sort
+}
+
+func (q listingQuery) matches(e gameListEntry) bool {
+	return (q.TimeClass == "" || e.TimeClass == q.TimeClass) &&
+		(q.Rated == "" || strconv.FormatBool(e.Rated) == q.Rated) &&
+		(q.Result == "" || e.Result == q.Result) &&
+		(q.Colour == "" || e.Colour == q.Colour) &&
+		(q.Analyzed == "" || strconv.FormatBool(e.Analyzed) == q.Analyzed) &&
+		(q.Opponent == "" || strings.ToLower(e.Opponent) == q.Opponent)
+}
+
+// whether a comes before b in the order of q, the UUID breaks ties
+func (q listingQuery) before(aKey string, aUUID string, bKey string, bUUID string) bool {
+	if aKey == bKey {
+		aKey, bKey = aUUID, bUUID
+	}
+	if q.Desc {
+		return aKey > bKey
+	}
+	return aKey < bKey
+}
+
+func encodeListingCursor(c listingCursor) string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeListingCursor(s string) (c *listingCursor, err error) {
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err == nil {
+		err = json.Unmarshal(data, &c)
+	}
+	if err != nil || c == nil || c.UUID == "" {
+		err = invalidInputError("invalid cursor %q", s)
+		return nil, WrapError(err)
+	}
+	return c, nil
+}
+
+// lists the games of an archive month read from the database
+func newGameListing(ad archiveData, q listingQuery) (gl gameListing, err error) {
+	if _, ok := ad.ArchiveData[ad.Key].([]interface{}); !ok {
+		err = notFoundError("archive %s of %s is not stored", ad.Key, ad.Player)
+		return gameListing{}, WrapError(err)
+	}
+
+	db, err := newDatabase("analysis", "")
+	if err != nil {
+		err = fmt.Errorf("newDatabase: %w", err)
+		return gameListing{}, WrapError(err)
+	}
+
+	rows, err := listingRows(ad, db, q)
+	if err != nil {
+		err = fmt.Errorf("listingRows: %w", err)
+		return gameListing{}, WrapError(err)
+	}
+
+	gl = gameListing{Player: ad.Player, Archive: ad.Key, db: db}
+	gl.page(rows, q)
+
+	return gl, nil
+}
+
+// lists the games of every stored archive month of a player within ar
+func newRangeListing(site gameSource, player string, ar archiveRange, q listingQuery) (gl gameListing, err error) {
+	archiveDB, err := newDatabase(site.contentType("archive_data"), player)
+	if err != nil {
+		err = fmt.Errorf("newDatabase: %w", err)
+		return gameListing{}, WrapError(err)
+	}
+	if len(archiveDB.Data) == 0 {
+		err = notFoundError("no archives of %s are stored", player)
+		return gameListing{}, WrapError(err)
+	}
+
+	db, err := newDatabase("analysis", "")
+	if err != nil {
+		err = fmt.Errorf("newDatabase: %w", err)
+		return gameListing{}, WrapError(err)
+	}
+
+	gl = gameListing{Player: player, From: ar.From, To: ar.To, Archives: []string{}, db: db}
+	rows := []listingRow{}
+	for key, value := range archiveDB.Data {
+		if !ar.contains(key) {
+			continue
+		}
+		ad := archiveData{Site: site, Player: player, Key: key, ArchiveData: map[string]interface{}{key: value}}
+		monthRows, err := listingRows(ad, db, q)
+		if err != nil {
+			err = fmt.Errorf("listingRows(%s): %w", key, err)
+			return gameListing{}, WrapError(err)
+		}
+		rows = append(rows, monthRows...)
+		gl.Archives = append(gl.Archives, key)
+	}
+	sort.Strings(gl.Archives)
+
+	gl.page(rows, q)
+
+	return gl, nil
+}
+
+// the games of an archive month that match q, analyzed according to the analysis db
+func listingRows(ad archiveData, db database, q listingQuery) (rows []listingRow, err error) {
+	sortKey := listingSortKeys[q.Sort]
+	games, _ := ad.ArchiveData[ad.Key].([]interface{})
+	for _, game := range games {
+		gameMap, ok := game.(map[string]interface{})
+		if !ok {
+			err = fmt.Errorf("game is not a map[string]interface{}")
+			return nil, WrapError(err)
+		}
+		gs, err := newGameSummary(ad.Player, ad.Key, gameMap)
+		if err != nil {
+			err = fmt.Errorf("newGameSummary: %w", err)
+			return nil, WrapError(err)
+		}
+
+		entry := newGameListEntry(gs, db)
+		if q.matches(entry) {
+			rows = append(rows, listingRow{key: sortKey(entry), uuid: gs.UUID, archive: ad.Key, game: gameMap})
+		}
+	}
+	return rows, nil
+}
+
+// a game summary with what its analysis, if any, says of the game
+func newGameListEntry(gs gameSummary, db database) (entry gameListEntry) {
+	entry = gameListEntry{gameSummary: gs}
+	record, ok := db.Data[gs.UUID].(map[string]interface{})
+	if !ok {
+		return entry
+	}
+
+	entry.Analyzed = true
+	if accuracy, ok := record["accuracy"].(map[string]interface{}); ok {
+		entry.Accuracy = &analysisAccuracy{}
+		entry.Accuracy.White, _ = accuracy["white"].(float64)
+		entry.Accuracy.Black, _ = accuracy["black"].(float64)
+	}
+
+	if evaluated, _ := record["evaluated"].(bool); !evaluated {
+		return entry
+	}
+	entry.Classifications = &analysisClassifications{}
+	moves, _ := record["moves"].(map[string]interface{})
+	for key, move := range moves {
+		moveMap, _ := move.(map[string]interface{})
+		classification, _ := moveMap["classification"].(string)
+		counts := &entry.Classifications.White
+		if strings.HasSuffix(key, "...") {
+			counts = &entry.Classifications.Black
+		}
+		switch classification {
+		case "inaccuracy":
+			counts.Inaccuracies++
+		case "mistake":
+			counts.Mistakes++
+		case "blunder":
+			counts.Blunders++
+		}
+	}
+
+	return entry
+}
+
+// sorts rows and keeps the page q asks for
+func (gl *gameListing) page(rows []listingRow, q listingQuery) {
+	sort.Slice(rows, func(i, j int) bool {
+		return q.before(rows[i].key, rows[i].uuid, rows[j].key, rows[j].uuid)
+	})
+
+	gl.Total = len(rows)
+	gl.Games = []gameListEntry{}
+
+	start := 0
+	if q.After != nil {
+		start = sort.Search(len(rows), func(i int) bool {
+			return q.before(q.After.Key, q.After.UUID, rows[i].key, rows[i].uuid)
+		})
+	}
+	end := len(rows)
+	if q.Limit > 0 {
+		end = min(start+q.Limit, len(rows))
+	}
+	gl.rows = rows[start:end]
+
+	if end < len(rows) {
+		last := rows[end-1]
+		gl.NextCursor = encodeListingCursor(listingCursor{Sort: q.sortParam(), Key: last.key, UUID: last.uuid})
+	}
+}
+
+// calls fn with the entry of each game of the page in order, building the
+// entries one at a time
+func (gl *gameListing) eachGame(fn func(e gameListEntry) error) (err error) {
+	for _, row := range gl.rows {
+		gs, err := newGameSummary(gl.Player, row.archive, row.game)
+		if err != nil {
+			err = fmt.Errorf("newGameSummary: %w", err)
+			return WrapError(err)
+		}
+
+		err = fn(newGameListEntry(gs, gl.db))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// fills Games with every game of the page, which is at most maxListingLimit
+// games long for JSON
+func (gl *gameListing) fillGames() (err error) {
+	gl.Games = make([]gameListEntry, 0, len(gl.rows))
+	err = gl.eachGame(func(e gameListEntry) error {
+		gl.Games = append(gl.Games, e)
+		return nil
+	})
+	if err != nil {
+		err = fmt.Errorf("gl.eachGame: %w", err)
+		return WrapError(err)
+	}
+	return nil
+}
+
+func (gl *gameListing) prettyPrint() (s string, err error) {
+	data, err := json.MarshalIndent(gl, "", "  ")
+	if err != nil {
+		err = fmt.Errorf("json.MarshalIndent: %w", err)
+		return "", WrapError(err)
+	}
+	return string(data), nil
+}
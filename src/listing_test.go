@@ -0,0 +1,182 @@
+package main
+
+import (
+	"errors"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestGameListing(t *testing.T) {
+	appConfig.DataDir = t.TempDir()
+	defer func() { appConfig = defaultConfig() }()
+
+	ad := fixtureArchiveData(t, "asdf", "2025-02", "testdata/asdf_2025_02.json")
+	db, err := newDatabase("analysis", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.writeData(map[string]interface{}{
+		"8e1f2c3a-0001-11ef-8000-000000000001": map[string]interface{}{
+			"accuracy": map[string]interface{}{"white": 0.9, "black": 0.6},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	game1 := "8e1f2c3a-0001-11ef-8000-000000000001"
+	game2 := "8e1f2c3a-0002-11ef-8000-000000000002"
+	game3 := "8e1f2c3a-0003-11ef-8000-000000000003"
+
+	type testCase struct {
+		// Input Params
+		query string
+		// Expected Values
+		uuids []string
+	}
+
+	t.Run("filters and sorting", func(t *testing.T) {
+		tests := []testCase{
+			{"", []string{game3, game2, game1}},
+			{"sort=date", []string{game1, game2, game3}},
+			{"sort=-opponent_rating", []string{game2, game3, game1}},
+			{"sort=opponent", []string{game1, game3, game2}},
+			{"time_class=rapid&rated=true", []string{game3, game1}},
+			{"colour=black", []string{game2}},
+			{"result=draw", []string{game2}},
+			{"analyzed=true", []string{game1}},
+			{"analyzed=false&opponent=OPPONENT1", []string{game3}},
+			{"time_class=bullet", []string{}},
+		}
+
+		for _, test := range tests {
+			values, _ := url.ParseQuery(test.query)
+			q, err := newListingQuery(values)
+			if err != nil {
+				t.Fatalf("%s: expected %v, got %v", test.query, nil, err)
+			}
+			gl, err := newGameListing(ad, q)
+			if err == nil {
+				err = gl.fillGames()
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(gl.Games) != len(test.uuids) || gl.Total != len(test.uuids) {
+				t.Errorf("%s: expected %v games, got %v of %v", test.query, len(test.uuids), len(gl.Games), gl.Total)
+				continue
+			}
+			for i := range gl.Games {
+				if gl.Games[i].UUID != test.uuids[i] {
+					t.Errorf("%s: expected %v, got %v", test.query, test.uuids[i], gl.Games[i].UUID)
+				}
+			}
+		}
+	})
+
+	t.Run("accuracy once analyzed", func(t *testing.T) {
+		gl, err := newGameListing(ad, listingQuery{Sort: "date", Limit: defaultListingLimit})
+		if err == nil {
+			err = gl.fillGames()
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if a := gl.Games[0].Accuracy; !gl.Games[0].Analyzed || a == nil || a.White != 0.9 || a.Black != 0.6 {
+			t.Errorf("expected an accuracy of %v/%v, got %+v", 0.9, 0.6, gl.Games[0])
+		}
+		if gl.Games[1].Analyzed || gl.Games[1].Accuracy != nil {
+			t.Errorf("expected no accuracy, got %+v", gl.Games[1])
+		}
+	})
+
+	t.Run("cursor pagination", func(t *testing.T) {
+		var seen []string
+		values := url.Values{"limit": {"2"}, "sort": {"-date"}}
+		for page := 0; page < 3; page++ {
+			q, err := newListingQuery(values)
+			if err != nil {
+				t.Fatal(err)
+			}
+			gl, err := newGameListing(ad, q)
+			if err == nil {
+				err = gl.fillGames()
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if gl.Total != 3 {
+				t.Errorf("expected a total of %v on every page, got %v", 3, gl.Total)
+			}
+			for _, e := range gl.Games {
+				seen = append(seen, e.UUID)
+			}
+			if gl.NextCursor == "" {
+				break
+			}
+			values.Set("cursor", gl.NextCursor)
+		}
+		expected := []string{game3, game2, game1}
+		if len(seen) != len(expected) || seen[0] != expected[0] || seen[1] != expected[1] || seen[2] != expected[2] {
+			t.Errorf("expected %v, got %v", expected, seen)
+		}
+
+		// a cursor only continues the sort it was made for
+		values.Set("sort", "date")
+		if _, err := newListingQuery(values); err == nil {
+			t.Errorf("expected an error, got %v", err)
+		}
+	})
+
+	t.Run("a range of months", func(t *testing.T) {
+		archiveDB, err := newDatabase(chessComSource.contentType("archive_data"), "asdf")
+		if err != nil {
+			t.Fatal(err)
+		}
+		january := fixtureArchiveData(t, "asdf", "2025-01", "testdata/asdf_2025_01.json")
+		for _, month := range []archiveData{ad, january} {
+			err = archiveDB.writeData(month.ArchiveData)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		tests := []struct {
+			// Input Params
+			ar archiveRange
+			// Expected Values
+			archives []string
+			total    int
+		}{
+			{archiveRange{}, []string{"2025-01", "2025-02"}, 3},
+			{archiveRange{From: "2025-01", To: "2025-01"}, []string{"2025-01"}, 0},
+			{archiveRange{From: "2025-02"}, []string{"2025-02"}, 3},
+			{archiveRange{To: "2024-12"}, []string{}, 0},
+		}
+		for _, test := range tests {
+			gl, err := newRangeListing(chessComSource, "asdf", test.ar, listingQuery{Sort: "date", Limit: defaultListingLimit})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if strings.Join(gl.Archives, ",") != strings.Join(test.archives, ",") || gl.Total != test.total {
+				t.Errorf("%+v: expected %v with %v games, got %v with %v", test.ar, test.archives, test.total, gl.Archives, gl.Total)
+			}
+		}
+
+		_, err = newRangeListing(chessComSource, "nobody", archiveRange{}, listingQuery{Sort: "date", Limit: defaultListingLimit})
+		var ke *kindError
+		if !errors.As(err, &ke) || ke.Kind != kindNotFound {
+			t.Errorf("expected a not found error, got %v", err)
+		}
+	})
+
+	t.Run("invalid query", func(t *testing.T) {
+		for _, query := range []string{"colour=green", "analyzed=yes", "rated=1", "result=won", "sort=moves", "limit=0", "limit=501", "limit=ten", "cursor=!!!", "cursor=e30"} {
+			values, _ := url.ParseQuery(query)
+			if _, err := newListingQuery(values); err == nil {
+				t.Errorf("%s: expected an error, got %v", query, err)
+			}
+		}
+	})
+}
@@ -5,6 +5,13 @@ import (
 	"os"
 )
 
+// loggers of their own rather than the standard logger, whose output would
+// have to be swapped on every use, racing between concurrent requests
+var (
+	requestLog = log.New(os.Stdout, "", log.LstdFlags) // requests received
+	errorLog   = log.New(os.Stderr, "", log.LstdFlags) // errors and retries
+)
+
 // Custom error type that wraps the original error and logs it
 type LoggedError struct {
 	Err error
@@ -14,11 +21,15 @@ func (e *LoggedError) Error() string {
 	return e.Err.Error()
 }
 
+// Unwrap allows errors.Is and errors.As to see the original error
+func (e *LoggedError) Unwrap() error {
+	return e.Err
+}
+
 // WrapError logs the error and returns a LoggedError
 func WrapError(err error) error {
 	if err != nil {
-		log.SetOutput(os.Stderr)
-		log.Println(err)
+		errorLog.Println(err)
 		return &LoggedError{Err: err}
 	}
 	return nil
@@ -4,21 +4,268 @@ import (
 	"fmt"
 	"net/http"
 	"os"
+	"time"
 )
 
+const usage = `usage: chess-analyzer [command] [flags]
+
+commands:
+  serve          run the API (default)
+  migrate        upgrade every stored table to the current schema version
+  sync [lichess] PLAYER
+                 fetch a player's new games from chess.com, or lichess
+  reindex        rebuild every player's game index from the stored archives
+  fsck [repair]  check every table, quarantining bad records with "repair"
+  backup FILE    write a tar.gz snapshot of every table to FILE ("-" for stdout)
+  restore FILE   replace every table with the snapshot in FILE ("-" for stdin)
+  config print   show the effective configuration
+
+Run "chess-analyzer <command> -h" for the flags accepted by each command.`
+
 func main() {
-	mux := http.NewServeMux()
+	command := "serve"
+	args := os.Args[1:]
+	if len(args) > 0 && args[0] != "" && args[0][0] != '-' {
+		command = args[0]
+		args = args[1:]
+	}
+	if command == "config" {
+		if len(args) == 0 || args[0] != "print" {
+			fmt.Fprintln(os.Stderr, usage)
+			os.Exit(2)
+		}
+		command = "config print"
+		args = args[1:]
+	}
+
+	c, rest, err := loadConfig(command, args)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(2)
+	}
+	setConfig(c)
+
+	switch command {
+	case "serve":
+		err = serve()
+	case "migrate":
+		err = migrateTables()
+	case "sync":
+		err = syncCommand(rest)
+	case "reindex":
+		err = reindexAll()
+	case "fsck":
+		err = fsckCommand(rest)
+	case "backup":
+		err = backupCommand(rest)
+	case "restore":
+		err = restoreCommand(rest)
+	case "config print":
+		err = printConfig()
+	default:
+		fmt.Fprintln(os.Stderr, usage)
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+}
+
+func serve() (err error) {
+	// refuse to run against data written by a newer binary
+	err = checkSchemaVersions()
+	if err != nil {
+		err = fmt.Errorf("checkSchemaVersions: %w", err)
+		return WrapError(err)
+	}
+
+	// players are looked up in lowercase, see parsePlayer
+	renamed, err := normalizeTableNames()
+	if err != nil {
+		err = fmt.Errorf("normalizeTableNames: %w", err)
+		return WrapError(err)
+	}
+	for _, tableName := range renamed {
+		fmt.Fprintf(os.Stderr, "%s: renamed to the lowercase player name\n", tableName)
+	}
+
+	fmt.Fprintf(os.Stderr, "API Listening %s/tcp\n", appConfig.Listen)
+	err = http.ListenAndServe(appConfig.Listen, newRouter())
+	if err != nil {
+		err = fmt.Errorf("http.ListenAndServe: %w", err)
+		return WrapError(err)
+	}
 
+	return nil
+}
+
+// registers every API route
+func newRouter() (mux *http.ServeMux) {
+	mux = http.NewServeMux()
+
+	// every route is documented in openapi.json, see TestOpenAPIRoutes
+	mux.Handle("GET /healthz", appHandler(Healthz))
+	mux.Handle("GET /readyz", appHandler(Readyz))
+	mux.Handle("GET /metrics", appHandler(Metrics))
+	mux.Handle("GET /api/openapi.json", appHandler(APIopenAPIGet))
 	mux.Handle("GET /api/{player}", appHandler(APIarchiveListGet))
 	mux.Handle("POST /api/{player}", appHandler(APIarchiveListPost))
-	mux.Handle("GET /api/{player}/{archive}", appHandler(APIarchiveDataGet))
-	mux.Handle("POST /api/{player}/{archive}", appHandler(APIarchiveDataPost))
+	mux.Handle("GET /api/{player}/search", appHandler(APIsearchGet))
+	mux.Handle("POST /api/{player}/sync", appHandler(APIsyncPost))
+	mux.Handle("GET /api/{player}/games", appHandler(APIrangeListGet))
+	mux.Handle("POST /api/{player}/games", appHandler(APIrangeRefreshPost))
+	mux.Handle("GET /api/{player}/profile", appHandler(APIprofileGet))
+	mux.Handle("POST /api/{player}/profile", appHandler(APIprofilePost))
+	// and GET/POST /api/games/{uuid}
+	mux.Handle("GET /api/{player}/{archive}", withGamesRoute(APIgameGet, APIarchiveDataGet))
+	mux.Handle("POST /api/{player}/{archive}", withGamesRoute(APIgamePost, APIarchiveDataPost))
 	mux.Handle("GET /api/{player}/{archive}/{uuid}", appHandler(APIresultGet))
 	mux.Handle("POST /api/{player}/{archive}/{uuid}", appHandler(APIresultPost))
+	mux.Handle("POST /api/position", appHandler(APIpositionPost))
+	mux.Handle("POST /api/pgn", appHandler(APIpgnPost))
+	mux.Handle("GET /api/pgn/games", appHandler(APIimportedListGet))
+	mux.Handle("GET /api/pgn/games/{uuid}", appHandler(APIimportedResultGet))
+	mux.Handle("POST /api/pgn/games/{uuid}", appHandler(APIimportedResultPost))
+	// lichess routes have at least four segments, so they cannot conflict with the chess.com routes
+	mux.Handle("GET /api/lichess/{player}/archives", appHandler(APIarchiveListGet))
+	mux.Handle("POST /api/lichess/{player}/archives", appHandler(APIarchiveListPost))
+	mux.Handle("GET /api/lichess/{player}/search", appHandler(APIsearchGet))
+	mux.Handle("POST /api/lichess/{player}/sync", appHandler(APIsyncPost))
+	mux.Handle("GET /api/lichess/{player}/games", appHandler(APIrangeListGet))
+	mux.Handle("POST /api/lichess/{player}/games", appHandler(APIrangeRefreshPost))
+	mux.Handle("GET /api/lichess/{player}/{archive}", appHandler(APIarchiveDataGet))
+	mux.Handle("POST /api/lichess/{player}/{archive}", appHandler(APIarchiveDataPost))
+	mux.Handle("GET /api/lichess/{player}/{archive}/{uuid}", appHandler(APIresultGet))
+	mux.Handle("POST /api/lichess/{player}/{archive}/{uuid}", appHandler(APIresultPost))
+	mux.Handle("GET /api/admin/fsck", appHandler(APIfsckGet))
+	mux.Handle("POST /api/admin/fsck", appHandler(APIfsckPost))
+	mux.Handle("GET /api/admin/backup", appHandler(APIbackupGet))
+	mux.Handle("POST /api/admin/restore", appHandler(APIrestorePost))
+
+	return mux
+}
 
-	fmt.Fprintln(os.Stderr, "API Listening :24377/tcp") // 24377 = 'chess' in T9
-	err := http.ListenAndServe(":24377", mux)           // all interfaces
+func printConfig() (err error) {
+	data, err := appConfig.prettyPrint()
 	if err != nil {
-		fmt.Fprintln(os.Stderr, err.Error())
+		err = fmt.Errorf("appConfig.prettyPrint: %w", err)
+		return WrapError(err)
+	}
+	fmt.Println(data)
+
+	return nil
+}
+
+func syncCommand(args []string) (err error) {
+	site := chessComSource
+	if len(args) == 2 && args[0] == "lichess" {
+		site = lichessSource
+		args = args[1:]
 	}
+	if len(args) != 1 {
+		err = fmt.Errorf("sync: expected one PLAYER argument, optionally after \"lichess\"")
+		return WrapError(err)
+	}
+
+	player, err := parsePlayer(args[0])
+	if err != nil {
+		err = fmt.Errorf("parsePlayer: %w", err)
+		return WrapError(err)
+	}
+
+	report, err := syncPlayer(site, player, time.Now())
+	if err != nil {
+		err = fmt.Errorf("syncPlayer: %w", err)
+		return WrapError(err)
+	}
+
+	data, err := report.prettyPrint()
+	if err != nil {
+		err = fmt.Errorf("report.prettyPrint: %w", err)
+		return WrapError(err)
+	}
+	fmt.Println(data)
+
+	return nil
+}
+
+func fsckCommand(args []string) (err error) {
+	repair := len(args) == 1 && args[0] == "repair"
+	if len(args) > 1 || (len(args) == 1 && !repair) {
+		err = fmt.Errorf("fsck: expected no argument or \"repair\"")
+		return WrapError(err)
+	}
+
+	report, err := runFsck(repair)
+	if err != nil {
+		err = fmt.Errorf("runFsck: %w", err)
+		return WrapError(err)
+	}
+
+	data, err := report.prettyPrint()
+	if err != nil {
+		err = fmt.Errorf("report.prettyPrint: %w", err)
+		return WrapError(err)
+	}
+	fmt.Println(data)
+
+	if outstanding := report.outstanding(); outstanding > 0 {
+		err = fmt.Errorf("fsck: %d problems outstanding", outstanding)
+		return WrapError(err)
+	}
+
+	return nil
+}
+
+func backupCommand(args []string) (err error) {
+	if len(args) != 1 {
+		err = fmt.Errorf("backup: expected one FILE argument")
+		return WrapError(err)
+	}
+
+	if args[0] == "-" {
+		return writeBackup(os.Stdout)
+	}
+
+	file, err := os.Create(args[0])
+	if err != nil {
+		err = fmt.Errorf("os.Create: %w", err)
+		return WrapError(err)
+	}
+	defer file.Close()
+
+	err = writeBackup(file)
+	if err != nil {
+		err = fmt.Errorf("writeBackup: %w", err)
+		return WrapError(err)
+	}
+
+	return file.Close()
+}
+
+func restoreCommand(args []string) (err error) {
+	if len(args) != 1 {
+		err = fmt.Errorf("restore: expected one FILE argument")
+		return WrapError(err)
+	}
+
+	file := os.Stdin
+	if args[0] != "-" {
+		file, err = os.Open(args[0])
+		if err != nil {
+			err = fmt.Errorf("os.Open: %w", err)
+			return WrapError(err)
+		}
+		defer file.Close()
+	}
+
+	restored, err := restoreBackup(file)
+	if err != nil {
+		err = fmt.Errorf("restoreBackup: %w", err)
+		return WrapError(err)
+	}
+	fmt.Fprintf(os.Stderr, "Restored %d tables\n", restored)
+
+	return nil
 }
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Metrics in the Prometheus text exposition format, served at GET /metrics,
+// see https://prometheus.io/docs/instrumenting/exposition_formats/. Counters
+// and histograms are kept in memory from the start of the process; gauges
+// are read when scraped.
+
+const metricsContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+var (
+	latencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+	dbBuckets      = []float64{0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1}
+	searchBuckets  = []float64{0.01, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30}
+)
+
+var (
+	httpRequests = newCounterVec("chess_analyzer_http_requests_total",
+		"HTTP requests by route pattern and status.", "route", "status")
+	httpRequestSeconds = newHistogramVec("chess_analyzer_http_request_duration_seconds",
+		"Time to answer HTTP requests by route pattern.", latencyBuckets, "route")
+	upstreamRequests = newCounterVec("chess_analyzer_upstream_requests_total",
+		"Requests to chess.com and Lichess by status, \"error\" when no response was received.", "source", "status")
+	upstreamRequestSeconds = newHistogramVec("chess_analyzer_upstream_request_duration_seconds",
+		"Time to receive responses from chess.com and Lichess.", latencyBuckets, "source")
+	upstreamErrors = newCounterVec("chess_analyzer_upstream_errors_total",
+		"Fetches from chess.com and Lichess that failed once retries were exhausted.", "source")
+	engineSearchSeconds = newHistogramVec("chess_analyzer_engine_search_duration_seconds",
+		"Time the engine spent searching positions.", searchBuckets)
+	engineSearchErrors = newCounterVec("chess_analyzer_engine_search_errors_total",
+		"Engine searches that failed.")
+	analyses = newCounterVec("chess_analyzer_analyses_total",
+		"Finished or refused analyses of games and positions by state.", "kind", "state")
+	dbReadSeconds = newHistogramVec("chess_analyzer_db_read_duration_seconds",
+		"Time to read table files by their contents.", dbBuckets, "contents")
+	dbWriteSeconds = newHistogramVec("chess_analyzer_db_write_duration_seconds",
+		"Time to write table files by their contents.", dbBuckets, "contents")
+)
+
+// positions of game analyses waiting for an engine
+var queuedPositions atomic.Int64
+
+// every metric, in the order they are written
+var metricCollectors = []metricCollector{
+	httpRequests,
+	httpRequestSeconds,
+	upstreamRequests,
+	upstreamRequestSeconds,
+	upstreamErrors,
+	engineSearchSeconds,
+	engineSearchErrors,
+	analyses,
+	&gaugeFunc{"chess_analyzer_analyses_running", "Analyses of games and positions running now.", func() map[string]float64 {
+		return map[string]float64{"": float64(runningAnalyses.Load())}
+	}},
+	&gaugeFunc{"chess_analyzer_engine_queue_positions", "Positions of game analyses waiting for an engine.", func() map[string]float64 {
+		return map[string]float64{"": float64(queuedPositions.Load())}
+	}},
+	dbReadSeconds,
+	dbWriteSeconds,
+	&gaugeFunc{"chess_analyzer_db_table_bytes", "Total size of the table files by content type.", tableSizes},
+}
+
+type metricCollector interface {
+	writeTo(w io.Writer)
+}
+
+// writes every metric
+func writeMetrics(w io.Writer) {
+	for _, collector := range metricCollectors {
+		collector.writeTo(w)
+	}
+}
+
+type counterVec struct {
+	name   string
+	help   string
+	labels []string
+
+	mu     sync.Mutex
+	values map[string]float64 // by formatted labels
+}
+
+func newCounterVec(name string, help string, labels ...string) *counterVec {
+	return &counterVec{name: name, help: help, labels: labels, values: make(map[string]float64)}
+}
+
+func (c *counterVec) add(v float64, labelValues ...string) {
+	key := formatLabels(c.labels, labelValues)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.values[key] += v
+}
+
+func (c *counterVec) writeTo(w io.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	writeMetricHeader(w, c.name, c.help, "counter")
+	for _, key := range sortedKeys(c.values) {
+		fmt.Fprintf(w, "%s%s %s\n", c.name, labelSet(key), formatMetricValue(c.values[key]))
+	}
+}
+
+type histogram struct {
+	counts []uint64 // per bucket, not cumulative
+	sum    float64
+	count  uint64
+}
+
+type histogramVec struct {
+	name    string
+	help    string
+	labels  []string
+	buckets []float64 // upper bounds, ascending
+
+	mu     sync.Mutex
+	series map[string]*histogram // by formatted labels
+}
+
+func newHistogramVec(name string, help string, buckets []float64, labels ...string) *histogramVec {
+	return &histogramVec{name: name, help: help, labels: labels, buckets: buckets, series: make(map[string]*histogram)}
+}
+
+func (h *histogramVec) observe(v float64, labelValues ...string) {
+	key := formatLabels(h.labels, labelValues)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	s, ok := h.series[key]
+	if !ok {
+		s = &histogram{counts: make([]uint64, len(h.buckets))}
+		h.series[key] = s
+	}
+	for i, bound := range h.buckets {
+		if v <= bound {
+			s.counts[i]++
+			break
+		}
+	}
+	s.sum += v
+	s.count++
+}
+
+// observes the time since start, e.g. defer h.observeSince(time.Now())
+func (h *histogramVec) observeSince(start time.Time, labelValues ...string) {
+	h.observe(time.Since(start).Seconds(), labelValues...)
+}
+
+func (h *histogramVec) writeTo(w io.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	writeMetricHeader(w, h.name, h.help, "histogram")
+	for _, key := range sortedKeys(h.series) {
+		s := h.series[key]
+		withLE := func(le string) string {
+			if key == "" {
+				return labelSet(`le="` + le + `"`)
+			}
+			return labelSet(key + `,le="` + le + `"`)
+		}
+		var cumulative uint64
+		for i, bound := range h.buckets {
+			cumulative += s.counts[i]
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, withLE(formatMetricValue(bound)), cumulative)
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, withLE("+Inf"), s.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, labelSet(key), formatMetricValue(s.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, labelSet(key), s.count)
+	}
+}
+
+// a gauge read when scraped, its values by formatted labels
+type gaugeFunc struct {
+	name   string
+	help   string
+	values func() map[string]float64
+}
+
+func (g *gaugeFunc) writeTo(w io.Writer) {
+	values := g.values()
+
+	writeMetricHeader(w, g.name, g.help, "gauge")
+	for _, key := range sortedKeys(values) {
+		fmt.Fprintf(w, "%s%s %s\n", g.name, labelSet(key), formatMetricValue(values[key]))
+	}
+}
+
+// the size of the table files in the data directory, summed by content type
+// so that the number of series does not grow with the number of players
+func tableSizes() map[string]float64 {
+	sizes := make(map[string]float64)
+	entries, err := os.ReadDir(appConfig.DataDir)
+	if err != nil {
+		return sizes
+	}
+	for _, entry := range entries {
+		// temporary files of writes in progress start with "." and are not tables
+		_, contentType, ok := parseTableName(entry.Name())
+		if entry.IsDir() || strings.HasPrefix(entry.Name(), ".") || !ok {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		sizes[formatLabels([]string{"contents"}, []string{contentType})] += float64(info.Size())
+	}
+	return sizes
+}
+
+func writeMetricHeader(w io.Writer, name string, help string, metricType string) {
+	fmt.Fprintf(w, "# HELP %s %s\n", name, strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(help))
+	fmt.Fprintf(w, "# TYPE %s %s\n", name, metricType)
+}
+
+// labels as they are written between the braces, e.g. route="GET /healthz",status="200"
+func formatLabels(names []string, values []string) string {
+	escape := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	pairs := make([]string, len(names))
+	for i, name := range names {
+		value := ""
+		if i < len(values) {
+			value = values[i]
+		}
+		pairs[i] = name + `="` + escape.Replace(value) + `"`
+	}
+	return strings.Join(pairs, ",")
+}
+
+func labelSet(labels string) string {
+	if labels == "" {
+		return ""
+	}
+	return "{" + labels + "}"
+}
+
+func formatMetricValue(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+func sortedKeys[V any](m map[string]V) (keys []string) {
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
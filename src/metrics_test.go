@@ -0,0 +1,112 @@
+package main
+
+import (
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestMetricsExposition(t *testing.T) {
+	counter := newCounterVec("test_total", "Things counted.", "kind", "state")
+	counter.add(1, "game", "completed")
+	counter.add(2, "game", "completed")
+	counter.add(1, `a "quoted"`+"\n", "failed")
+
+	histogram := newHistogramVec("test_seconds", "Time taken.", []float64{0.1, 1})
+	histogram.observe(0.05)
+	histogram.observe(0.5)
+	histogram.observe(2)
+
+	type testCase struct {
+		// Input Params
+		collector metricCollector
+		// Expected Values
+		text string
+	}
+
+	tests := []testCase{
+		{counter, `# HELP test_total Things counted.
+# TYPE test_total counter
+test_total{kind="a \"quoted\"\n",state="failed"} 1
+test_total{kind="game",state="completed"} 3
+`},
+		{histogram, `# HELP test_seconds Time taken.
+# TYPE test_seconds histogram
+test_seconds_bucket{le="0.1"} 1
+test_seconds_bucket{le="1"} 2
+test_seconds_bucket{le="+Inf"} 3
+test_seconds_sum 2.55
+test_seconds_count 3
+`},
+		{&gaugeFunc{"test_running", "Running now.", func() map[string]float64 { return map[string]float64{"": 2} }}, `# HELP test_running Running now.
+# TYPE test_running gauge
+test_running 2
+`},
+	}
+
+	for _, test := range tests {
+		var b strings.Builder
+		test.collector.writeTo(&b)
+		if b.String() != test.text {
+			t.Errorf("expected\n%s\ngot\n%s", test.text, b.String())
+		}
+	}
+}
+
+func TestMetricsEndToEnd(t *testing.T) {
+	setConfig(fakeEngineConfig(t, nil))
+	defer setConfig(defaultConfig())
+	for _, tableName := range []string{"asdf_archive_list.json", "qwer_archive_list.json"} {
+		err := os.WriteFile(filepath.Join(appConfig.DataDir, tableName), []byte("{}\n"), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	mux := newRouter()
+	serve := func(method string, target string, body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, target, strings.NewReader(body))
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, r)
+		validateAPIResponse(t, method, target, w.Code, w.Header(), w.Body.Bytes())
+		return w
+	}
+	serve("GET", "/healthz", "")
+	serve("GET", "/api/asdf/2025-13", "")
+	serve("GET", "/api/games/not-a-game", "")
+	serve("POST", "/api/position", `{"fen": "7k/R7/5K2/8/8/8/8/8 b - - 0 1"}`)
+
+	w := serve("GET", "/metrics", "")
+	if ct := w.Header().Get("Content-Type"); ct != metricsContentType {
+		t.Errorf("expected %v, got %v", metricsContentType, ct)
+	}
+	text := w.Body.String()
+
+	// every sample is a name, optional labels and a value
+	sample := regexp.MustCompile(`^[a-z_]+(\{([a-z_]+="([^"\\]|\\.)*",?)+\})? [0-9.e+-]+$`)
+	for _, line := range strings.Split(strings.TrimSuffix(text, "\n"), "\n") {
+		if !strings.HasPrefix(line, "# ") && !sample.MatchString(line) {
+			t.Errorf("malformed sample %q", line)
+		}
+	}
+
+	expected := []string{
+		`chess_analyzer_http_requests_total{route="GET /healthz",status="200"} `,
+		`chess_analyzer_http_requests_total{route="GET /api/{player}/{archive}",status="400"} `,
+		`chess_analyzer_http_requests_total{route="GET /api/games/{uuid}",status="400"} `,
+		`chess_analyzer_http_request_duration_seconds_count{route="POST /api/position"} `,
+		`chess_analyzer_engine_search_duration_seconds_count `,
+		`chess_analyzer_analyses_total{kind="position",state="completed"} `,
+		"chess_analyzer_analyses_running 0\n",
+		"chess_analyzer_engine_queue_positions 0\n",
+		`chess_analyzer_db_table_bytes{contents="archive_list"} 6` + "\n",
+	}
+	for _, s := range expected {
+		if !strings.Contains(text, s) {
+			t.Errorf("expected %q in\n%s", s, text)
+		}
+	}
+}
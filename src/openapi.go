@@ -0,0 +1,14 @@
+package main
+
+import (
+	_ "embed"
+)
+
+// The OpenAPI 3 document of every route newRouter registers, served at
+// GET /api/openapi.json. It is written by hand alongside the handlers, and
+// TestOpenAPIRoutes fails when a route is registered without being
+// documented. Responses of the end-to-end tests are validated against its
+// schemas, see validateAPIResponse.
+
+//go:embed openapi.json
+var openAPIDocument []byte
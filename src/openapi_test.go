@@ -0,0 +1,401 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"math"
+	"mime"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// openapi.json, decoded once for every test that validates against it
+var loadOpenAPI = sync.OnceValues(func() (doc map[string]interface{}, err error) {
+	err = json.Unmarshal(openAPIDocument, &doc)
+	return doc, err
+})
+
+func openAPIDoc(t *testing.T) map[string]interface{} {
+	t.Helper()
+	doc, err := loadOpenAPI()
+	if err != nil {
+		t.Fatalf("openapi.json: %v", err)
+	}
+	return doc
+}
+
+func openAPIPaths(t *testing.T) map[string]interface{} {
+	t.Helper()
+	return openAPIDoc(t)["paths"].(map[string]interface{})
+}
+
+// the patterns newRouter registers, read from its source so that a route
+// cannot be added without the test seeing it
+func registeredRoutes(t *testing.T) (patterns []string) {
+	t.Helper()
+	file, err := parser.ParseFile(token.NewFileSet(), "main.go", nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, decl := range file.Decls {
+		fn, ok := decl.(*ast.FuncDecl)
+		if !ok || fn.Name.Name != "newRouter" {
+			continue
+		}
+		ast.Inspect(fn, func(n ast.Node) bool {
+			call, ok := n.(*ast.CallExpr)
+			if !ok || len(call.Args) == 0 {
+				return true
+			}
+			sel, ok := call.Fun.(*ast.SelectorExpr)
+			if !ok || sel.Sel.Name != "Handle" {
+				return true
+			}
+			if lit, ok := call.Args[0].(*ast.BasicLit); ok && lit.Kind == token.STRING {
+				pattern, _ := strconv.Unquote(lit.Value)
+				patterns = append(patterns, pattern)
+			}
+			return true
+		})
+	}
+	if len(patterns) == 0 {
+		t.Fatal("no routes found in newRouter")
+	}
+	return patterns
+}
+
+func TestOpenAPIRoutes(t *testing.T) {
+	paths := openAPIPaths(t)
+
+	t.Run("every route is documented", func(t *testing.T) {
+		for _, pattern := range registeredRoutes(t) {
+			method, path, _ := strings.Cut(pattern, " ")
+			operations, _ := paths[path].(map[string]interface{})
+			if _, ok := operations[strings.ToLower(method)]; !ok {
+				t.Errorf("%s is not documented in openapi.json", pattern)
+			}
+		}
+	})
+
+	t.Run("every documented route is served", func(t *testing.T) {
+		mux := newRouter()
+		sample := strings.NewReplacer("{player}", "asdf", "{archive}", "2025-02", "{uuid}", "8e1f2c3a-0001-11ef-8000-000000000001")
+		for path, operations := range paths {
+			for method := range operations.(map[string]interface{}) {
+				r := httptest.NewRequest(strings.ToUpper(method), sample.Replace(path), nil)
+				_, pattern := mux.Handler(r)
+				if !strings.HasPrefix(pattern, strings.ToUpper(method)+" ") {
+					t.Errorf("%s %s: expected a route, got %q", method, path, pattern)
+				}
+			}
+		}
+	})
+
+	t.Run("served", func(t *testing.T) {
+		r := httptest.NewRequest("GET", "/api/openapi.json", nil)
+		w := httptest.NewRecorder()
+		newRouter().ServeHTTP(w, r)
+		if w.Code != http.StatusOK || w.Body.String() != string(openAPIDocument) {
+			t.Errorf("expected %v and the document, got %v", http.StatusOK, w.Code)
+		}
+		validateAPIResponse(t, "GET", "/api/openapi.json", w.Code, w.Header(), w.Body.Bytes())
+	})
+}
+
+// checks a response of the API against the operation openapi.json documents
+// for it: its status, its content type and, for JSON, its body
+func validateAPIResponse(t *testing.T, method string, target string, status int, header http.Header, body []byte) {
+	t.Helper()
+	// the mux answers unknown routes itself, without reaching a handler
+	if header.Get("X-Request-ID") == "" {
+		return
+	}
+
+	u, err := url.Parse(target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path, ok := matchOpenAPIPath(openAPIPaths(t), u.EscapedPath())
+	if !ok {
+		t.Errorf("%s %s: no documented path", method, u.Path)
+		return
+	}
+	operation, ok := openAPIPaths(t)[path].(map[string]interface{})[strings.ToLower(method)].(map[string]interface{})
+	if !ok {
+		t.Errorf("%s %s: %s is not documented for %s", method, u.Path, method, path)
+		return
+	}
+	responses := operation["responses"].(map[string]interface{})
+	response, ok := responses[strconv.Itoa(status)].(map[string]interface{})
+	if !ok {
+		response, ok = responses["default"].(map[string]interface{})
+	}
+	if !ok {
+		t.Errorf("%s %s: status %d is not documented", method, path, status)
+		return
+	}
+
+	mediaType, _, err := mime.ParseMediaType(header.Get("Content-Type"))
+	if err != nil {
+		t.Errorf("%s %s: Content-Type: %v", method, path, err)
+		return
+	}
+	content, ok := response["content"].(map[string]interface{})[mediaType].(map[string]interface{})
+	if !ok {
+		t.Errorf("%s %s: %d %s is not documented", method, path, status, mediaType)
+		return
+	}
+	if mediaType != "application/json" {
+		return
+	}
+
+	var value interface{}
+	err = json.Unmarshal(body, &value)
+	if err != nil {
+		t.Errorf("%s %s: %d: %v", method, path, status, err)
+		return
+	}
+	for _, problem := range validateSchema(openAPIDoc(t), content["schema"].(map[string]interface{}), value, "body") {
+		t.Errorf("%s %s: %d: %s", method, path, status, problem)
+	}
+}
+
+// the documented path a request path is for, preferring literal segments,
+// then wildcards with a suffix such as {uuid}.pgn, to bare wildcards
+func matchOpenAPIPath(paths map[string]interface{}, requestPath string) (path string, ok bool) {
+	segments := strings.Split(requestPath, "/")
+	best := -1
+	for candidate := range paths {
+		templates := strings.Split(candidate, "/")
+		if len(templates) != len(segments) {
+			continue
+		}
+		score := 0
+		for i, template := range templates {
+			open, close := strings.Index(template, "{"), strings.Index(template, "}")
+			switch {
+			case open < 0 && template == segments[i]:
+				score += 2
+			case open == 0 && close == len(template)-1:
+				// a bare wildcard matches any segment
+			case open == 0 && close > 0 && strings.HasSuffix(segments[i], template[close+1:]) && len(segments[i]) > len(template)-close-1:
+				score++
+			default:
+				score = -1
+			}
+			if score < 0 {
+				break
+			}
+		}
+		if score > best || (score == best && candidate < path) {
+			best, path = score, candidate
+		}
+	}
+	return path, best >= 0
+}
+
+// the ways value does not match schema, an OpenAPI 3.0 schema object of the
+// document with $ref, allOf, nullable, type, enum, pattern, format date-time,
+// properties, required, additionalProperties and items
+func validateSchema(doc map[string]interface{}, schema map[string]interface{}, value interface{}, at string) (problems []string) {
+	if ref, ok := schema["$ref"].(string); ok {
+		resolved := interface{}(doc)
+		for _, part := range strings.Split(strings.TrimPrefix(ref, "#/"), "/") {
+			m, _ := resolved.(map[string]interface{})
+			resolved = m[part]
+		}
+		refSchema, ok := resolved.(map[string]interface{})
+		if !ok {
+			return []string{fmt.Sprintf("%s: unresolved %s", at, ref)}
+		}
+		return validateSchema(doc, refSchema, value, at)
+	}
+	for _, sub := range asSlice(schema["allOf"]) {
+		problems = append(problems, validateSchema(doc, sub.(map[string]interface{}), value, at)...)
+	}
+
+	if value == nil {
+		if nullable, _ := schema["nullable"].(bool); !nullable && schema["type"] != nil {
+			problems = append(problems, fmt.Sprintf("%s: null is not nullable", at))
+		}
+		return problems
+	}
+
+	if enum, ok := schema["enum"].([]interface{}); ok {
+		found := false
+		for _, allowed := range enum {
+			found = found || allowed == value
+		}
+		if !found {
+			problems = append(problems, fmt.Sprintf("%s: %v is not one of %v", at, value, enum))
+		}
+	}
+
+	switch schema["type"] {
+	case "string":
+		s, ok := value.(string)
+		if !ok {
+			return append(problems, fmt.Sprintf("%s: expected a string, got %T", at, value))
+		}
+		if pattern, ok := schema["pattern"].(string); ok && !regexp.MustCompile(pattern).MatchString(s) {
+			problems = append(problems, fmt.Sprintf("%s: %q does not match %s", at, s, pattern))
+		}
+		if schema["format"] == "date-time" {
+			if _, err := time.Parse(time.RFC3339, s); err != nil {
+				problems = append(problems, fmt.Sprintf("%s: %q is not a date-time", at, s))
+			}
+		}
+	case "integer":
+		f, ok := value.(float64)
+		if !ok || f != math.Trunc(f) {
+			problems = append(problems, fmt.Sprintf("%s: expected an integer, got %v", at, value))
+		}
+	case "number":
+		if _, ok := value.(float64); !ok {
+			problems = append(problems, fmt.Sprintf("%s: expected a number, got %T", at, value))
+		}
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			problems = append(problems, fmt.Sprintf("%s: expected a boolean, got %T", at, value))
+		}
+	case "array":
+		items, ok := value.([]interface{})
+		if !ok {
+			return append(problems, fmt.Sprintf("%s: expected an array, got %T", at, value))
+		}
+		if itemSchema, ok := schema["items"].(map[string]interface{}); ok {
+			for i, item := range items {
+				problems = append(problems, validateSchema(doc, itemSchema, item, fmt.Sprintf("%s[%d]", at, i))...)
+			}
+		}
+	case "object":
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return append(problems, fmt.Sprintf("%s: expected an object, got %T", at, value))
+		}
+		for _, name := range asSlice(schema["required"]) {
+			if _, ok := object[name.(string)]; !ok {
+				problems = append(problems, fmt.Sprintf("%s: %s is required", at, name))
+			}
+		}
+		properties, _ := schema["properties"].(map[string]interface{})
+		names := make([]string, 0, len(object))
+		for name := range object {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			if property, ok := properties[name].(map[string]interface{}); ok {
+				problems = append(problems, validateSchema(doc, property, object[name], at+"."+name)...)
+				continue
+			}
+			// properties of another allOf schema are left to it
+			if additional, ok := schema["additionalProperties"].(map[string]interface{}); ok {
+				problems = append(problems, validateSchema(doc, additional, object[name], at+"."+name)...)
+			} else if schema["additionalProperties"] == false {
+				problems = append(problems, fmt.Sprintf("%s: %s is not a documented property", at, name))
+			}
+		}
+	}
+
+	return problems
+}
+
+func asSlice(v interface{}) []interface{} {
+	s, _ := v.([]interface{})
+	return s
+}
+
+func TestValidateSchema(t *testing.T) {
+	doc := map[string]interface{}{}
+	err := json.Unmarshal([]byte(`{"components": {"schemas": {
+		"Game": {"type": "object", "required": ["uuid"], "properties": {
+			"uuid": {"type": "string", "pattern": "^[0-9a-f]+$"},
+			"date": {"type": "string", "format": "date-time"},
+			"moves": {"type": "array", "nullable": true, "items": {"type": "integer"}},
+			"result": {"type": "string", "enum": ["win", "draw", "loss"]}
+		}},
+		"Analyzed": {"allOf": [{"$ref": "#/components/schemas/Game"}, {"type": "object", "required": ["analyzed"], "properties": {"analyzed": {"type": "boolean"}}}]},
+		"Present": {"type": "object", "additionalProperties": {"type": "boolean"}}
+	}}}`), &doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type testCase struct {
+		// Input Params
+		schema string
+		value  string
+		// Expected Values
+		problems int
+	}
+
+	tests := []testCase{
+		{"Game", `{"uuid": "ab12", "date": "2025-02-03T10:00:00Z", "moves": [1, 2], "result": "win"}`, 0},
+		{"Game", `{"uuid": "ab12", "moves": null}`, 0},
+		{"Game", `{"date": "yesterday"}`, 2},
+		{"Game", `{"uuid": "XYZ", "moves": [1.5], "result": "won"}`, 3},
+		{"Game", `[]`, 1},
+		{"Analyzed", `{"uuid": "ab12", "analyzed": true}`, 0},
+		{"Analyzed", `{"uuid": "ab12", "analyzed": "yes"}`, 1},
+		{"Analyzed", `{"uuid": 12}`, 2},
+		{"Present", `{"2025-01": true, "2025-02": false}`, 0},
+		{"Present", `{"2025-01": 1}`, 1},
+	}
+
+	for _, test := range tests {
+		var value interface{}
+		err := json.Unmarshal([]byte(test.value), &value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		schema := map[string]interface{}{"$ref": "#/components/schemas/" + test.schema}
+		problems := validateSchema(doc, schema, value, "body")
+		if len(problems) != test.problems {
+			t.Errorf("%s %s: expected %v problems, got %v", test.schema, test.value, test.problems, problems)
+		}
+	}
+}
+
+func TestMatchOpenAPIPath(t *testing.T) {
+	paths := openAPIPaths(t)
+
+	type testCase struct {
+		// Input Params
+		path string
+		// Expected Values
+		expected string
+	}
+
+	tests := []testCase{
+		{"/api/asdf", "/api/{player}"},
+		{"/api/openapi.json", "/api/openapi.json"},
+		{"/api/asdf/games", "/api/{player}/games"},
+		{"/api/asdf/2025-02", "/api/{player}/{archive}"},
+		{"/api/asdf/2025-02.pgn", "/api/{player}/{archive}.pgn"},
+		{"/api/games/abcd1234", "/api/games/{uuid}"},
+		{"/api/games/abcd1234.pgn", "/api/games/{uuid}.pgn"},
+		{"/api/pgn/games", "/api/pgn/games"},
+		{"/api/lichess/asdf/archives", "/api/lichess/{player}/archives"},
+		{"/api/lichess/asdf/2025-02/abcd1234.pgn", "/api/lichess/{player}/{archive}/{uuid}.pgn"},
+		{"/api/admin/fsck", "/api/admin/fsck"},
+	}
+
+	for _, test := range tests {
+		path, ok := matchOpenAPIPath(paths, test.path)
+		if !ok || path != test.expected {
+			t.Errorf("%s: expected %v, got %v %v", test.path, test.expected, path, ok)
+		}
+	}
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/notnil/chess"
+)
+
+// Export of a game as PGN with its analysis merged in, for any chess GUI:
+// an [%eval] comment after every move, a NAG on inaccuracies, mistakes and
+// blunders with the engine's best move as a variation, and tags for the
+// accuracies and the engine settings. Comments of the game itself, such as
+// chess.com's [%clk], are kept. A game that has not been analyzed is
+// exported as it was played.
+
+// PGN lines are at most 80 characters, see the PGN standard, 8.2.2
+const pgnLineLength = 80
+
+// the NAG of each move classification that gets one
+var classificationNAGs = map[string]string{
+	"inaccuracy": "$6", // ?!
+	"mistake":    "$2", // ?
+	"blunder":    "$4", // ??
+}
+
+// the tags written from the analysis, dropped from the game's own tags so that
+// exporting a game imported from an export does not repeat them
+var analysisTags = []string{"Annotator", "WhiteAccuracy", "BlackAccuracy", "AnalysisEngine", "AnalysisMoveTime", "AnalysisDepth"}
+
+// an analysed move as stored, see moveHistoryToAnalysis
+type analysedMove struct {
+	Best struct {
+		Move string `json:"move"` // SAN
+	} `json:"best"`
+	Eval           moveEval `json:"eval"`           // only once the analysis is evaluated
+	Classification string   `json:"classification"` // see classifyMove
+}
+
+// writes the game's PGN with its analysis
+func (r *result) annotatedPGN() (s string, err error) {
+	// analyses read from the database hold maps, fresh ones structs, JSON reads both
+	movesJSON, err := json.Marshal(r.Analysis.Moves)
+	if err != nil {
+		err = fmt.Errorf("json.Marshal: %w", err)
+		return "", WrapError(err)
+	}
+	moves := make(map[string]analysedMove)
+	err = json.Unmarshal(movesJSON, &moves)
+	if err != nil {
+		err = fmt.Errorf("json.Unmarshal: %w", err)
+		return "", WrapError(err)
+	}
+
+	var b strings.Builder
+	for _, tp := range r.pgnTags(len(moves) > 0) {
+		value := strings.ReplaceAll(strings.ReplaceAll(tp.Value, `\`, `\\`), `"`, `\"`)
+		fmt.Fprintf(&b, "[%s \"%s\"]\n", tp.Key, value)
+	}
+	b.WriteString("\n")
+
+	var tokens []string
+	number := fenMoveNumber(r.StartFEN)
+	keys := analysisMoveKeys(r.plies)
+	interrupted := true // by the tags, a comment or a variation: Black's next move needs its number
+	for i, ply := range r.plies {
+		white := ply.pre.position.Turn() == chess.White
+		moveNumber := fmt.Sprintf("%d...", number)
+		if white {
+			moveNumber = fmt.Sprintf("%d.", number)
+		} else {
+			number++
+		}
+		if white || interrupted {
+			tokens = append(tokens, moveNumber)
+		}
+		interrupted = false
+
+		tokens = append(tokens, ply.pre.encodeSAN(ply.move))
+		move := moves[keys[i]]
+		nag, mistaken := classificationNAGs[move.Classification]
+		if mistaken {
+			tokens = append(tokens, nag)
+		}
+
+		var comments []string
+		if r.Analysis.Evaluated && move.Eval.Actual != nil {
+			comments = append(comments, "[%eval "+pgnEval(*move.Eval.Actual)+"]")
+		}
+		for _, comment := range ply.comments {
+			if comment = strings.TrimSpace(comment); comment != "" {
+				comments = append(comments, comment)
+			}
+		}
+		if len(comments) > 0 {
+			tokens = append(tokens, "{ "+strings.Join(comments, " ")+" }")
+			interrupted = true
+		}
+
+		if mistaken && r.Analysis.Evaluated {
+			tokens = append(tokens, "(", moveNumber, move.Best.Move, "{ [%eval "+pgnEval(move.Eval.Best)+"] }", ")")
+			interrupted = true
+		}
+	}
+	tokens = append(tokens, r.pgnResult())
+
+	// comments are kept on one line, the chess package reads a line
+	// starting with [%eval as a tag
+	line := ""
+	for _, token := range tokens {
+		if line != "" && len(line)+1+len(token) > pgnLineLength {
+			b.WriteString(line + "\n")
+			line = ""
+		}
+		if line != "" {
+			line += " "
+		}
+		line += token
+	}
+	b.WriteString(line + "\n")
+
+	return b.String(), nil
+}
+
+// the tags of the game as played, then those of its analysis
+func (r *result) pgnTags(analysed bool) (tags []*chess.TagPair) {
+	gameTags, _, _ := parsePGN(r.PGN)
+	has := make(map[string]bool)
+	for _, tp := range gameTags {
+		dropped := false
+		for _, name := range analysisTags {
+			dropped = dropped || tp.Key == name
+		}
+		if !dropped {
+			tags = append(tags, tp)
+			has[tp.Key] = true
+		}
+	}
+	tag := func(key string, value string) {
+		tags = append(tags, &chess.TagPair{Key: key, Value: value})
+	}
+
+	// GUIs need the starting position of games that do not start from the standard one
+	if r.Variant == variantChess960 && !has["Variant"] {
+		tag("Variant", "Chess960")
+	}
+	if r.StartFEN != standardFEN && !has["FEN"] {
+		tag("SetUp", "1")
+		tag("FEN", r.StartFEN)
+	}
+
+	if !analysed {
+		return tags
+	}
+	tag("Annotator", "chess-analyzer")
+	tag("WhiteAccuracy", fmt.Sprintf("%.1f", r.Analysis.WhiteAccuracy*100))
+	tag("BlackAccuracy", fmt.Sprintf("%.1f", r.Analysis.BlackAccuracy*100))
+	if e := r.Analysis.Engine; e != nil {
+		tag("AnalysisEngine", e.Name)
+		tag("AnalysisMoveTime", time.Duration(e.MoveTime).String())
+		tag("AnalysisDepth", strconv.Itoa(e.Depth))
+	}
+
+	return tags
+}
+
+// the result token that ends the movetext
+func (r *result) pgnResult() string {
+	_, _, outcome := parsePGN(r.PGN)
+	if outcome == chess.NoOutcome {
+		return "*"
+	}
+	return string(outcome)
+}
+
+// the fullmove number of a FEN, 1 if it has none
+func fenMoveNumber(fen string) int {
+	fields := strings.Fields(fen)
+	if len(fields) < 6 {
+		return 1
+	}
+	number, err := strconv.Atoi(fields[5])
+	if err != nil || number < 1 {
+		return 1
+	}
+	return number
+}
+
+// an evaluation as [%eval] writes it: pawns, or # and the moves to mate
+func pgnEval(e evaluation) string {
+	if e.Mate != 0 {
+		return fmt.Sprintf("#%d", e.Mate)
+	}
+	return fmt.Sprintf("%.2f", float64(e.CP)/100)
+}
+
+// exports every game of an archive month read from the database, one after the other
+func archivePGN(ad archiveData) (s string, err error) {
+	games, ok := ad.ArchiveData[ad.Key].([]interface{})
+	if !ok {
+		err = notFoundError("archive %s of %s is not stored", ad.Key, ad.Player)
+		return "", WrapError(err)
+	}
+
+	pgns := make([]string, 0, len(games))
+	for _, game := range games {
+		gameMap, ok := game.(map[string]interface{})
+		if !ok {
+			err = fmt.Errorf("game is not a map[string]interface{}")
+			return "", WrapError(err)
+		}
+		r, err := NewResult(gameMap)
+		if err != nil {
+			err = fmt.Errorf("NewResult: %w", err)
+			return "", WrapError(err)
+		}
+		pgn, err := r.annotatedPGN()
+		if err != nil {
+			err = fmt.Errorf("r.annotatedPGN(%s): %w", r.UUID, err)
+			return "", WrapError(err)
+		}
+		pgns = append(pgns, pgn)
+	}
+
+	// games are separated by a blank line
+	return strings.Join(pgns, "\n"), nil
+}
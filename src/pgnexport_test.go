@@ -0,0 +1,112 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAnnotatedPGN(t *testing.T) {
+	appConfig.DataDir = t.TempDir()
+	defer func() { appConfig = defaultConfig() }()
+
+	gameMap, err := pgnToGameMap("[Event \"Casual game\"]\n[White \"asdf\"]\n[Black \"opponent\"]\n[Result \"0-1\"]\n\n1. f3 {[%clk 0:03:00]} e5 2. g4 Qh4# 0-1\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := NewResult(gameMap)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("not analyzed", func(t *testing.T) {
+		pgn, err := r.annotatedPGN()
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := "[Event \"Casual game\"]\n[White \"asdf\"]\n[Black \"opponent\"]\n[Result \"0-1\"]\n\n1. f3 { [%clk 0:03:00] } 1... e5 2. g4 Qh4# 0-1\n"
+		if pgn != expected {
+			t.Errorf("expected %q, got %q", expected, pgn)
+		}
+	})
+
+	t.Run("analyzed", func(t *testing.T) {
+		analysed := func(best string, eval map[string]interface{}, classification string) map[string]interface{} {
+			return map[string]interface{}{"best": map[string]interface{}{"move": best}, "eval": eval, "classification": classification}
+		}
+		r.Analysis = analysis{
+			Moves: map[string]interface{}{
+				"01.": analysed("e4", map[string]interface{}{
+					"best": map[string]interface{}{"cp": 30.0}, "actual": map[string]interface{}{"cp": -20.0},
+				}, "inaccuracy"),
+				"01...": analysed("e5", map[string]interface{}{
+					"best": map[string]interface{}{"cp": -20.0}, "actual": map[string]interface{}{"cp": -10.0},
+				}, "best"),
+				"02.": analysed("Nc3", map[string]interface{}{
+					"best": map[string]interface{}{"cp": -10.0}, "actual": map[string]interface{}{"mate": -1.0},
+				}, "blunder"),
+				"02...": analysed("Qh4#", map[string]interface{}{
+					"best": map[string]interface{}{"mate": -1.0},
+				}, "best"),
+			},
+			WhiteAccuracy: 0,
+			BlackAccuracy: 1,
+			Engine:        &analysisEngine{Name: "Stockfish 17", MoveTime: duration(100 * time.Millisecond), Depth: 20},
+			Evaluated:     true,
+		}
+
+		pgn, err := r.annotatedPGN()
+		if err != nil {
+			t.Fatal(err)
+		}
+		tags, movetext, _ := strings.Cut(pgn, "\n\n")
+
+		for _, tag := range []string{`[Annotator "chess-analyzer"]`, `[WhiteAccuracy "0.0"]`, `[BlackAccuracy "100.0"]`, `[AnalysisEngine "Stockfish 17"]`, `[AnalysisMoveTime "100ms"]`, `[AnalysisDepth "20"]`} {
+			if !strings.Contains(tags, tag) {
+				t.Errorf("expected %v in %q", tag, tags)
+			}
+		}
+
+		expected := "1. f3 $6 { [%eval -0.20] [%clk 0:03:00] } ( 1. e4 { [%eval 0.30] } ) 1... e5 { [%eval -0.10] } 2. g4 $4 { [%eval #-1] } ( 2. Nc3 { [%eval -0.10] } ) 2... Qh4# 0-1"
+		if actual := strings.Join(strings.Fields(movetext), " "); actual != expected {
+			t.Errorf("expected %q, got %q", expected, actual)
+		}
+		for _, line := range strings.Split(movetext, "\n") {
+			if len(line) > pgnLineLength {
+				t.Errorf("expected lines of at most %v characters, got %q", pgnLineLength, line)
+			}
+		}
+
+		// the export reads back as the same game
+		_, plies, err := replayPGN(pgn, variantChess, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(plies) != 4 {
+			t.Errorf("expected %v plies, got %v", 4, len(plies))
+		}
+	})
+}
+
+func TestPGNEval(t *testing.T) {
+	type testCase struct {
+		// Input Params
+		eval evaluation
+		// Expected Values
+		s string
+	}
+
+	tests := []testCase{
+		{evaluation{CP: 17}, "0.17"},
+		{evaluation{CP: -250}, "-2.50"},
+		{evaluation{}, "0.00"},
+		{evaluation{Mate: 3}, "#3"},
+		{evaluation{Mate: -2}, "#-2"},
+	}
+
+	for _, test := range tests {
+		if actual := pgnEval(test.eval); actual != test.s {
+			t.Errorf("expected %v, got %v", test.s, actual)
+		}
+	}
+}
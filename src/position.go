@@ -0,0 +1,220 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/notnil/chess"
+	"github.com/notnil/chess/uci"
+)
+
+// Ad-hoc analysis of a single position, e.g. from a book or a stream.
+// The uci package only reports the last line of a search, so rather than the
+// engine's MultiPV option, each further line is a search restricted to the
+// moves not ranked yet: line 2 is the best move other than the best move, etc.
+
+// the most lines one request may ask for
+const maxMultiPV = 10
+
+// the longest search one request may ask for, per line
+const maxPositionMoveTime = 60 * time.Second
+
+type positionRequest struct {
+	FEN      string   `json:"fen"`
+	MoveTime duration `json:"movetime"` // with depth, default search.movetime and search.depth
+	Depth    int      `json:"depth"`
+	MultiPV  int      `json:"multipv"` // default 1
+}
+
+// an evaluation from White's point of view
+type evaluation struct {
+	CP   int `json:"cp"`             // centipawns, when not mate
+	Mate int `json:"mate,omitempty"` // moves to mate, negative when Black mates
+}
+
+type positionLine struct {
+	Rank    int        `json:"rank"`
+	MoveSAN string     `json:"move_san"`
+	MoveUCI string     `json:"move_uci"`
+	Eval    evaluation `json:"eval"`
+	Depth   int        `json:"depth"`
+	PV      []string   `json:"pv"`     // SAN
+	PVUCI   []string   `json:"pv_uci"` // UCI
+}
+
+type positionAnalysis struct {
+	FEN         string         `json:"fen"`
+	Turn        string         `json:"turn"` // white / black
+	BestMoveSAN string         `json:"best_move_san"`
+	BestMoveUCI string         `json:"best_move_uci"`
+	Eval        evaluation     `json:"eval"`
+	PV          []string       `json:"pv"` // SAN
+	Lines       []positionLine `json:"lines"`
+}
+
+// reads and validates a position request, filling in the defaults
+func newPositionRequest(body io.Reader) (req positionRequest, gp gamePosition, err error) {
+	req = positionRequest{MultiPV: 1}
+	err = json.NewDecoder(body).Decode(&req)
+	if err != nil {
+		err = invalidInputError("json.Decode: %w", err)
+		return positionRequest{}, gamePosition{}, WrapError(err)
+	}
+	// a client limiting the search one way must not be limited the other way too
+	if req.MoveTime == 0 && req.Depth == 0 {
+		req.MoveTime = appConfig.Search.MoveTime
+		req.Depth = appConfig.Search.Depth
+	}
+
+	gp, err = newGamePosition(variantChess, req.FEN)
+	if err != nil {
+		err = invalidInputError("invalid FEN %q: %w", req.FEN, err)
+		return positionRequest{}, gamePosition{}, WrapError(err)
+	}
+
+	switch {
+	case len(gp.position.ValidMoves()) == 0:
+		err = invalidInputError("position has no legal moves: %s", req.FEN)
+	case req.MultiPV < 1 || req.MultiPV > maxMultiPV:
+		err = invalidInputError("multipv must be between 1 and %d, got %d", maxMultiPV, req.MultiPV)
+	case req.MoveTime < 0 || time.Duration(req.MoveTime) > maxPositionMoveTime:
+		err = invalidInputError("movetime must be between 0s and %s, got %s", maxPositionMoveTime, time.Duration(req.MoveTime))
+	case req.Depth < 0:
+		err = invalidInputError("depth must not be negative, got %d", req.Depth)
+	case req.MoveTime == 0 && req.Depth == 0:
+		err = invalidInputError("one of movetime or depth must be set")
+	}
+	if err != nil {
+		return positionRequest{}, gamePosition{}, WrapError(err)
+	}
+
+	return req, gp, nil
+}
+
+// analyzes a position, returning up to req.MultiPV lines, best first
+func analyzePosition(req positionRequest, gp gamePosition) (pa positionAnalysis, err error) {
+	done, err := startAnalysis("position")
+	if err != nil {
+		err = fmt.Errorf("startAnalysis: %w", err)
+		return positionAnalysis{}, WrapError(err)
+	}
+	defer func() { done(err) }()
+
+	eng, err := newEngine(gp.variant)
+	if err != nil {
+		err = fmt.Errorf("newEngine: %w", err)
+		return positionAnalysis{}, WrapError(err)
+	}
+	defer eng.Close()
+
+	pa = positionAnalysis{
+		FEN:   gp.fen(),
+		Turn:  "white",
+		Lines: []positionLine{},
+	}
+	if gp.position.Turn() == chess.Black {
+		pa.Turn = "black"
+	}
+
+	remaining := gp.position.ValidMoves()
+	for rank := 1; rank <= req.MultiPV && len(remaining) > 0; rank++ {
+		cmdGo := uci.CmdGo{
+			MoveTime: time.Duration(req.MoveTime),
+			Depth:    req.Depth,
+		}
+		if rank > 1 {
+			cmdGo.SearchMoves = remaining
+		}
+
+		results, err := searchPosition(eng, gp, cmdGo)
+		if err != nil {
+			err = fmt.Errorf("searchPosition: %w", err)
+			return positionAnalysis{}, WrapError(err)
+		}
+
+		line, err := newPositionLine(rank, gp, results)
+		if err != nil {
+			err = fmt.Errorf("newPositionLine: %w", err)
+			return positionAnalysis{}, WrapError(err)
+		}
+		pa.Lines = append(pa.Lines, line)
+
+		var rest []*chess.Move
+		for _, move := range remaining {
+			if (chess.UCINotation{}).Encode(gp.position, move) != line.MoveUCI {
+				rest = append(rest, move)
+			}
+		}
+		remaining = rest
+	}
+
+	best := pa.Lines[0]
+	pa.BestMoveSAN = best.MoveSAN
+	pa.BestMoveUCI = best.MoveUCI
+	pa.Eval = best.Eval
+	pa.PV = best.PV
+
+	return pa, nil
+}
+
+// converts the results of a search into a line, replaying the PV to name its moves
+func newPositionLine(rank int, gp gamePosition, results uci.SearchResults) (line positionLine, err error) {
+	bestUCI := chess.UCINotation{}.Encode(nil, results.BestMove)
+	best, err := gp.decodeMove(bestUCI)
+	if err != nil {
+		err = fmt.Errorf("engine returned an illegal best move %s: %w", bestUCI, err)
+		return positionLine{}, WrapError(err)
+	}
+
+	line = positionLine{
+		Rank:    rank,
+		MoveSAN: gp.encodeSAN(best),
+		MoveUCI: bestUCI,
+		Eval:    whiteEvaluation(gp.position.Turn(), results.Info.Score),
+		Depth:   results.Info.Depth,
+		PV:      []string{},
+		PVUCI:   []string{},
+	}
+
+	// the PV normally starts with the best move, stop at the first move that is not legal
+	pv := results.Info.PV
+	if len(pv) == 0 || (chess.UCINotation{}).Encode(nil, pv[0]) != bestUCI {
+		pv = []*chess.Move{results.BestMove}
+	}
+	current := gp
+	for _, m := range pv {
+		moveUCI := chess.UCINotation{}.Encode(nil, m)
+		move, err := current.decodeMove(moveUCI)
+		if err != nil {
+			break
+		}
+		line.PV = append(line.PV, current.encodeSAN(move))
+		line.PVUCI = append(line.PVUCI, moveUCI)
+		current = current.update(move)
+	}
+
+	return line, nil
+}
+
+// engines score from the side to move, evaluations are from White's point of view
+func whiteEvaluation(turn chess.Color, score uci.Score) evaluation {
+	e := evaluation{CP: score.CP, Mate: score.Mate}
+	if turn == chess.Black {
+		e.CP, e.Mate = -e.CP, -e.Mate
+	}
+	if e.Mate != 0 {
+		e.CP = 0
+	}
+	return e
+}
+
+func (pa *positionAnalysis) prettyPrint() (s string, err error) {
+	data, err := json.MarshalIndent(pa, "", "  ")
+	if err != nil {
+		err = fmt.Errorf("json.MarshalIndent: %w", err)
+		return "", WrapError(err)
+	}
+	return string(data), nil
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/notnil/chess"
+	"github.com/notnil/chess/uci"
+)
+
+func TestWhiteEvaluation(t *testing.T) {
+	type testCase struct {
+		// Input Params
+		turn  chess.Color
+		score uci.Score
+		// Expected Values
+		expected evaluation
+	}
+
+	tests := []testCase{
+		{chess.White, uci.Score{CP: 35}, evaluation{CP: 35}},
+		{chess.Black, uci.Score{CP: 35}, evaluation{CP: -35}},
+		{chess.White, uci.Score{Mate: 2}, evaluation{Mate: 2}},
+		{chess.Black, uci.Score{Mate: 2}, evaluation{Mate: -2}},
+	}
+
+	for _, test := range tests {
+		got := whiteEvaluation(test.turn, test.score)
+		if got != test.expected {
+			t.Errorf("%v %+v: expected %+v, got %+v", test.turn, test.score, test.expected, got)
+		}
+	}
+}
+
+func TestPositionEndToEnd(t *testing.T) {
+	setConfig(fakeEngineConfig(t, nil))
+	defer setConfig(defaultConfig())
+
+	api := httptest.NewServer(newRouter())
+	defer api.Close()
+
+	post := func(body string) (status int, data string) {
+		resp, err := api.Client().Post(api.URL+"/api/position", "application/json", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		b, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		validateAPIResponse(t, "POST", api.URL+"/api/position", resp.StatusCode, resp.Header, b)
+		return resp.StatusCode, string(b)
+	}
+
+	t.Run("multipv", func(t *testing.T) {
+		// the fake engine plays the first of the moves it may search, in UCI order
+		status, data := post(`{"fen": "rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkq - 0 1", "multipv": 3}`)
+		if status != 200 {
+			t.Fatalf("expected %v, got %v (%s)", 200, status, data)
+		}
+		var pa positionAnalysis
+		err := json.Unmarshal([]byte(data), &pa)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if pa.BestMoveSAN != "a3" || pa.BestMoveUCI != "a2a3" {
+			t.Errorf("expected %v %v, got %v %v", "a3", "a2a3", pa.BestMoveSAN, pa.BestMoveUCI)
+		}
+		if pa.Turn != "white" {
+			t.Errorf("expected %v, got %v", "white", pa.Turn)
+		}
+		expected := []string{"a3", "a4", "Na3"}
+		if len(pa.Lines) != len(expected) {
+			t.Fatalf("expected %v lines, got %+v", len(expected), pa.Lines)
+		}
+		for i, line := range pa.Lines {
+			if line.Rank != i+1 || line.MoveSAN != expected[i] {
+				t.Errorf("line %d: expected %v, got %v %v", i, expected[i], line.Rank, line.MoveSAN)
+			}
+			if len(line.PV) == 0 || line.PV[0] != line.MoveSAN {
+				t.Errorf("line %d: expected a PV starting with %v, got %v", i, line.MoveSAN, line.PV)
+			}
+		}
+	})
+
+	t.Run("more lines than moves", func(t *testing.T) {
+		// Black has one legal move, Kg8
+		status, data := post(`{"fen": "7k/R7/5K2/8/8/8/8/8 b - - 0 1", "multipv": 5}`)
+		if status != 200 {
+			t.Fatalf("expected %v, got %v (%s)", 200, status, data)
+		}
+		var pa positionAnalysis
+		err := json.Unmarshal([]byte(data), &pa)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if pa.Turn != "black" || len(pa.Lines) != 1 || pa.BestMoveSAN != "Kg8" {
+			t.Errorf("expected black and %v line of %v, got %v and %+v", 1, "Kg8", pa.Turn, pa.Lines)
+		}
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		tests := []struct {
+			body     string
+			expected string
+		}{
+			{`{"fen": "not a fen"}`, "invalid FEN"},
+			{`{"fen": "7k/6Q1/6K1/8/8/8/8/8 b - - 0 1"}`, "no legal moves"},
+			{`{"fen": "rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkq - 0 1", "multipv": 11}`, "multipv"},
+			{`{"fen": "rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkq - 0 1", "movetime": "2m"}`, "movetime"},
+			{`{"fen": `, "json"},
+		}
+		for _, test := range tests {
+			status, data := post(test.body)
+			if status != 400 || !strings.Contains(data, test.expected) {
+				t.Errorf("%s: expected %v %q, got %v %q", test.body, 400, test.expected, status, data)
+			}
+		}
+	})
+}
+
+func TestNewPositionRequest(t *testing.T) {
+	appConfig.Search.MoveTime = duration(time.Second)
+	appConfig.Search.Depth = 20
+	defer func() { appConfig = defaultConfig() }()
+
+	type testCase struct {
+		// Input Params
+		body string
+		// Expected Values
+		moveTime duration
+		depth    int
+	}
+
+	fen := `"fen": "rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkq - 0 1"`
+	tests := []testCase{
+		{`{` + fen + `}`, duration(time.Second), 20},
+		{`{` + fen + `, "depth": 5}`, 0, 5},
+		{`{` + fen + `, "movetime": "100ms"}`, duration(100 * time.Millisecond), 0},
+		{`{` + fen + `, "movetime": "100ms", "depth": 5}`, duration(100 * time.Millisecond), 5},
+	}
+
+	for _, test := range tests {
+		req, _, err := newPositionRequest(strings.NewReader(test.body))
+		if err != nil {
+			t.Errorf("%s: expected %v, got %v", test.body, nil, err)
+			continue
+		}
+		if req.MoveTime != test.moveTime || req.Depth != test.depth {
+			t.Errorf("%s: expected %v/%v, got %v/%v", test.body, test.moveTime, test.depth, req.MoveTime, req.Depth)
+		}
+	}
+}
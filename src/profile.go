@@ -0,0 +1,215 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Every profile refresh stores the chess.com profile and stats as they were
+// at that moment, keyed by the fetch time, so ratings can be followed over time.
+
+// a stored snapshot of /pub/player/{player} and /pub/player/{player}/stats
+type profileSnapshot struct {
+	Fetched time.Time              `json:"fetched"`
+	Profile map[string]interface{} `json:"profile"`
+	Stats   map[string]interface{} `json:"stats"`
+}
+
+type gameRecord struct {
+	Win  int `json:"win"`
+	Loss int `json:"loss"`
+	Draw int `json:"draw"`
+}
+
+// ratings of one time class, e.g. rapid
+type timeClassStats struct {
+	Rating     int        `json:"rating"`
+	RatingDate time.Time  `json:"rating_date"`
+	Best       int        `json:"best"`
+	BestDate   time.Time  `json:"best_date"`
+	BestGame   string     `json:"best_game,omitempty"`
+	Record     gameRecord `json:"record"`
+}
+
+type ratingHistoryEntry struct {
+	Fetched time.Time      `json:"fetched"`
+	Ratings map[string]int `json:"ratings"` // time class -> rating
+}
+
+// what GET /api/{player}/profile returns
+type profileSummary struct {
+	Player        string                    `json:"player"`
+	Name          string                    `json:"name,omitempty"`
+	Title         string                    `json:"title,omitempty"`
+	Status        string                    `json:"status,omitempty"`
+	Country       string                    `json:"country,omitempty"` // ISO code, e.g. GB
+	Joined        time.Time                 `json:"joined"`
+	LastOnline    time.Time                 `json:"last_online"`
+	Fetched       time.Time                 `json:"fetched"`
+	TimeClasses   map[string]timeClassStats `json:"time_classes"`
+	RatingHistory []ratingHistoryEntry      `json:"rating_history"`
+}
+
+// fetches a player's profile and stats from chess.com and stores them as a new snapshot
+func refreshProfile(player string) (ps profileSnapshot, err error) {
+	base := fmt.Sprintf("%s/player/%s", appConfig.ChessCom.BaseURL, player)
+
+	ps.Profile, err = chessCom.getJSON(base)
+	if err != nil {
+		err = fmt.Errorf("chessCom.getJSON: %w", err)
+		return profileSnapshot{}, WrapError(err)
+	}
+	ps.Stats, err = chessCom.getJSON(base + "/stats")
+	if err != nil {
+		err = fmt.Errorf("chessCom.getJSON: %w", err)
+		return profileSnapshot{}, WrapError(err)
+	}
+	ps.Fetched = time.Now().UTC().Truncate(time.Second)
+
+	db, err := newDatabase("profile", player)
+	if err != nil {
+		err = fmt.Errorf("newDatabase: %w", err)
+		return profileSnapshot{}, WrapError(err)
+	}
+
+	err = db.writeData(map[string]interface{}{
+		ps.Fetched.Format(time.RFC3339): ps,
+	})
+	if err != nil {
+		err = fmt.Errorf("db.writeData: %w", err)
+		return profileSnapshot{}, WrapError(err)
+	}
+
+	return ps, nil
+}
+
+// reads every stored snapshot of a player, oldest first
+func readProfileSnapshots(player string) (snapshots []profileSnapshot, err error) {
+	db, err := newDatabase("profile", player)
+	if err != nil {
+		err = fmt.Errorf("newDatabase: %w", err)
+		return nil, WrapError(err)
+	}
+
+	// round trip through JSON to get from the table's map to the typed snapshots
+	dataJSON, err := json.Marshal(db.Data)
+	if err != nil {
+		err = fmt.Errorf("json.Marshal: %w", err)
+		return nil, WrapError(err)
+	}
+	byFetched := make(map[string]profileSnapshot)
+	err = json.Unmarshal(dataJSON, &byFetched)
+	if err != nil {
+		err = fmt.Errorf("json.Unmarshal: %w", err)
+		return nil, WrapError(err)
+	}
+
+	for _, ps := range byFetched {
+		snapshots = append(snapshots, ps)
+	}
+	sort.Slice(snapshots, func(i, j int) bool {
+		return snapshots[i].Fetched.Before(snapshots[j].Fetched)
+	})
+
+	return snapshots, nil
+}
+
+// summarises the latest snapshot, with the rating history of all of them
+func newProfileSummary(player string, snapshots []profileSnapshot) (summary profileSummary, err error) {
+	if len(snapshots) == 0 {
+		err = notFoundError("no profile stored for %s", player)
+		return profileSummary{}, WrapError(err)
+	}
+	latest := snapshots[len(snapshots)-1]
+
+	summary = profileSummary{
+		Player:        player,
+		Fetched:       latest.Fetched,
+		TimeClasses:   timeClassesFromStats(latest.Stats),
+		RatingHistory: []ratingHistoryEntry{},
+	}
+	summary.Name, _ = latest.Profile["name"].(string)
+	summary.Title, _ = latest.Profile["title"].(string)
+	summary.Status, _ = latest.Profile["status"].(string)
+	if country, ok := latest.Profile["country"].(string); ok {
+		// e.g. https://api.chess.com/pub/country/GB
+		summary.Country = country[strings.LastIndex(country, "/")+1:]
+	}
+	if joined, ok := latest.Profile["joined"].(float64); ok {
+		summary.Joined = epochToTime(joined).UTC()
+	}
+	if lastOnline, ok := latest.Profile["last_online"].(float64); ok {
+		summary.LastOnline = epochToTime(lastOnline).UTC()
+	}
+
+	for _, ps := range snapshots {
+		entry := ratingHistoryEntry{
+			Fetched: ps.Fetched,
+			Ratings: make(map[string]int),
+		}
+		for timeClass, stats := range timeClassesFromStats(ps.Stats) {
+			entry.Ratings[timeClass] = stats.Rating
+		}
+		summary.RatingHistory = append(summary.RatingHistory, entry)
+	}
+
+	return summary, nil
+}
+
+// reads the per time class ratings from chess.com stats,
+// e.g. "chess_rapid" becomes "rapid" and "chess960_daily" stays as it is
+func timeClassesFromStats(stats map[string]interface{}) (timeClasses map[string]timeClassStats) {
+	timeClasses = make(map[string]timeClassStats)
+
+	for key, value := range stats {
+		if !strings.HasPrefix(key, "chess") {
+			continue
+		}
+		statsMap, ok := value.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		last, ok := statsMap["last"].(map[string]interface{})
+		if !ok {
+			continue
+		}
+
+		var tcs timeClassStats
+		tcs.Rating = intFromMap(last, "rating")
+		tcs.RatingDate = epochToTime(float64(intFromMap(last, "date"))).UTC()
+		if best, ok := statsMap["best"].(map[string]interface{}); ok {
+			tcs.Best = intFromMap(best, "rating")
+			tcs.BestDate = epochToTime(float64(intFromMap(best, "date"))).UTC()
+			tcs.BestGame, _ = best["game"].(string)
+		}
+		if record, ok := statsMap["record"].(map[string]interface{}); ok {
+			tcs.Record = gameRecord{
+				Win:  intFromMap(record, "win"),
+				Loss: intFromMap(record, "loss"),
+				Draw: intFromMap(record, "draw"),
+			}
+		}
+
+		timeClasses[strings.TrimPrefix(key, "chess_")] = tcs
+	}
+
+	return timeClasses
+}
+
+// JSON numbers decode as float64
+func intFromMap(m map[string]interface{}, key string) int {
+	f, _ := m[key].(float64)
+	return int(f)
+}
+
+func (summary *profileSummary) prettyPrint() (s string, err error) {
+	data, err := json.MarshalIndent(summary, "", "  ")
+	if err != nil {
+		err = fmt.Errorf("json.MarshalIndent: %w", err)
+		return "", WrapError(err)
+	}
+	return string(data), nil
+}
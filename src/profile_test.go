@@ -0,0 +1,101 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"chess-analyzer/fakechesscom"
+)
+
+func TestProfile(t *testing.T) {
+	upstream := fakechesscom.New()
+	defer upstream.Close()
+	err := upstream.LoadFixtures("testdata")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	setConfig(fakeEngineConfig(t, upstream))
+	defer setConfig(defaultConfig())
+
+	t.Run("refresh and summarise", func(t *testing.T) {
+		_, err := refreshProfile("asdf")
+		if err != nil {
+			t.Fatalf("expected %v, got %v", nil, err)
+		}
+
+		snapshots, err := readProfileSnapshots("asdf")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(snapshots) != 1 {
+			t.Fatalf("expected %v, got %v", 1, len(snapshots))
+		}
+
+		summary, err := newProfileSummary("asdf", snapshots)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if summary.Country != "GB" {
+			t.Errorf("expected %v, got %v", "GB", summary.Country)
+		}
+		expected := timeClassStats{
+			Rating:     1310,
+			RatingDate: time.Unix(1739145600, 0).UTC(),
+			Best:       1402,
+			BestDate:   time.Unix(1725148800, 0).UTC(),
+			BestGame:   "https://www.chess.com/game/live/120000000001",
+			Record:     gameRecord{Win: 210, Loss: 198, Draw: 17},
+		}
+		if !reflect.DeepEqual(summary.TimeClasses["rapid"], expected) {
+			t.Errorf("expected %+v, got %+v", expected, summary.TimeClasses["rapid"])
+		}
+		if _, ok := summary.TimeClasses["tactics"]; ok {
+			t.Errorf("expected no tactics in %v", summary.TimeClasses)
+		}
+	})
+
+	t.Run("unknown player", func(t *testing.T) {
+		_, err := refreshProfile("nobody")
+		if err == nil {
+			t.Errorf("expected an error, got %v", err)
+		}
+		_, err = newProfileSummary("nobody", nil)
+		if err == nil {
+			t.Errorf("expected an error, got %v", err)
+		}
+	})
+}
+
+func TestRatingHistory(t *testing.T) {
+	snapshot := func(fetched time.Time, rapid float64) profileSnapshot {
+		return profileSnapshot{
+			Fetched: fetched,
+			Profile: map[string]interface{}{},
+			Stats: map[string]interface{}{
+				"chess_rapid": map[string]interface{}{
+					"last": map[string]interface{}{"rating": rapid, "date": float64(fetched.Unix())},
+				},
+			},
+		}
+	}
+	first := time.Date(2025, time.January, 1, 0, 0, 0, 0, time.UTC)
+	second := time.Date(2025, time.February, 1, 0, 0, 0, 0, time.UTC)
+
+	summary, err := newProfileSummary("asdf", []profileSnapshot{snapshot(first, 1200), snapshot(second, 1250)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []ratingHistoryEntry{
+		{first, map[string]int{"rapid": 1200}},
+		{second, map[string]int{"rapid": 1250}},
+	}
+	if !reflect.DeepEqual(summary.RatingHistory, expected) {
+		t.Errorf("expected %v, got %v", expected, summary.RatingHistory)
+	}
+	if summary.TimeClasses["rapid"].Rating != 1250 {
+		t.Errorf("expected %v, got %v", 1250, summary.TimeClasses["rapid"].Rating)
+	}
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// In record mode every chess.com response is saved to the fixture directory,
+// one file per URL, replacing any earlier recording of that URL.
+// In replay mode nothing is sent upstream: responses come only from those
+// files, and a URL without one is an error.
+
+var errFixtureMissing = errors.New("no recorded response")
+
+type recordedResponse struct {
+	URL        string      `json:"url"`
+	StatusCode int         `json:"status"`
+	Header     http.Header `json:"header"`
+	Body       string      `json:"body"`
+	Recorded   time.Time   `json:"recorded"`
+}
+
+// the fixture file of a URL: its path with "/" replaced by "_",
+// plus a hash of the query string if there is one
+func fixturePath(dir string, rawURL string) (path string, err error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		err = fmt.Errorf("url.Parse: %w", err)
+		return "", WrapError(err)
+	}
+
+	name := strings.ReplaceAll(strings.Trim(u.Path, "/"), "/", "_")
+	name = strings.Map(func(r rune) rune {
+		if r == '.' || r == '\\' {
+			return '_'
+		}
+		return r
+	}, name)
+	if u.RawQuery != "" {
+		sum := sha256.Sum256([]byte(u.RawQuery))
+		name += "_" + hex.EncodeToString(sum[:6])
+	}
+	if name == "" {
+		name = "_"
+	}
+
+	return filepath.Join(dir, name+".json"), nil
+}
+
+// saves a response to the fixture directory
+func (c *chessComClient) record(url string, statusCode int, header http.Header, body []byte) (err error) {
+	path, err := fixturePath(c.fixtureDir, url)
+	if err != nil {
+		err = fmt.Errorf("fixturePath: %w", err)
+		return WrapError(err)
+	}
+
+	data, err := json.MarshalIndent(recordedResponse{
+		URL:        url,
+		StatusCode: statusCode,
+		Header:     header,
+		Body:       string(body),
+		Recorded:   time.Now().UTC(),
+	}, "", "  ")
+	if err != nil {
+		err = fmt.Errorf("json.MarshalIndent: %w", err)
+		return WrapError(err)
+	}
+
+	err = os.MkdirAll(c.fixtureDir, 0755)
+	if err != nil {
+		err = fmt.Errorf("os.MkdirAll: %w", err)
+		return WrapError(err)
+	}
+	err = os.WriteFile(path, data, 0644)
+	if err != nil {
+		err = fmt.Errorf("os.WriteFile: %w", err)
+		return WrapError(err)
+	}
+
+	return nil
+}
+
+// answers a request from the fixture directory, as chess.com answered it when recorded
+func (c *chessComClient) replay(url string, validators cacheValidators) (resp chessComResponse, err error) {
+	path, err := fixturePath(c.fixtureDir, url)
+	if err != nil {
+		err = fmt.Errorf("fixturePath: %w", err)
+		return chessComResponse{}, WrapError(err)
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		err = fmt.Errorf("%w for %s in %s", errFixtureMissing, url, c.fixtureDir)
+		return chessComResponse{}, WrapError(err)
+	}
+	if err != nil {
+		err = fmt.Errorf("os.ReadFile: %w", err)
+		return chessComResponse{}, WrapError(err)
+	}
+
+	var recorded recordedResponse
+	err = json.Unmarshal(data, &recorded)
+	if err != nil {
+		err = fmt.Errorf("json.Unmarshal(%s): %w", path, err)
+		return chessComResponse{}, WrapError(err)
+	}
+
+	if recorded.StatusCode != http.StatusOK {
+		err = &upstreamStatusError{URL: url, StatusCode: recorded.StatusCode}
+		return chessComResponse{}, WrapError(err)
+	}
+
+	resp = chessComResponse{
+		StatusCode: http.StatusOK,
+		Body:       []byte(recorded.Body),
+		Validators: cacheValidators{
+			ETag:         recorded.Header.Get("ETag"),
+			LastModified: recorded.Header.Get("Last-Modified"),
+		},
+	}
+
+	// the recording cannot have changed since the copy the caller holds
+	if (validators.ETag != "" && validators.ETag == resp.Validators.ETag) ||
+		(validators.LastModified != "" && validators.LastModified == resp.Validators.LastModified) {
+		return chessComResponse{StatusCode: http.StatusNotModified, Validators: resp.Validators}, nil
+	}
+
+	return resp, nil
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"chess-analyzer/fakechesscom"
+)
+
+func TestRecordAndReplay(t *testing.T) {
+	appConfig.DataDir = t.TempDir()
+	defer func() { appConfig = defaultConfig() }()
+
+	upstream := fakechesscom.New()
+	err := upstream.LoadFixtures("testdata")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := defaultConfig().ChessCom
+	c.MinInterval = 0
+	c.MaxRetries = 0
+	c.Mode = "record"
+	c.FixtureDir = t.TempDir()
+	recorder := newChessComClient(c)
+
+	type testCase struct {
+		// Input Params
+		path string
+		// Expected Values
+		isErr bool
+	}
+
+	tests := []testCase{
+		{"/player/asdf/games/archives", false},
+		{"/player/asdf/games/2025/02", false},
+		{"/player/asdf/stats", false},
+		{"/player/nobody", true},
+	}
+
+	recorded := make(map[string]map[string]interface{})
+	t.Run("record", func(t *testing.T) {
+		for _, test := range tests {
+			data, err := recorder.getJSON(upstream.BaseURL() + test.path)
+			if (err != nil) != test.isErr {
+				t.Errorf("%s: expected error %v, got %v", test.path, test.isErr, err)
+			}
+			recorded[test.path] = data
+		}
+	})
+
+	// nothing may reach chess.com from here on
+	baseURL := upstream.BaseURL()
+	upstream.Close()
+	c.Mode = "replay"
+	replayer := newChessComClient(c)
+
+	t.Run("replay", func(t *testing.T) {
+		for _, test := range tests {
+			data, err := replayer.getJSON(baseURL + test.path)
+			if (err != nil) != test.isErr {
+				t.Errorf("%s: expected error %v, got %v", test.path, test.isErr, err)
+			}
+			if !reflect.DeepEqual(data, recorded[test.path]) {
+				t.Errorf("%s: expected %v, got %v", test.path, recorded[test.path], data)
+			}
+		}
+
+		_, err := replayer.getJSON(baseURL + "/player/asdf/games/2025/01")
+		if !errors.Is(err, errFixtureMissing) {
+			t.Errorf("expected %v, got %v", errFixtureMissing, err)
+		}
+	})
+
+	t.Run("replay conditional", func(t *testing.T) {
+		url := baseURL + "/player/asdf/games/2025/02"
+		_, modified, err := replayer.getJSONIfModified(url, false)
+		if err != nil || !modified {
+			t.Fatalf("expected %v, got %v (%v)", true, modified, err)
+		}
+		_, modified, err = replayer.getJSONIfModified(url, true)
+		if err != nil || modified {
+			t.Errorf("expected %v, got %v (%v)", false, modified, err)
+		}
+	})
+}
@@ -1,43 +1,118 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
-	"log"
+	"io"
 	"net/http"
-	"os"
+	"strconv"
+	"strings"
+	"time"
 )
 
+// the largest PGN accepted by POST /api/pgn
+const maxPGNUploadBytes = 10 << 20
+
+// the largest backup accepted by POST /api/admin/restore
+const maxRestoreUploadBytes = 1 << 30
+
 // https://go.dev/blog/error-handling-and-go
 // Handles errors and logging
 type appHandler func(http.ResponseWriter, *http.Request) (err error)
 
 func (fn appHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
 	// Logging the request
-	log.SetOutput(os.Stdout)
-	log.Printf("Received request: %s %s", r.Method, r.URL.Path)
+	id := requestID(r)
+	w.Header().Set("X-Request-ID", id)
+	requestLog.Printf("Received request %s: %s %s", id, r.Method, r.URL.Path)
 
 	// Handling the request and capturing any error
-	err := fn(w, r)
+	sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+	err := fn(sw, r)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(sw, id, err)
 	}
+
+	// by the pattern rather than the path, which holds player names and game IDs
+	httpRequests.add(1, r.Pattern, strconv.Itoa(sw.status))
+	httpRequestSeconds.observeSince(start, r.Pattern)
+}
+
+// remembers the status of a response for the metrics
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (sw *statusWriter) WriteHeader(status int) {
+	sw.status = status
+	sw.ResponseWriter.WriteHeader(status)
+}
+
+// lets http.ResponseController reach the underlying writer
+func (sw *statusWriter) Unwrap() http.ResponseWriter {
+	return sw.ResponseWriter
 }
 
 // GET /healthz
 func Healthz(w http.ResponseWriter, r *http.Request) (err error) {
 	data := "OK"
-	// Write the JSON response
+	// Write the plaintext response
 	w.Header().Set("Content-Type", "text/plain")
 	w.Write([]byte(data))
 
 	return nil
 }
 
+// GET /readyz
+func Readyz(w http.ResponseWriter, r *http.Request) (err error) {
+	report := checkReadiness()
+
+	data, err := report.prettyPrint()
+	if err != nil {
+		err = fmt.Errorf("report.prettyPrint: %w", err)
+		return WrapError(err)
+	}
+	// Write the JSON response, 503 takes the pod out of the Service until it is ready
+	w.Header().Set("Content-Type", "application/json")
+	if !report.Ready {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	w.Write([]byte(data))
+
+	return nil
+}
+
+// GET /metrics
+func Metrics(w http.ResponseWriter, r *http.Request) (err error) {
+	// Write the Prometheus text response
+	w.Header().Set("Content-Type", metricsContentType)
+	writeMetrics(w)
+
+	return nil
+}
+
+// GET /api/openapi.json
+func APIopenAPIGet(w http.ResponseWriter, r *http.Request) (err error) {
+	// Write the JSON response
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(openAPIDocument)
+
+	return nil
+}
+
 // GET /api/{player}
+// GET /api/lichess/{player}/archives
 func APIarchiveListGet(w http.ResponseWriter, r *http.Request) (err error) {
-	player := r.PathValue("player")
+	site := requestSource(r)
+	player, err := parsePlayer(r.PathValue("player"))
+	if err != nil {
+		err = fmt.Errorf("parsePlayer: %w", err)
+		return WrapError(err)
+	}
 
-	al, err := NewArchiveList(player, "db")
+	al, err := NewArchiveList(site, player, "db")
 	if err != nil {
 		err = fmt.Errorf("NewArchiveList: %w", err)
 		return WrapError(err)
@@ -56,39 +131,44 @@ func APIarchiveListGet(w http.ResponseWriter, r *http.Request) (err error) {
 }
 
 // POST /api/{player}
+// POST /api/lichess/{player}/archives
 func APIarchiveListPost(w http.ResponseWriter, r *http.Request) (err error) {
-	player := r.PathValue("player")
-
-	al, err := NewArchiveList(player, "api")
-	if err != nil {
-		err = fmt.Errorf("NewArchiveList: %w", err)
-		return WrapError(err)
-	}
-
-	// read from the object and write it to the database
-	db, err := newDatabase("archive_list", al.Player)
+	site := requestSource(r)
+	player, err := parsePlayer(r.PathValue("player"))
 	if err != nil {
-		err = fmt.Errorf("newDatabase: %w", err)
+		err = fmt.Errorf("parsePlayer: %w", err)
 		return WrapError(err)
 	}
 
-	err = db.writeData(al.ArchiveList)
+	al, err := NewArchiveList(site, player, "api")
 	if err != nil {
-		err = fmt.Errorf("db.writeData: %w", err)
+		err = fmt.Errorf("NewArchiveList: %w", err)
 		return WrapError(err)
 	}
 
 	// Write the plaintext response
 	w.Header().Set("Content-Type", "text/plain")
-	w.Write([]byte("Archive List Updated"))
+	if al.Unchanged {
+		w.Write([]byte("Archive List Unchanged"))
+	} else {
+		w.Write([]byte("Archive List Updated"))
+	}
 
 	return nil
 }
 
 // GET /api/{player}/{archive}
+// GET /api/{player}/{archive}.pgn
+// GET /api/lichess/{player}/{archive}
+// GET /api/lichess/{player}/{archive}.pgn
 func APIarchiveDataGet(w http.ResponseWriter, r *http.Request) (err error) {
-	player := r.PathValue("player")
-	archive := r.PathValue("archive")
+	site := requestSource(r)
+	player, err := parsePlayer(r.PathValue("player"))
+	if err != nil {
+		err = fmt.Errorf("parsePlayer: %w", err)
+		return WrapError(err)
+	}
+	archive, asPGN := strings.CutSuffix(r.PathValue("archive"), ".pgn")
 
 	year, month, err := archiveToYearMonth(archive)
 	if err != nil {
@@ -96,27 +176,59 @@ func APIarchiveDataGet(w http.ResponseWriter, r *http.Request) (err error) {
 		return WrapError(err)
 	}
 
-	ad, err := NewArchiveData(player, year, month, "db")
+	if asPGN {
+		ad, err := NewArchiveData(site, player, year, month, "db")
+		if err != nil {
+			err = fmt.Errorf("NewArchiveData: %w", err)
+			return WrapError(err)
+		}
+		pgn, err := archivePGN(ad)
+		if err != nil {
+			err = fmt.Errorf("archivePGN: %w", err)
+			return WrapError(err)
+		}
+		// Write the PGN response
+		w.Header().Set("Content-Type", "application/x-chess-pgn")
+		w.Write([]byte(pgn))
+		return nil
+	}
+
+	q, format, err := newListingRequest(r)
+	if err != nil {
+		err = fmt.Errorf("newListingRequest: %w", err)
+		return WrapError(err)
+	}
+
+	ad, err := NewArchiveData(site, player, year, month, "db")
 	if err != nil {
 		err = fmt.Errorf("NewArchiveData: %w", err)
 		return WrapError(err)
 	}
 
-	data, err := ad.prettyPrint()
+	gl, err := newGameListing(ad, q)
 	if err != nil {
-		err = fmt.Errorf("ad.prettyPrint: %w", err)
+		err = fmt.Errorf("newGameListing: %w", err)
+		return WrapError(err)
+	}
+
+	err = writeListing(w, gl, format)
+	if err != nil {
+		err = fmt.Errorf("writeListing: %w", err)
 		return WrapError(err)
 	}
-	// Write the JSON response
-	w.Header().Set("Content-Type", "application/json")
-	w.Write([]byte(data))
 
 	return nil
 }
 
 // POST /api/{player}/{archive}
+// POST /api/lichess/{player}/{archive}
 func APIarchiveDataPost(w http.ResponseWriter, r *http.Request) (err error) {
-	player := r.PathValue("player")
+	site := requestSource(r)
+	player, err := parsePlayer(r.PathValue("player"))
+	if err != nil {
+		err = fmt.Errorf("parsePlayer: %w", err)
+		return WrapError(err)
+	}
 	archive := r.PathValue("archive")
 
 	year, month, err := archiveToYearMonth(archive)
@@ -125,38 +237,113 @@ func APIarchiveDataPost(w http.ResponseWriter, r *http.Request) (err error) {
 		return WrapError(err)
 	}
 
-	ad, err := NewArchiveData(player, year, month, "api")
+	ad, err := NewArchiveData(site, player, year, month, "api")
 	if err != nil {
 		err = fmt.Errorf("NewArchiveData: %w", err)
 		return WrapError(err)
 	}
 
-	// read from the object and write it to the database
-	db, err := newDatabase("archive_data", ad.Player)
+	// Write the plaintext response
+	w.Header().Set("Content-Type", "text/plain")
+	if ad.Unchanged {
+		w.Write([]byte("Archive Data Unchanged"))
+	} else {
+		w.Write([]byte(fmt.Sprintf("Archive Data Updated (%d games added)", ad.Added)))
+	}
+
+	return nil
+}
+
+// GET /api/{player}/games?from=YYYY-MM&to=YYYY-MM
+// GET /api/lichess/{player}/games?from=YYYY-MM&to=YYYY-MM
+func APIrangeListGet(w http.ResponseWriter, r *http.Request) (err error) {
+	site := requestSource(r)
+	player, err := parsePlayer(r.PathValue("player"))
 	if err != nil {
-		err = fmt.Errorf("newDatabase: %w", err)
+		err = fmt.Errorf("parsePlayer: %w", err)
 		return WrapError(err)
 	}
 
-	err = db.writeData(ad.ArchiveData)
+	ar, err := parseArchiveRange(r.URL.Query())
 	if err != nil {
-		err = fmt.Errorf("db.writeData: %w", err)
+		err = fmt.Errorf("parseArchiveRange: %w", err)
 		return WrapError(err)
 	}
 
-	// Write the plaintext response
-	w.Header().Set("Content-Type", "text/plain")
-	w.Write([]byte("Archive Data Updated"))
+	q, format, err := newListingRequest(r)
+	if err != nil {
+		err = fmt.Errorf("newListingRequest: %w", err)
+		return WrapError(err)
+	}
+
+	gl, err := newRangeListing(site, player, ar, q)
+	if err != nil {
+		err = fmt.Errorf("newRangeListing: %w", err)
+		return WrapError(err)
+	}
+
+	err = writeListing(w, gl, format)
+	if err != nil {
+		err = fmt.Errorf("writeListing: %w", err)
+		return WrapError(err)
+	}
+
+	return nil
+}
+
+// POST /api/{player}/games?from=YYYY-MM&to=YYYY-MM
+// POST /api/lichess/{player}/games?from=YYYY-MM&to=YYYY-MM
+func APIrangeRefreshPost(w http.ResponseWriter, r *http.Request) (err error) {
+	site := requestSource(r)
+	player, err := parsePlayer(r.PathValue("player"))
+	if err != nil {
+		err = fmt.Errorf("parsePlayer: %w", err)
+		return WrapError(err)
+	}
+
+	ar, err := parseArchiveRange(r.URL.Query())
+	if err != nil {
+		err = fmt.Errorf("parseArchiveRange: %w", err)
+		return WrapError(err)
+	}
+
+	report, err := refreshArchiveRange(site, player, ar)
+	if err != nil {
+		err = fmt.Errorf("refreshArchiveRange: %w", err)
+		return WrapError(err)
+	}
+
+	data, err := report.prettyPrint()
+	if err != nil {
+		err = fmt.Errorf("report.prettyPrint: %w", err)
+		return WrapError(err)
+	}
+	// Write the JSON response
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte(data))
 
 	return nil
 }
 
 // GET /api/{player}/{archive}/{uuid}
+// GET /api/{player}/{archive}/{uuid}.pgn
+// GET /api/lichess/{player}/{archive}/{uuid}
+// GET /api/lichess/{player}/{archive}/{uuid}.pgn
 func APIresultGet(w http.ResponseWriter, r *http.Request) (err error) {
-	player := r.PathValue("player")
+	site := requestSource(r)
+	player, err := parsePlayer(r.PathValue("player"))
+	if err != nil {
+		err = fmt.Errorf("parsePlayer: %w", err)
+		return WrapError(err)
+	}
 	archive := r.PathValue("archive")
-	uuid := r.PathValue("uuid")
-	verbosity := "full"
+	uuid, asPGN := strings.CutSuffix(r.PathValue("uuid"), ".pgn")
+
+	err = parseGameID(site, uuid)
+	if err != nil {
+		err = fmt.Errorf("parseGameID: %w", err)
+		return WrapError(err)
+	}
 
 	year, month, err := archiveToYearMonth(archive)
 	if err != nil {
@@ -164,7 +351,7 @@ func APIresultGet(w http.ResponseWriter, r *http.Request) (err error) {
 		return WrapError(err)
 	}
 
-	ad, err := NewArchiveData(player, year, month, "db")
+	ad, err := NewArchiveData(site, player, year, month, "db")
 	if err != nil {
 		err = fmt.Errorf("NewArchiveData: %w", err)
 		return WrapError(err)
@@ -175,31 +362,41 @@ func APIresultGet(w http.ResponseWriter, r *http.Request) (err error) {
 		err = fmt.Errorf("createResultFromArchiveDataAndUUID: %w", err)
 		return WrapError(err)
 	}
-	data, err := result.prettyPrint(verbosity)
+
+	err = writeResult(w, result, asPGN)
 	if err != nil {
-		err = fmt.Errorf("result.prettyPrint: %w", err)
+		err = fmt.Errorf("writeResult: %w", err)
 		return WrapError(err)
 	}
-	// Write the JSON response
-	w.Header().Set("Content-Type", "application/json")
-	w.Write([]byte(data))
 
 	return nil
 }
 
 // POST /api/{player}/{archive}/{uuid}
+// POST /api/lichess/{player}/{archive}/{uuid}
 func APIresultPost(w http.ResponseWriter, r *http.Request) (err error) {
-	player := r.PathValue("player")
+	site := requestSource(r)
+	player, err := parsePlayer(r.PathValue("player"))
+	if err != nil {
+		err = fmt.Errorf("parsePlayer: %w", err)
+		return WrapError(err)
+	}
 	archive := r.PathValue("archive")
 	uuid := r.PathValue("uuid")
 
+	err = parseGameID(site, uuid)
+	if err != nil {
+		err = fmt.Errorf("parseGameID: %w", err)
+		return WrapError(err)
+	}
+
 	year, month, err := archiveToYearMonth(archive)
 	if err != nil {
 		err = fmt.Errorf("archiveToYearMonth: %w", err)
 		return WrapError(err)
 	}
 
-	ad, err := NewArchiveData(player, year, month, "db")
+	ad, err := NewArchiveData(site, player, year, month, "db")
 	if err != nil {
 		err = fmt.Errorf("NewArchiveData: %w", err)
 		return WrapError(err)
@@ -211,7 +408,363 @@ func APIresultPost(w http.ResponseWriter, r *http.Request) (err error) {
 		return WrapError(err)
 	}
 
-	result.analyzeGame()
+	err = result.analyzeGame()
+	if err != nil {
+		err = fmt.Errorf("result.analyzeGame: %w", err)
+		return WrapError(err)
+	}
+
+	// Write the plaintext response
+	w.Header().Set("Content-Type", "text/plain")
+	w.Write([]byte("Result Updated"))
+
+	return nil
+}
+
+// GET /api/games/{uuid}
+// GET /api/games/{uuid}.pgn
+func APIgameGet(w http.ResponseWriter, r *http.Request) (err error) {
+	uuid, asPGN := strings.CutSuffix(r.PathValue("uuid"), ".pgn")
+
+	err = parseIndexedGameID(uuid)
+	if err != nil {
+		err = fmt.Errorf("parseIndexedGameID: %w", err)
+		return WrapError(err)
+	}
+
+	result, err := resultFromUUID(uuid)
+	if err != nil {
+		err = fmt.Errorf("resultFromUUID: %w", err)
+		return WrapError(err)
+	}
+
+	err = writeResult(w, result, asPGN)
+	if err != nil {
+		err = fmt.Errorf("writeResult: %w", err)
+		return WrapError(err)
+	}
+
+	return nil
+}
+
+// writes a result as JSON, or as PGN with its analysis for the routes ending in .pgn
+func writeResult(w http.ResponseWriter, result result, asPGN bool) (err error) {
+	if asPGN {
+		pgn, err := result.annotatedPGN()
+		if err != nil {
+			err = fmt.Errorf("result.annotatedPGN: %w", err)
+			return WrapError(err)
+		}
+		// Write the PGN response
+		w.Header().Set("Content-Type", "application/x-chess-pgn")
+		w.Write([]byte(pgn))
+		return nil
+	}
+
+	data, err := result.prettyPrint("full")
+	if err != nil {
+		err = fmt.Errorf("result.prettyPrint: %w", err)
+		return WrapError(err)
+	}
+	// Write the JSON response
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte(data))
+
+	return nil
+}
+
+// POST /api/games/{uuid}
+func APIgamePost(w http.ResponseWriter, r *http.Request) (err error) {
+	uuid := r.PathValue("uuid")
+
+	err = parseIndexedGameID(uuid)
+	if err != nil {
+		err = fmt.Errorf("parseIndexedGameID: %w", err)
+		return WrapError(err)
+	}
+
+	result, err := resultFromUUID(uuid)
+	if err != nil {
+		err = fmt.Errorf("resultFromUUID: %w", err)
+		return WrapError(err)
+	}
+
+	err = result.analyzeGame()
+	if err != nil {
+		err = fmt.Errorf("result.analyzeGame: %w", err)
+		return WrapError(err)
+	}
+
+	// Write the plaintext response
+	w.Header().Set("Content-Type", "text/plain")
+	w.Write([]byte("Result Updated"))
+
+	return nil
+}
+
+// serves /api/games/{uuid} from the /api/{player}/{archive} route: ServeMux
+// refuses to have both /api/games/{uuid} and /api/{player}/search, as
+// neither is more specific than the other. "games" is a reserved player name,
+// so no player's routes are shadowed by it.
+func withGamesRoute(games appHandler, archive appHandler) appHandler {
+	return func(w http.ResponseWriter, r *http.Request) (err error) {
+		if r.PathValue("player") == "games" {
+			r.SetPathValue("uuid", r.PathValue("archive"))
+			// the route the request is counted under in the metrics
+			r.Pattern = r.Method + " /api/games/{uuid}"
+			return games(w, r)
+		}
+		return archive(w, r)
+	}
+}
+
+// GET /api/{player}/search?from=YYYY-MM-DD&to=YYYY-MM-DD
+// GET /api/lichess/{player}/search?from=YYYY-MM-DD&to=YYYY-MM-DD
+// a search ranges over the days games were played, /games over archive months
+func APIsearchGet(w http.ResponseWriter, r *http.Request) (err error) {
+	site := requestSource(r)
+	player, err := parsePlayer(r.PathValue("player"))
+	if err != nil {
+		err = fmt.Errorf("parsePlayer: %w", err)
+		return WrapError(err)
+	}
+
+	q, err := newGameQuery(r.URL.Query())
+	if err != nil {
+		err = fmt.Errorf("newGameQuery: %w", err)
+		return WrapError(err)
+	}
+
+	gi, err := readGameIndex(site, player)
+	if err != nil {
+		err = fmt.Errorf("readGameIndex: %w", err)
+		return WrapError(err)
+	}
+
+	data, err := json.MarshalIndent(gi.query(q), "", "  ")
+	if err != nil {
+		err = fmt.Errorf("json.MarshalIndent: %w", err)
+		return WrapError(err)
+	}
+	// Write the JSON response
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+
+	return nil
+}
+
+// POST /api/{player}/sync
+// POST /api/lichess/{player}/sync
+func APIsyncPost(w http.ResponseWriter, r *http.Request) (err error) {
+	site := requestSource(r)
+	player, err := parsePlayer(r.PathValue("player"))
+	if err != nil {
+		err = fmt.Errorf("parsePlayer: %w", err)
+		return WrapError(err)
+	}
+
+	report, err := syncPlayer(site, player, time.Now())
+	if err != nil {
+		err = fmt.Errorf("syncPlayer: %w", err)
+		return WrapError(err)
+	}
+
+	data, err := report.prettyPrint()
+	if err != nil {
+		err = fmt.Errorf("report.prettyPrint: %w", err)
+		return WrapError(err)
+	}
+	// Write the JSON response
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte(data))
+
+	return nil
+}
+
+// GET /api/{player}/profile
+func APIprofileGet(w http.ResponseWriter, r *http.Request) (err error) {
+	player, err := parsePlayer(r.PathValue("player"))
+	if err != nil {
+		err = fmt.Errorf("parsePlayer: %w", err)
+		return WrapError(err)
+	}
+
+	snapshots, err := readProfileSnapshots(player)
+	if err != nil {
+		err = fmt.Errorf("readProfileSnapshots: %w", err)
+		return WrapError(err)
+	}
+
+	summary, err := newProfileSummary(player, snapshots)
+	if err != nil {
+		err = fmt.Errorf("newProfileSummary: %w", err)
+		return WrapError(err)
+	}
+
+	data, err := summary.prettyPrint()
+	if err != nil {
+		err = fmt.Errorf("summary.prettyPrint: %w", err)
+		return WrapError(err)
+	}
+	// Write the JSON response
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte(data))
+
+	return nil
+}
+
+// POST /api/{player}/profile
+func APIprofilePost(w http.ResponseWriter, r *http.Request) (err error) {
+	player, err := parsePlayer(r.PathValue("player"))
+	if err != nil {
+		err = fmt.Errorf("parsePlayer: %w", err)
+		return WrapError(err)
+	}
+
+	ps, err := refreshProfile(player)
+	if err != nil {
+		err = fmt.Errorf("refreshProfile: %w", err)
+		return WrapError(err)
+	}
+
+	// Write the plaintext response
+	w.Header().Set("Content-Type", "text/plain")
+	w.Write([]byte(fmt.Sprintf("Profile Updated (%s)", ps.Fetched.Format(time.RFC3339))))
+
+	return nil
+}
+
+// POST /api/position
+func APIpositionPost(w http.ResponseWriter, r *http.Request) (err error) {
+	req, gp, err := newPositionRequest(r.Body)
+	if err != nil {
+		err = fmt.Errorf("newPositionRequest: %w", err)
+		return WrapError(err)
+	}
+
+	pa, err := analyzePosition(req, gp)
+	if err != nil {
+		err = fmt.Errorf("analyzePosition: %w", err)
+		return WrapError(err)
+	}
+
+	data, err := pa.prettyPrint()
+	if err != nil {
+		err = fmt.Errorf("pa.prettyPrint: %w", err)
+		return WrapError(err)
+	}
+	// Write the JSON response
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte(data))
+
+	return nil
+}
+
+// POST /api/pgn
+func APIpgnPost(w http.ResponseWriter, r *http.Request) (err error) {
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxPGNUploadBytes))
+	if err != nil {
+		err = invalidInputError("io.ReadAll: %w", err)
+		return WrapError(err)
+	}
+
+	report, err := importPGN(string(body))
+	if err != nil {
+		err = fmt.Errorf("importPGN: %w", err)
+		return WrapError(err)
+	}
+
+	data, err := report.prettyPrint()
+	if err != nil {
+		err = fmt.Errorf("report.prettyPrint: %w", err)
+		return WrapError(err)
+	}
+	// Write the JSON response
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte(data))
+
+	return nil
+}
+
+// GET /api/pgn/games
+func APIimportedListGet(w http.ResponseWriter, r *http.Request) (err error) {
+	present, err := listImported()
+	if err != nil {
+		err = fmt.Errorf("listImported: %w", err)
+		return WrapError(err)
+	}
+
+	data, err := json.MarshalIndent(present, "", "  ")
+	if err != nil {
+		err = fmt.Errorf("json.MarshalIndent: %w", err)
+		return WrapError(err)
+	}
+	// Write the JSON response
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+
+	return nil
+}
+
+// GET /api/pgn/games/{uuid}
+// GET /api/pgn/games/{uuid}.pgn
+func APIimportedResultGet(w http.ResponseWriter, r *http.Request) (err error) {
+	uuid, asPGN := strings.CutSuffix(r.PathValue("uuid"), ".pgn")
+
+	err = parseImportedGameID(uuid)
+	if err != nil {
+		err = fmt.Errorf("parseImportedGameID: %w", err)
+		return WrapError(err)
+	}
+
+	gameMap, err := readImportedGame(uuid)
+	if err != nil {
+		err = fmt.Errorf("readImportedGame: %w", err)
+		return WrapError(err)
+	}
+
+	result, err := NewResult(gameMap)
+	if err != nil {
+		err = fmt.Errorf("NewResult: %w", err)
+		return WrapError(err)
+	}
+
+	err = writeResult(w, result, asPGN)
+	if err != nil {
+		err = fmt.Errorf("writeResult: %w", err)
+		return WrapError(err)
+	}
+
+	return nil
+}
+
+// POST /api/pgn/games/{uuid}
+func APIimportedResultPost(w http.ResponseWriter, r *http.Request) (err error) {
+	uuid := r.PathValue("uuid")
+
+	err = parseImportedGameID(uuid)
+	if err != nil {
+		err = fmt.Errorf("parseImportedGameID: %w", err)
+		return WrapError(err)
+	}
+
+	gameMap, err := readImportedGame(uuid)
+	if err != nil {
+		err = fmt.Errorf("readImportedGame: %w", err)
+		return WrapError(err)
+	}
+
+	result, err := NewResult(gameMap)
+	if err != nil {
+		err = fmt.Errorf("NewResult: %w", err)
+		return WrapError(err)
+	}
+
+	err = result.analyzeGame()
+	if err != nil {
+		err = fmt.Errorf("result.analyzeGame: %w", err)
+		return WrapError(err)
+	}
 
 	// Write the plaintext response
 	w.Header().Set("Content-Type", "text/plain")
@@ -219,3 +772,63 @@ func APIresultPost(w http.ResponseWriter, r *http.Request) (err error) {
 
 	return nil
 }
+
+// GET /api/admin/fsck
+func APIfsckGet(w http.ResponseWriter, r *http.Request) (err error) {
+	return writeFsckReport(w, false)
+}
+
+// POST /api/admin/fsck
+func APIfsckPost(w http.ResponseWriter, r *http.Request) (err error) {
+	return writeFsckReport(w, true)
+}
+
+func writeFsckReport(w http.ResponseWriter, repair bool) (err error) {
+	report, err := runFsck(repair)
+	if err != nil {
+		err = fmt.Errorf("runFsck: %w", err)
+		return WrapError(err)
+	}
+
+	data, err := report.prettyPrint()
+	if err != nil {
+		err = fmt.Errorf("report.prettyPrint: %w", err)
+		return WrapError(err)
+	}
+	// Write the JSON response
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte(data))
+
+	return nil
+}
+
+// GET /api/admin/backup
+func APIbackupGet(w http.ResponseWriter, r *http.Request) (err error) {
+	filename := fmt.Sprintf("chess-analyzer-%s.tar.gz", time.Now().UTC().Format("20060102T150405Z"))
+
+	// Write the tar.gz response as it is built
+	w.Header().Set("Content-Type", "application/gzip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	err = writeBackup(w)
+	if err != nil {
+		err = fmt.Errorf("writeBackup: %w", err)
+		return WrapError(err)
+	}
+
+	return nil
+}
+
+// POST /api/admin/restore
+func APIrestorePost(w http.ResponseWriter, r *http.Request) (err error) {
+	restored, err := restoreBackup(http.MaxBytesReader(w, r.Body, maxRestoreUploadBytes))
+	if err != nil {
+		err = fmt.Errorf("restoreBackup: %w", err)
+		return WrapError(err)
+	}
+
+	// Write the plaintext response
+	w.Header().Set("Content-Type", "text/plain")
+	w.Write([]byte(fmt.Sprintf("Restored %d tables", restored)))
+
+	return nil
+}
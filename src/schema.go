@@ -0,0 +1,397 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"maps"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Tables are stored on disk as a versioned envelope:
+//
+//	{
+//	  "table_name": "PLAYER_archive_data.json",
+//	  "contents": "archive_data",
+//	  "schema_version": 3,
+//	  "data": { ... }
+//	}
+//
+// Tables written before versioning was introduced hold only the bare `data`
+// object and are treated as schema version 1.
+//
+// The schema version is that of every record in `data`, not only of the
+// envelope: a change to the shape of a stored record, e.g. a new field of an
+// analysis, bumps schemaVersion and adds the migration that upgrades the
+// records of older tables.
+
+// the schema version written by this binary
+const schemaVersion = 3
+
+// the version assumed for tables without a `schema_version` key
+const legacySchemaVersion = 1
+
+var errSchemaTooNew = errors.New("schema version is newer than this binary supports")
+
+// known table content types, used to recover the player from a table name
+var contentTypes = []string{
+	"archive_list", "archive_data", "analysis", "game_index", "quarantine", "http_cache", "profile", "imported", "uuid_index",
+	"lichess_archive_list", "lichess_archive_data", "lichess_game_index",
+}
+
+// migration upgrades the data of a single table by one schema version, the
+// whole table through Migrate, then each of its records through the Records
+// func of its content type, either being nil when there is nothing to do
+type migration struct {
+	Version     int // the version the data is at after the migration
+	Description string
+	Migrate     func(contentType string, data map[string]interface{}) (map[string]interface{}, error)
+	Records     map[string]func(key string, record interface{}) (interface{}, error) // by content type
+}
+
+// migrations must be ordered by Version, with no gaps, ending at schemaVersion
+var migrations = []migration{
+	{
+		// the envelope is added when the table is next written
+		// the records themselves are unchanged
+		Version:     2,
+		Description: "wrap table data in a versioned envelope",
+	},
+	{
+		Version:     3,
+		Description: "mark the analyses whose moves are evaluated and classified",
+		Records: map[string]func(key string, record interface{}) (interface{}, error){
+			"analysis": markEvaluatedAnalysis,
+		},
+	},
+}
+
+// sets "evaluated" on an analysis stored before it was recorded: analyses
+// stored before moves carried an eval and a classification are not, and have
+// no engine either
+func markEvaluatedAnalysis(key string, record interface{}) (interface{}, error) {
+	recordMap, ok := record.(map[string]interface{})
+	if !ok {
+		// left for fsck to quarantine
+		return record, nil
+	}
+	if _, ok := recordMap["evaluated"]; ok {
+		return recordMap, nil
+	}
+
+	evaluated := true
+	moves, _ := recordMap["moves"].(map[string]interface{})
+	for _, move := range moves {
+		moveMap, _ := move.(map[string]interface{})
+		_, hasEval := moveMap["eval"]
+		_, hasClassification := moveMap["classification"]
+		evaluated = evaluated && hasEval && hasClassification
+	}
+	recordMap["evaluated"] = evaluated
+	return recordMap, nil
+}
+
+// splits a decoded table file into its schema version and data
+func decodeTable(raw map[string]interface{}) (version int, data map[string]interface{}, err error) {
+	rawVersion, ok := raw["schema_version"]
+	if !ok {
+		// legacy table, the whole object is the data
+		return legacySchemaVersion, raw, nil
+	}
+
+	versionFloat, ok := rawVersion.(float64)
+	if !ok || versionFloat != float64(int(versionFloat)) || versionFloat < 1 {
+		err = fmt.Errorf("invalid schema_version: %v", rawVersion)
+		return 0, nil, WrapError(err)
+	}
+	version = int(versionFloat)
+
+	data = make(map[string]interface{})
+	if raw["data"] != nil {
+		data, ok = raw["data"].(map[string]interface{})
+		if !ok {
+			err = fmt.Errorf("'data' key is not a map[string]interface{}")
+			return 0, nil, WrapError(err)
+		}
+	}
+
+	return version, data, nil
+}
+
+// upgrades data stored at `version` to schemaVersion
+func migrateData(contentType string, version int, data map[string]interface{}) (migrated map[string]interface{}, err error) {
+	if version > schemaVersion {
+		err = conflictError("%w: found %d, supported %d", errSchemaTooNew, version, schemaVersion)
+		return nil, WrapError(err)
+	}
+
+	migrated = data
+	for _, m := range migrations {
+		if m.Version <= version {
+			continue
+		}
+		if m.Migrate != nil {
+			migrated, err = m.Migrate(contentType, migrated)
+			if err != nil {
+				err = fmt.Errorf("migration to version %d (%s): %w", m.Version, m.Description, err)
+				return nil, WrapError(err)
+			}
+		}
+
+		migrateRecord := m.Records[contentType]
+		if migrateRecord == nil {
+			continue
+		}
+		for key, record := range migrated {
+			migrated[key], err = migrateRecord(key, record)
+			if err != nil {
+				err = fmt.Errorf("migration to version %d (%s) of %s: %w", m.Version, m.Description, key, err)
+				return nil, WrapError(err)
+			}
+		}
+	}
+
+	return migrated, nil
+}
+
+// recovers the player and content type from a table name
+// e.g. "PLAYER_archive_data.json" -> "PLAYER", "archive_data"
+// and "PLAYER_lichess_archive_data.json" -> "PLAYER", "lichess_archive_data"
+func parseTableName(tableName string) (player string, contentType string, ok bool) {
+	name, found := strings.CutSuffix(tableName, ".json")
+	if !found {
+		return "", "", false
+	}
+	// the longest content type wins, "archive_data" is a suffix of "lichess_archive_data"
+	for _, ct := range contentTypes {
+		if p, found := strings.CutSuffix(name, "_"+ct); found && len(ct) > len(contentType) {
+			player, contentType, ok = p, ct, true
+		}
+	}
+	return player, contentType, ok
+}
+
+// lists the table files present in the data directory
+func listTables() (tableNames []string, err error) {
+	entries, err := os.ReadDir(appConfig.DataDir)
+	if err != nil {
+		err = fmt.Errorf("os.ReadDir: %w", err)
+		return nil, WrapError(err)
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		if _, _, ok := parseTableName(entry.Name()); ok {
+			tableNames = append(tableNames, entry.Name())
+		}
+	}
+
+	return tableNames, nil
+}
+
+// reads the schema version of a table file without migrating it
+func readTableVersion(tableName string) (version int, err error) {
+	file, err := os.Open(filepath.Join(appConfig.DataDir, tableName))
+	if err != nil {
+		err = fmt.Errorf("os.Open: %w", err)
+		return 0, WrapError(err)
+	}
+	defer file.Close()
+
+	raw := make(map[string]interface{})
+	err = json.NewDecoder(file).Decode(&raw)
+	if err != nil {
+		err = fmt.Errorf("decoder.Decode: %w", err)
+		return 0, WrapError(err)
+	}
+
+	version, _, err = decodeTable(raw)
+	if err != nil {
+		err = fmt.Errorf("decodeTable: %w", err)
+		return 0, WrapError(err)
+	}
+
+	return version, nil
+}
+
+// returns an error if any table was written by a newer binary
+func checkSchemaVersions() (err error) {
+	tableNames, err := listTables()
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			// nothing stored yet
+			return nil
+		}
+		err = fmt.Errorf("listTables: %w", err)
+		return WrapError(err)
+	}
+
+	for _, tableName := range tableNames {
+		version, err := readTableVersion(tableName)
+		if err != nil {
+			err = fmt.Errorf("readTableVersion(%s): %w", tableName, err)
+			return WrapError(err)
+		}
+		if version > schemaVersion {
+			err = conflictError("%s: %w: found %d, supported %d", tableName, errSchemaTooNew, version, schemaVersion)
+			return WrapError(err)
+		}
+	}
+
+	return nil
+}
+
+// renames the tables of players stored under a mixed-case name, as written
+// before players were normalized, to the lowercase name every lookup uses
+// records are merged into any lowercase table already there, whose records
+// win as they were written later, and the games of renamed archives are
+// reindexed
+func normalizeTableNames() (renamed []string, err error) {
+	tableNames, err := listTables()
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			// nothing stored yet
+			return nil, nil
+		}
+		err = fmt.Errorf("listTables: %w", err)
+		return nil, WrapError(err)
+	}
+
+	type sitePlayer struct {
+		site   gameSource
+		player string
+	}
+	reindex := make(map[sitePlayer]bool)
+	for _, tableName := range tableNames {
+		player, contentType, _ := parseTableName(tableName)
+		lower := strings.ToLower(player)
+		if player == lower {
+			continue
+		}
+
+		err = renameTable(tableName, contentType, lower)
+		if err != nil {
+			err = fmt.Errorf("renameTable(%s): %w", tableName, err)
+			return renamed, WrapError(err)
+		}
+		renamed = append(renamed, tableName)
+
+		site, base := sourceOfContentType(contentType)
+		if base == "archive_data" || base == "game_index" {
+			reindex[sitePlayer{site, lower}] = true
+		}
+	}
+
+	for sp := range reindex {
+		_, err = reindexPlayer(sp.site, sp.player)
+		if err != nil {
+			err = fmt.Errorf("reindexPlayer(%s): %w", sp.player, err)
+			return renamed, WrapError(err)
+		}
+	}
+	// the UUID index locates games by the player whose archives hold them
+	if len(reindex) > 0 {
+		_, err = rebuildUUIDIndex()
+		if err != nil {
+			err = fmt.Errorf("rebuildUUIDIndex: %w", err)
+			return renamed, WrapError(err)
+		}
+	}
+
+	return renamed, nil
+}
+
+// moves a table to the same content type of player, merging the records
+// game indexes are derived from the archives, so they are dropped instead
+func renameTable(tableName string, contentType string, player string) (err error) {
+	tablesMu.Lock()
+	defer tablesMu.Unlock()
+
+	old := database{TableName: tableName, ContentType: contentType}
+	if _, base := sourceOfContentType(contentType); base != "game_index" {
+		err = old.load()
+		if err != nil {
+			err = fmt.Errorf("old.load: %w", err)
+			return WrapError(err)
+		}
+
+		db := database{
+			TableName:   fmt.Sprintf("%s_%s.json", player, contentType),
+			ContentType: contentType,
+			Data:        make(map[string]interface{}),
+		}
+		err = db.load()
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			err = fmt.Errorf("db.load: %w", err)
+			return WrapError(err)
+		}
+
+		maps.Copy(old.Data, db.Data)
+		db.Data = old.Data
+		err = db.save()
+		if err != nil {
+			err = fmt.Errorf("db.save: %w", err)
+			return WrapError(err)
+		}
+	}
+
+	err = os.Remove(old.getFilePath())
+	if err != nil {
+		err = fmt.Errorf("os.Remove: %w", err)
+		return WrapError(err)
+	}
+
+	return nil
+}
+
+// upgrades every table in the data directory to schemaVersion
+func migrateTables() (err error) {
+	renamed, err := normalizeTableNames()
+	if err != nil {
+		err = fmt.Errorf("normalizeTableNames: %w", err)
+		return WrapError(err)
+	}
+	for _, tableName := range renamed {
+		fmt.Printf("%s: renamed to the lowercase player name\n", tableName)
+	}
+
+	tableNames, err := listTables()
+	if err != nil {
+		err = fmt.Errorf("listTables: %w", err)
+		return WrapError(err)
+	}
+
+	for _, tableName := range tableNames {
+		version, err := readTableVersion(tableName)
+		if err != nil {
+			err = fmt.Errorf("readTableVersion(%s): %w", tableName, err)
+			return WrapError(err)
+		}
+		if version == schemaVersion {
+			fmt.Printf("%s: already at version %d\n", tableName, version)
+			continue
+		}
+
+		player, contentType, _ := parseTableName(tableName)
+		db, err := newDatabase(contentType, player)
+		if err != nil {
+			err = fmt.Errorf("newDatabase: %w", err)
+			return WrapError(err)
+		}
+
+		// readData has already migrated the data in memory
+		err = db.writeTable()
+		if err != nil {
+			err = fmt.Errorf("db.writeTable: %w", err)
+			return WrapError(err)
+		}
+		fmt.Printf("%s: migrated from version %d to %d\n", tableName, version, schemaVersion)
+	}
+
+	return nil
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestMigrations(t *testing.T) {
+	t.Run("registry is contiguous and ends at schemaVersion", func(t *testing.T) {
+		expected := legacySchemaVersion + 1
+		for _, m := range migrations {
+			if m.Version != expected {
+				t.Errorf("expected %v, got %v", expected, m.Version)
+			}
+			expected++
+		}
+		if expected-1 != schemaVersion {
+			t.Errorf("expected %v, got %v", schemaVersion, expected-1)
+		}
+	})
+}
+
+func TestDecodeTable(t *testing.T) {
+	type testCase struct {
+		// Input Params
+		raw map[string]interface{}
+		// Expected Values
+		version int
+		dataKey string
+		isErr   bool
+	}
+
+	t.Run("decode table", func(t *testing.T) {
+		tests := []testCase{
+			{map[string]interface{}{"2025-02": []interface{}{}}, 1, "2025-02", false},
+			{map[string]interface{}{"schema_version": float64(2), "data": map[string]interface{}{"2025-03": nil}}, 2, "2025-03", false},
+			{map[string]interface{}{"schema_version": "2", "data": map[string]interface{}{}}, 0, "", true},
+			{map[string]interface{}{"schema_version": float64(2), "data": "games"}, 0, "", true},
+		}
+
+		for _, test := range tests {
+			actualVersion, actualData, actualErr := decodeTable(test.raw)
+			if actualVersion != test.version {
+				t.Errorf("expected %v, got %v", test.version, actualVersion)
+			}
+			if (actualErr != nil) != test.isErr {
+				t.Errorf("expected error %v, got %v", test.isErr, actualErr)
+			}
+			if _, ok := actualData[test.dataKey]; !ok && !test.isErr {
+				t.Errorf("expected key %v in %v", test.dataKey, actualData)
+			}
+		}
+	})
+}
+
+func TestMigrateData(t *testing.T) {
+	t.Run("newer version is refused", func(t *testing.T) {
+		_, err := migrateData("analysis", schemaVersion+1, map[string]interface{}{})
+		if !errors.Is(err, errSchemaTooNew) {
+			t.Errorf("expected %v, got %v", errSchemaTooNew, err)
+		}
+	})
+
+	t.Run("legacy data is upgraded", func(t *testing.T) {
+		data := map[string]interface{}{"uuid": map[string]interface{}{}}
+		migrated, err := migrateData("analysis", legacySchemaVersion, data)
+		if err != nil {
+			t.Errorf("expected %v, got %v", nil, err)
+		}
+		if _, ok := migrated["uuid"]; !ok {
+			t.Errorf("expected key %v in %v", "uuid", migrated)
+		}
+	})
+
+	t.Run("records of the content type are upgraded", func(t *testing.T) {
+		defer func(registered []migration) { migrations = registered }(migrations)
+		migrations = append(slices.Clone(migrations), migration{
+			Version:     schemaVersion + 1,
+			Description: "mark analyses",
+			Records: map[string]func(key string, record interface{}) (interface{}, error){
+				"analysis": func(key string, record interface{}) (interface{}, error) {
+					recordMap, _ := record.(map[string]interface{})
+					recordMap["marked"] = true
+					return recordMap, nil
+				},
+			},
+		})
+
+		for _, contentType := range []string{"analysis", "profile"} {
+			data := map[string]interface{}{"uuid": map[string]interface{}{}}
+			migrated, err := migrateData(contentType, schemaVersion, data)
+			if err != nil {
+				t.Fatalf("expected %v, got %v", nil, err)
+			}
+			_, marked := migrated["uuid"].(map[string]interface{})["marked"]
+			if marked != (contentType == "analysis") {
+				t.Errorf("%s: expected marked %v, got %v", contentType, contentType == "analysis", migrated)
+			}
+		}
+	})
+}
+
+func TestParseTableName(t *testing.T) {
+	type testCase struct {
+		// Input Params
+		tableName string
+		// Expected Values
+		player      string
+		contentType string
+		ok          bool
+	}
+
+	t.Run("parse table name", func(t *testing.T) {
+		tests := []testCase{
+			{"asdf_archive_list.json", "asdf", "archive_list", true},
+			{"as_df_archive_data.json", "as_df", "archive_data", true},
+			{"_analysis.json", "", "analysis", true},
+			{"asdf_lichess_archive_data.json", "asdf", "lichess_archive_data", true},
+			{"asdf_archive_data.txt", "", "", false},
+			{"asdf_unknown.json", "", "", false},
+		}
+
+		for _, test := range tests {
+			actualPlayer, actualContentType, actualOk := parseTableName(test.tableName)
+			if actualPlayer != test.player {
+				t.Errorf("expected %v, got %v", test.player, actualPlayer)
+			}
+			if actualContentType != test.contentType {
+				t.Errorf("expected %v, got %v", test.contentType, actualContentType)
+			}
+			if actualOk != test.ok {
+				t.Errorf("expected %v, got %v", test.ok, actualOk)
+			}
+		}
+	})
+}
+
+func TestNormalizeTableNames(t *testing.T) {
+	appConfig.DataDir = t.TempDir()
+	defer func() { appConfig = defaultConfig() }()
+
+	ad := fixtureArchiveData(t, "AsDf", "2025-02", "testdata/asdf_2025_02.json")
+	tables := []struct {
+		contentType string
+		player      string
+		data        map[string]interface{}
+	}{
+		{"archive_data", "AsDf", ad.ArchiveData},
+		{"archive_data", "asdf", map[string]interface{}{"2025-01": []interface{}{}}},
+		{"game_index", "AsDf", map[string]interface{}{"games": map[string]interface{}{}}},
+		{"profile", "asdf", map[string]interface{}{"username": "asdf"}},
+		{"profile", "ASDF", map[string]interface{}{"username": "ASDF", "fetched": "2025-02-01T00:00:00Z"}},
+		{"profile", "AsDf", map[string]interface{}{"username": "AsDf"}},
+	}
+	for _, table := range tables {
+		db, _ := newDatabase(table.contentType, table.player)
+		err := db.writeData(table.data)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	renamed, err := normalizeTableNames()
+	if err != nil {
+		t.Fatalf("expected %v, got %v", nil, err)
+	}
+	if len(renamed) != 4 {
+		t.Errorf("expected %v, got %v", 4, renamed)
+	}
+	tableNames, _ := listTables()
+	for _, tableName := range tableNames {
+		if player, _, _ := parseTableName(tableName); player != strings.ToLower(player) {
+			t.Errorf("expected %v to be renamed", tableName)
+		}
+	}
+
+	type testCase struct {
+		// Input Params
+		contentType string
+		key         string
+		// Expected Values
+		value interface{}
+	}
+
+	tests := []testCase{
+		{"archive_data", "2025-01", []interface{}{}},
+		// the lowercase table's records win
+		{"profile", "username", "asdf"},
+		// records only in a mixed-case table are kept
+		{"profile", "fetched", "2025-02-01T00:00:00Z"},
+	}
+
+	for _, test := range tests {
+		db, _ := newDatabase(test.contentType, "asdf")
+		if !reflect.DeepEqual(db.Data[test.key], test.value) {
+			t.Errorf("%s/%s: expected %v, got %v", test.contentType, test.key, test.value, db.Data[test.key])
+		}
+	}
+
+	t.Run("renamed archives are reindexed", func(t *testing.T) {
+		gi, err := readGameIndex(chessComSource, "asdf")
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := len(ad.ArchiveData["2025-02"].([]interface{}))
+		if len(gi.Games) != expected {
+			t.Errorf("expected %v, got %v", expected, len(gi.Games))
+		}
+	})
+}
+
+func TestMarkEvaluatedAnalysis(t *testing.T) {
+	type testCase struct {
+		// Input Params
+		name   string
+		record map[string]interface{}
+		// Expected Values
+		evaluated bool
+	}
+
+	move := func(keys ...string) map[string]interface{} {
+		m := map[string]interface{}{"pre": "", "actual": map[string]interface{}{}, "best": map[string]interface{}{}}
+		for _, key := range keys {
+			m[key] = map[string]interface{}{}
+		}
+		return m
+	}
+
+	t.Run("mark evaluated analysis", func(t *testing.T) {
+		tests := []testCase{
+			{"stored before moves were evaluated", map[string]interface{}{"moves": map[string]interface{}{"01.": move()}}, false},
+			{"moves evaluated", map[string]interface{}{"moves": map[string]interface{}{"01.": move("eval", "classification")}}, true},
+			{"already marked", map[string]interface{}{"moves": map[string]interface{}{"01.": move()}, "evaluated": true}, true},
+		}
+
+		for _, test := range tests {
+			migrated, err := migrateData("analysis", 2, map[string]interface{}{"uuid": test.record})
+			if err != nil {
+				t.Fatalf("%s: expected %v, got %v", test.name, nil, err)
+			}
+			if actual := migrated["uuid"].(map[string]interface{})["evaluated"]; actual != test.evaluated {
+				t.Errorf("%s: expected %v, got %v", test.name, test.evaluated, actual)
+			}
+		}
+	})
+}
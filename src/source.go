@@ -0,0 +1,130 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// A gameSource is a site games are fetched from.
+// Every source keeps its own archive list, archive data and game index tables,
+// and stores its games in the chess.com game JSON shape, so that everything
+// from archiveData onwards (results, analysis, the game index) is shared.
+type gameSource interface {
+	// the name recorded on every game, e.g. "chess.com"
+	name() string
+	// the content type of the source's table, e.g. "archive_data" -> "lichess_archive_data"
+	contentType(base string) string
+
+	archiveListURL(player string) string
+	// fetches the archive list, a map with an "archives" list
+	fetchArchiveList(url string, haveCopy bool) (data map[string]interface{}, modified bool, err error)
+	// reads the YYYY-MM key of one entry of the "archives" list
+	archiveKey(archive interface{}) (key string, err error)
+
+	archiveDataURL(player string, year int, month time.Month) string
+	// fetches the games of one month, as a []interface{} of game maps
+	fetchArchiveData(url string, haveCopy bool) (games interface{}, modified bool, err error)
+
+	// whether id has the shape of the source's game IDs, the "uuid" of its games
+	validGameID(id string) bool
+}
+
+var chessComSource gameSource = chessComGameSource{}
+
+// the source of a table's content type, and the content type without the source's prefix
+func sourceOfContentType(contentType string) (site gameSource, base string) {
+	if base, ok := strings.CutPrefix(contentType, "lichess_"); ok {
+		return lichessSource, base
+	}
+	return chessComSource, contentType
+}
+
+// the source of a name recorded on games, see gameSource.name
+func sourceByName(name string) (site gameSource, ok bool) {
+	for _, site := range []gameSource{chessComSource, lichessSource} {
+		if site.name() == name {
+			return site, true
+		}
+	}
+	return nil, false
+}
+
+// the source of an API request, chosen by the route it matched
+func requestSource(r *http.Request) gameSource {
+	if strings.Contains(r.Pattern, "/api/lichess/") {
+		return lichessSource
+	}
+	return chessComSource
+}
+
+// the chess.com published-data API, whose game JSON is stored as it is
+type chessComGameSource struct{}
+
+func (chessComGameSource) name() string {
+	return "chess.com"
+}
+
+// chess.com tables predate other sources and carry no prefix
+func (chessComGameSource) contentType(base string) string {
+	return base
+}
+
+func (chessComGameSource) archiveListURL(player string) string {
+	return fmt.Sprintf("%s/player/%s/games/archives", appConfig.ChessCom.BaseURL, player)
+}
+
+func (chessComGameSource) fetchArchiveList(url string, haveCopy bool) (data map[string]interface{}, modified bool, err error) {
+	data, modified, err = chessCom.getJSONIfModified(url, haveCopy)
+	if err != nil {
+		err = fmt.Errorf("chessCom.getJSONIfModified: %w", err)
+		return nil, false, WrapError(err)
+	}
+	return data, modified, nil
+}
+
+// the archive list holds the URL of every month
+func (chessComGameSource) archiveKey(archive interface{}) (key string, err error) {
+	archiveDataUrl, ok := archive.(string)
+	if !ok {
+		err = fmt.Errorf("archive is not a string")
+		return "", WrapError(err)
+	}
+
+	_, yearStr, monthStr, err := extractFromArchiveURL(archiveDataUrl)
+	if err != nil {
+		err = fmt.Errorf("extractFromArchiveURL: %w", err)
+		return "", WrapError(err)
+	}
+
+	return fmt.Sprintf("%s-%s", yearStr, monthStr), nil
+}
+
+func (chessComGameSource) archiveDataURL(player string, year int, month time.Month) string {
+	return fmt.Sprintf("%s/player/%s/games/%d/%02d", appConfig.ChessCom.BaseURL, player, year, int(month))
+}
+
+func (chessComGameSource) fetchArchiveData(url string, haveCopy bool) (games interface{}, modified bool, err error) {
+	apiData, modified, err := chessCom.getJSONIfModified(url, haveCopy)
+	if err != nil {
+		err = fmt.Errorf("chessCom.getJSONIfModified: %w", err)
+		return nil, false, WrapError(err)
+	}
+	if !modified {
+		return nil, false, nil
+	}
+	return apiData["games"], true, nil
+}
+
+func (chessComGameSource) validGameID(id string) bool {
+	return chessComGameIDRegexp.MatchString(id)
+}
+
+// the source recorded on a game, games stored before sources were recorded are from chess.com
+func gameSourceName(gameMap map[string]interface{}) string {
+	if name, ok := gameMap["source"].(string); ok && name != "" {
+		return name
+	}
+	return chessComSource.name()
+}
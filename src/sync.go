@@ -0,0 +1,168 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// A sync refreshes the archive list, then only the archives that can have
+// changed: the current and previous months, any month not stored yet, and
+// any month last fetched before it ended, which may miss its last games. A
+// range refresh checks every month of a range instead, with the same report,
+// for ranges of up to maxRefreshMonths stored or listed months. Every request
+// is conditional, so an unchanged archive costs a 304.
+
+// the most archive months one range refresh checks
+const maxRefreshMonths = 12
+
+type syncReport struct {
+	Player             string   `json:"player"`
+	Source             string   `json:"source"`
+	ArchiveListUpdated bool     `json:"archive_list_updated"`
+	ArchivesChecked    []string `json:"archives_checked"`
+	ArchivesUpdated    []string `json:"archives_updated"`
+	ArchivesUnchanged  []string `json:"archives_unchanged"`
+	GamesAdded         int      `json:"games_added"`
+}
+
+func (sr *syncReport) prettyPrint() (s string, err error) {
+	data, err := json.MarshalIndent(sr, "", "  ")
+	if err != nil {
+		err = fmt.Errorf("json.MarshalIndent: %w", err)
+		return "", WrapError(err)
+	}
+	return string(data), nil
+}
+
+// refreshes a player's archive list and the archives that may hold new games
+func syncPlayer(site gameSource, player string, now time.Time) (report syncReport, err error) {
+	report = syncReport{
+		Player:            player,
+		Source:            site.name(),
+		ArchivesChecked:   []string{},
+		ArchivesUpdated:   []string{},
+		ArchivesUnchanged: []string{},
+	}
+
+	al, err := NewArchiveList(site, player, "api")
+	if err != nil {
+		err = fmt.Errorf("NewArchiveList: %w", err)
+		return syncReport{}, WrapError(err)
+	}
+	report.ArchiveListUpdated = !al.Unchanged
+
+	cache, err := newDatabase("http_cache", "")
+	if err != nil {
+		err = fmt.Errorf("newDatabase: %w", err)
+		return syncReport{}, WrapError(err)
+	}
+
+	thisMonth := time.Date(now.UTC().Year(), now.UTC().Month(), 1, 0, 0, 0, 0, time.UTC)
+	keys := make([]string, 0, len(al.Present))
+	for key, present := range al.Present {
+		if !present || mayHaveChanged(site, player, key, thisMonth, cache) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	err = refreshArchives(site, player, keys, &report)
+	if err != nil {
+		err = fmt.Errorf("refreshArchives: %w", err)
+		return report, WrapError(err)
+	}
+
+	return report, nil
+}
+
+// whether a stored archive month can hold games that are not stored: it is
+// the current or previous month, or it was last fetched before it ended
+func mayHaveChanged(site gameSource, player string, key string, thisMonth time.Time, cache database) bool {
+	year, month, err := archiveToYearMonth(key)
+	if err != nil {
+		return false
+	}
+	start := time.Date(year, month, 1, 0, 0, 0, 0, time.UTC)
+	end := start.AddDate(0, 1, 0)
+	if !start.Before(thisMonth.AddDate(0, -1, 0)) {
+		return true
+	}
+
+	// months stored before fetches were recorded are taken to be complete
+	fetched, ok := lastFetched(cache, site.archiveDataURL(player, year, month))
+	return ok && fetched.Before(end)
+}
+
+// refreshes every archive month of a player within ar that the archive list
+// holds, stored or not, e.g. to pick up games chess.com corrected afterwards
+func refreshArchiveRange(site gameSource, player string, ar archiveRange) (report syncReport, err error) {
+	// a sync picks up new games, a range refresh is for going back over old ones
+	if ar.From == "" && ar.To == "" {
+		err = invalidInputError("a range refresh needs from or to, to refresh new games use sync")
+		return syncReport{}, WrapError(err)
+	}
+
+	report = syncReport{
+		Player:            player,
+		Source:            site.name(),
+		ArchivesChecked:   []string{},
+		ArchivesUpdated:   []string{},
+		ArchivesUnchanged: []string{},
+	}
+
+	al, err := NewArchiveList(site, player, "api")
+	if err != nil {
+		err = fmt.Errorf("NewArchiveList: %w", err)
+		return syncReport{}, WrapError(err)
+	}
+	report.ArchiveListUpdated = !al.Unchanged
+
+	keys := make([]string, 0, len(al.Present))
+	for key := range al.Present {
+		if ar.contains(key) {
+			keys = append(keys, key)
+		}
+	}
+	if len(keys) > maxRefreshMonths {
+		err = invalidInputError("the range holds %d archive months, at most %d can be refreshed at once", len(keys), maxRefreshMonths)
+		return syncReport{}, WrapError(err)
+	}
+	sort.Strings(keys)
+
+	err = refreshArchives(site, player, keys, &report)
+	if err != nil {
+		err = fmt.Errorf("refreshArchives: %w", err)
+		return report, WrapError(err)
+	}
+
+	return report, nil
+}
+
+// refreshes the archive months in keys, recording each in report
+func refreshArchives(site gameSource, player string, keys []string, report *syncReport) (err error) {
+	for _, key := range keys {
+		year, month, err := archiveToYearMonth(key)
+		if err != nil {
+			err = fmt.Errorf("archiveToYearMonth(%s): %w", key, err)
+			return WrapError(err)
+		}
+
+		ad, err := NewArchiveData(site, player, year, month, "api")
+		if err != nil {
+			err = fmt.Errorf("NewArchiveData(%s): %w", key, err)
+			return WrapError(err)
+		}
+
+		report.ArchivesChecked = append(report.ArchivesChecked, key)
+		if ad.Unchanged {
+			report.ArchivesUnchanged = append(report.ArchivesUnchanged, key)
+		} else {
+			report.ArchivesUpdated = append(report.ArchivesUpdated, key)
+			report.GamesAdded += ad.Added
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestSyncPlayer(t *testing.T) {
+	appConfig.DataDir = t.TempDir()
+	defer func() { appConfig = defaultConfig(); chessCom = newChessComClient(appConfig.ChessCom) }()
+
+	fixtures := make(map[string]map[string]interface{})
+	for path, file := range map[string]string{
+		"/player/asdf/games/archives": "testdata/asdf_archives.json",
+		"/player/asdf/games/2025/01":  "testdata/asdf_2025_01.json",
+		"/player/asdf/games/2025/02":  "testdata/asdf_2025_02.json",
+	} {
+		contents, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data := make(map[string]interface{})
+		err = json.Unmarshal(contents, &data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fixtures[path] = data
+	}
+
+	// version 1 of February holds only its first game, version 2 all three
+	version := 1
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, ok := fixtures[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		etag := `"v1"`
+		if r.URL.Path == "/player/asdf/games/2025/02" {
+			etag = fmt.Sprintf(`"v%d"`, version)
+			if version == 1 {
+				games := data["games"].([]interface{})
+				data = map[string]interface{}{"games": games[:1]}
+			}
+		}
+		w.Header().Set("ETag", etag)
+		if r.Header.Get("If-None-Match") == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		json.NewEncoder(w).Encode(data)
+	}))
+	defer server.Close()
+
+	c := defaultConfig()
+	c.DataDir = appConfig.DataDir
+	c.ChessCom.BaseURL = server.URL
+	c.ChessCom.MinInterval = 0
+	setConfig(c)
+
+	now := time.Date(2025, time.February, 15, 12, 0, 0, 0, time.UTC)
+
+	type testCase struct {
+		// Input Params
+		version int
+		// Expected Values
+		report syncReport
+	}
+
+	t.Run("sync player", func(t *testing.T) {
+		tests := []testCase{
+			// first sync fetches everything
+			{1, syncReport{"asdf", "chess.com", true, []string{"2025-01", "2025-02"}, []string{"2025-01", "2025-02"}, []string{}, 1}},
+			// nothing changed, only the current and previous months are checked
+			{1, syncReport{"asdf", "chess.com", false, []string{"2025-01", "2025-02"}, []string{}, []string{"2025-01", "2025-02"}, 0}},
+			// new games this month
+			{2, syncReport{"asdf", "chess.com", false, []string{"2025-01", "2025-02"}, []string{"2025-02"}, []string{"2025-01"}, 2}},
+		}
+
+		for i, test := range tests {
+			version = test.version
+			report, err := syncPlayer(chessComSource, "asdf", now)
+			if err != nil {
+				t.Fatalf("expected %v, got %v", nil, err)
+			}
+			if !reflect.DeepEqual(report, test.report) {
+				t.Errorf("sync %d: expected %+v, got %+v", i, test.report, report)
+			}
+		}
+	})
+
+	t.Run("months fetched before they ended", func(t *testing.T) {
+		// February was last fetched mid-month, January after it ended
+		cache, err := newDatabase("http_cache", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		url := chessComSource.archiveDataURL("asdf", 2025, time.February)
+		err = recordFetch(cache, url, cachedValidators(cache, url))
+		if err != nil {
+			t.Fatal(err)
+		}
+		entry := cache.Data[url].(map[string]interface{})
+		entry["fetched"] = now
+		err = cache.writeData(map[string]interface{}{url: entry})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		later := time.Date(2025, time.April, 10, 0, 0, 0, 0, time.UTC)
+		tests := []testCase{
+			{2, syncReport{"asdf", "chess.com", false, []string{"2025-02"}, []string{}, []string{"2025-02"}, 0}},
+			// the 304 confirmed February complete
+			{2, syncReport{"asdf", "chess.com", false, []string{}, []string{}, []string{}, 0}},
+		}
+		for i, test := range tests {
+			version = test.version
+			report, err := syncPlayer(chessComSource, "asdf", later)
+			if err != nil {
+				t.Fatalf("expected %v, got %v", nil, err)
+			}
+			if !reflect.DeepEqual(report, test.report) {
+				t.Errorf("sync %d: expected %+v, got %+v", i, test.report, report)
+			}
+		}
+	})
+
+	// the stored month keeps every game across the 304s
+	ad, err := NewArchiveData(chessComSource, "asdf", 2025, time.February, "db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ad.Present) != 3 {
+		t.Errorf("expected %v, got %v", 3, len(ad.Present))
+	}
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"maps"
+)
+
+// The UUID index maps every stored game, archived or imported, to where it
+// is stored, so that a game can be found from its UUID alone. It is updated
+// whenever an archive month is refreshed or games are imported, and rebuilt
+// from every stored game by `chess-analyzer reindex` and fsck repair.
+
+type gameLocation struct {
+	Source  string `json:"source"`            // chess.com / lichess / imported
+	Player  string `json:"player,omitempty"`  // whose archive holds the game
+	Archive string `json:"archive,omitempty"` // YYYY-MM
+}
+
+// the owner of the game, as fsck records it, see gameOwner
+func (loc gameLocation) owner() string {
+	if loc.Source == importedSource {
+		return importedSource
+	}
+	return loc.Source + "/" + loc.Player
+}
+
+// the locations of the games of an archive month
+func archiveLocations(ad archiveData) (locations map[string]interface{}) {
+	locations = make(map[string]interface{})
+	games, _ := ad.ArchiveData[ad.Key].([]interface{})
+	for _, game := range games {
+		gameMap, ok := game.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if uuid, ok := gameMap["uuid"].(string); ok && uuid != "" {
+			locations[uuid] = gameLocation{Source: ad.Site.name(), Player: ad.Player, Archive: ad.Key}
+		}
+	}
+	return locations
+}
+
+// replaces the locations of one archive month with the games in ad
+func updateUUIDIndex(ad archiveData) (err error) {
+	locations := archiveLocations(ad)
+	month := gameLocation{Source: ad.Site.name(), Player: ad.Player, Archive: ad.Key}
+	gone := func(uuid string, value interface{}) bool {
+		_, ok := locations[uuid]
+		return !ok && locationOf(value) == month
+	}
+
+	err = writeUUIDIndex(locations, gone)
+	if err != nil {
+		err = fmt.Errorf("writeUUIDIndex: %w", err)
+		return WrapError(err)
+	}
+
+	return nil
+}
+
+// adds imported games to the UUID index
+func indexImportedGames(uuids []string) (err error) {
+	locations := make(map[string]interface{})
+	for _, uuid := range uuids {
+		locations[uuid] = gameLocation{Source: importedSource}
+	}
+
+	err = writeUUIDIndex(locations, nil)
+	if err != nil {
+		err = fmt.Errorf("writeUUIDIndex: %w", err)
+		return WrapError(err)
+	}
+
+	return nil
+}
+
+// rebuilds the UUID index from every stored archive month and imported game
+func rebuildUUIDIndex() (indexed int, err error) {
+	tableNames, err := listTables()
+	if err != nil {
+		err = fmt.Errorf("listTables: %w", err)
+		return 0, WrapError(err)
+	}
+
+	locations := make(map[string]interface{})
+	for _, tableName := range tableNames {
+		player, contentType, _ := parseTableName(tableName)
+		site, base := sourceOfContentType(contentType)
+		if base != "archive_data" && base != "imported" {
+			continue
+		}
+
+		db, err := newDatabase(contentType, player)
+		if err != nil {
+			err = fmt.Errorf("newDatabase(%s): %w", tableName, err)
+			return 0, WrapError(err)
+		}
+		for key, value := range db.Data {
+			if base == "imported" {
+				locations[key] = gameLocation{Source: importedSource}
+				continue
+			}
+			ad := archiveData{Site: site, Player: player, Key: key, ArchiveData: map[string]interface{}{key: value}}
+			for uuid, loc := range archiveLocations(ad) {
+				locations[uuid] = loc
+			}
+		}
+	}
+
+	gone := func(uuid string, value interface{}) bool {
+		_, ok := locations[uuid]
+		return !ok
+	}
+
+	err = writeUUIDIndex(locations, gone)
+	if err != nil {
+		err = fmt.Errorf("writeUUIDIndex: %w", err)
+		return 0, WrapError(err)
+	}
+
+	return len(locations), nil
+}
+
+// writes locations and removes the UUIDs gone reports, if it is not nil, from
+// the index as it is on disk: the table is read, updated and written under one
+// lock, so that a concurrent update is neither lost nor taken for a gone game
+func writeUUIDIndex(locations map[string]interface{}, gone func(uuid string, value interface{}) bool) (err error) {
+	// round trip through JSON so the table holds plain maps, as if read back
+	dataJSON, err := json.Marshal(locations)
+	if err != nil {
+		err = fmt.Errorf("json.Marshal: %w", err)
+		return WrapError(err)
+	}
+	data := make(map[string]interface{})
+	err = json.Unmarshal(dataJSON, &data)
+	if err != nil {
+		err = fmt.Errorf("json.Unmarshal: %w", err)
+		return WrapError(err)
+	}
+
+	db, err := newDatabase("uuid_index", "")
+	if err != nil {
+		err = fmt.Errorf("newDatabase: %w", err)
+		return WrapError(err)
+	}
+
+	tablesMu.Lock()
+	defer tablesMu.Unlock()
+
+	err = db.load() // refresh the data in the database object
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		err = fmt.Errorf("db.load: %w", err)
+		return WrapError(err)
+	}
+
+	changed := len(data) > 0
+	if gone != nil {
+		for uuid, value := range db.Data {
+			if gone(uuid, value) {
+				delete(db.Data, uuid)
+				changed = true
+			}
+		}
+	}
+	if !changed {
+		return nil
+	}
+	maps.Copy(db.Data, data)
+
+	err = db.save()
+	if err != nil {
+		err = fmt.Errorf("db.save: %w", err)
+		return WrapError(err)
+	}
+
+	return nil
+}
+
+// reads a location as stored in the table, the zero location if it is not one
+func locationOf(value interface{}) (loc gameLocation) {
+	m, _ := value.(map[string]interface{})
+	loc.Source, _ = m["source"].(string)
+	loc.Player, _ = m["player"].(string)
+	loc.Archive, _ = m["archive"].(string)
+	return loc
+}
+
+// finds where a game is stored
+func readGameLocation(uuid string) (loc gameLocation, err error) {
+	db, err := newDatabase("uuid_index", "")
+	if err != nil {
+		err = fmt.Errorf("newDatabase: %w", err)
+		return gameLocation{}, WrapError(err)
+	}
+
+	value, ok := db.Data[uuid]
+	if !ok {
+		err = notFoundError("game with UUID %s not found", uuid)
+		return gameLocation{}, WrapError(err)
+	}
+
+	return locationOf(value), nil
+}
+
+// creates the result of a game from its UUID alone
+func resultFromUUID(uuid string) (r result, err error) {
+	loc, err := readGameLocation(uuid)
+	if err != nil {
+		err = fmt.Errorf("readGameLocation: %w", err)
+		return result{}, WrapError(err)
+	}
+
+	if loc.Source == importedSource {
+		gameMap, err := readImportedGame(uuid)
+		if err != nil {
+			err = fmt.Errorf("readImportedGame: %w", err)
+			return result{}, WrapError(err)
+		}
+		r, err = NewResult(gameMap)
+		if err != nil {
+			err = fmt.Errorf("NewResult: %w", err)
+			return result{}, WrapError(err)
+		}
+		return r, nil
+	}
+
+	site, ok := sourceByName(loc.Source)
+	if !ok {
+		err = fmt.Errorf("game with UUID %s has an unknown source %q", uuid, loc.Source)
+		return result{}, WrapError(err)
+	}
+	year, month, err := archiveToYearMonth(loc.Archive)
+	if err != nil {
+		err = fmt.Errorf("archiveToYearMonth: %w", err)
+		return result{}, WrapError(err)
+	}
+
+	ad, err := NewArchiveData(site, loc.Player, year, month, "db")
+	if err != nil {
+		err = fmt.Errorf("NewArchiveData: %w", err)
+		return result{}, WrapError(err)
+	}
+
+	r, err = createResultFromArchiveDataAndUUID(ad, uuid)
+	if err != nil {
+		err = fmt.Errorf("createResultFromArchiveDataAndUUID: %w", err)
+		return result{}, WrapError(err)
+	}
+
+	return r, nil
+}
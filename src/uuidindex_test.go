@@ -0,0 +1,119 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"sync"
+	"testing"
+)
+
+func TestUUIDIndex(t *testing.T) {
+	appConfig.DataDir = t.TempDir()
+	defer func() { appConfig = defaultConfig() }()
+
+	ad := fixtureArchiveData(t, "asdf", "2025-02", "testdata/asdf_2025_02.json")
+	ad.Site = chessComSource
+	archiveDB, _ := newDatabase("archive_data", "asdf")
+	err := archiveDB.writeData(ad.ArchiveData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = updateUUIDIndex(ad)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	uuid := "8e1f2c3a-0002-11ef-8000-000000000002"
+	loc, err := readGameLocation(uuid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := gameLocation{Source: "chess.com", Player: "asdf", Archive: "2025-02"}
+	if loc != expected {
+		t.Errorf("expected %+v, got %+v", expected, loc)
+	}
+
+	r, err := resultFromUUID(uuid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.UUID != uuid {
+		t.Errorf("expected %v, got %v", uuid, r.UUID)
+	}
+
+	t.Run("a refresh drops games no longer in the month", func(t *testing.T) {
+		games := ad.ArchiveData["2025-02"].([]interface{})
+		refreshed := ad
+		refreshed.ArchiveData = map[string]interface{}{"2025-02": games[:1]}
+		err := updateUUIDIndex(refreshed)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := readGameLocation(uuid); err == nil {
+			t.Errorf("expected %v to be gone, got %v", uuid, err)
+		}
+	})
+
+	t.Run("rebuild", func(t *testing.T) {
+		report, err := importPGN(chess960PGN)
+		if err != nil {
+			t.Fatal(err)
+		}
+		imported := report.Games[0].UUID
+
+		indexed, err := rebuildUUIDIndex()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if indexed != 4 {
+			t.Errorf("expected %v, got %v", 4, indexed)
+		}
+		for _, id := range []string{uuid, imported} {
+			if _, err := resultFromUUID(id); err != nil {
+				t.Errorf("%s: expected %v, got %v", id, nil, err)
+			}
+		}
+	})
+
+	_, err = readGameLocation("8e1f2c3a-0009-11ef-8000-000000000009")
+	var ke *kindError
+	if !errors.As(err, &ke) || ke.Kind != kindNotFound {
+		t.Errorf("expected a not found error, got %v", err)
+	}
+}
+
+func TestUpdateUUIDIndexConcurrently(t *testing.T) {
+	appConfig.DataDir = t.TempDir()
+	defer func() { appConfig = defaultConfig() }()
+
+	fixture := fixtureArchiveData(t, "asdf", "2025-02", "testdata/asdf_2025_02.json")
+	game := fixture.ArchiveData["2025-02"].([]interface{})[0].(map[string]interface{})
+
+	// refreshes of the same month, each finding a single game of its own:
+	// whichever is written last, the month holds one game
+	var wg sync.WaitGroup
+	errs := make([]error, 12)
+	for i := range 12 {
+		g := maps.Clone(game)
+		g["uuid"] = fmt.Sprintf("2025-02-%d", i)
+		ad := archiveData{Site: chessComSource, Player: "asdf", Key: "2025-02", ArchiveData: map[string]interface{}{"2025-02": []interface{}{g}}}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = updateUUIDIndex(ad)
+		}()
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	db, _ := newDatabase("uuid_index", "")
+	if len(db.Data) != 1 {
+		t.Errorf("expected %v, got %v", 1, len(db.Data))
+	}
+}
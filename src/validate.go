@@ -0,0 +1,136 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Validation of the path parameters of every route. Players and archives end
+// up in table file names and upstream URLs, so nothing outside these shapes
+// gets past a handler, and newDatabase checks the file name again.
+
+// chess.com usernames are 3 to 25 letters, digits, underscores and hyphens,
+// Lichess usernames 2 to 30, and both sites ignore case
+var playerRegexp = regexp.MustCompile(`^[a-z0-9_-]{2,30}$`)
+
+var archiveRegexp = regexp.MustCompile(`^[0-9]{4}-[0-9]{2}$`)
+
+// no archive is older than chess.com
+const minArchiveYear = 2005
+
+var (
+	// chess.com games are identified by a UUID
+	chessComGameIDRegexp = regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}$`)
+	// Lichess games by the 8 characters of their URL
+	lichessGameIDRegexp = regexp.MustCompile(`^[A-Za-z0-9]{8}$`)
+	// imported games by a hash of their tags and moves, see importedGameID
+	importedGameIDRegexp = regexp.MustCompile(`^[0-9a-f]{32}$`)
+)
+
+// player names that are the first path segment of routes of their own, so
+// that no player can be reached under them, see withGamesRoute
+var reservedPlayers = map[string]bool{"games": true}
+
+// the player part of a table file name, looser than playerRegexp so that
+// tables written before players were normalized can still be read and
+// renamed, see normalizeTableNames
+var tablePlayerRegexp = regexp.MustCompile(`^[A-Za-z0-9_-]{0,64}$`)
+
+// normalizes a player name from a request to the lowercase name tables are stored under
+func parsePlayer(name string) (player string, err error) {
+	player = strings.ToLower(name)
+	if !playerRegexp.MatchString(player) {
+		err = invalidInputError("invalid player %q: expected 2 to 30 letters, digits, underscores or hyphens", name)
+		return "", WrapError(err)
+	}
+	if reservedPlayers[player] {
+		err = invalidInputError("invalid player %q: /api/%s/ is reserved for looking up games by UUID", name, player)
+		return "", WrapError(err)
+	}
+	return player, nil
+}
+
+// parses a YYYY-MM archive key, from minArchiveYear up to the current year
+func archiveToYearMonth(archive string) (year int, month time.Month, err error) {
+	if !archiveRegexp.MatchString(archive) {
+		err = invalidInputError("invalid archive %q: expected YYYY-MM", archive)
+		return 0, 0, WrapError(err)
+	}
+
+	// both are digits only, they cannot fail to parse
+	year, _ = strconv.Atoi(archive[:4])
+	monthInt, _ := strconv.Atoi(archive[5:])
+
+	if maxYear := time.Now().UTC().Year(); year < minArchiveYear || year > maxYear {
+		err = invalidInputError("invalid archive %q: the year must be between %d and %d", archive, minArchiveYear, maxYear)
+		return 0, 0, WrapError(err)
+	}
+	if monthInt < 1 || monthInt > 12 {
+		err = invalidInputError("invalid archive %q: the month must be between 01 and 12", archive)
+		return 0, 0, WrapError(err)
+	}
+
+	return year, time.Month(monthInt), nil
+}
+
+// checks the game ID of a request against the IDs the source gives its games
+func parseGameID(site gameSource, id string) (err error) {
+	if !site.validGameID(id) {
+		err = invalidInputError("invalid %s game ID %q", site.name(), id)
+		return WrapError(err)
+	}
+	return nil
+}
+
+// checks a game ID from any source, as the UUID index holds them all
+func parseIndexedGameID(id string) (err error) {
+	if !chessComSource.validGameID(id) && !lichessSource.validGameID(id) && !importedGameIDRegexp.MatchString(id) {
+		err = invalidInputError("invalid game ID %q", id)
+		return WrapError(err)
+	}
+	return nil
+}
+
+// checks the ID of an imported game
+func parseImportedGameID(id string) (err error) {
+	if !importedGameIDRegexp.MatchString(id) {
+		err = invalidInputError("invalid imported game ID %q", id)
+		return WrapError(err)
+	}
+	return nil
+}
+
+// a range of archive months, both ends included, an empty end is open
+type archiveRange struct {
+	From string // YYYY-MM
+	To   string // YYYY-MM
+}
+
+func (ar archiveRange) contains(key string) bool {
+	// YYYY-MM keys sort in date order
+	return (ar.From == "" || key >= ar.From) && (ar.To == "" || key <= ar.To)
+}
+
+// parses the from and to query parameters of a range of archive months
+func parseArchiveRange(values url.Values) (ar archiveRange, err error) {
+	ar = archiveRange{From: values.Get("from"), To: values.Get("to")}
+	for _, archive := range []string{ar.From, ar.To} {
+		if archive == "" {
+			continue
+		}
+		_, _, err = archiveToYearMonth(archive)
+		if err != nil {
+			err = fmt.Errorf("archiveToYearMonth: %w", err)
+			return archiveRange{}, WrapError(err)
+		}
+	}
+	if ar.From != "" && ar.To != "" && ar.From > ar.To {
+		err = invalidInputError("invalid range: from %s is after to %s", ar.From, ar.To)
+		return archiveRange{}, WrapError(err)
+	}
+	return ar, nil
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"errors"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParsePlayer(t *testing.T) {
+	type testCase struct {
+		// Input Params
+		name string
+		// Expected Values
+		player string
+		ok     bool
+	}
+
+	tests := []testCase{
+		{"asdf", "asdf", true},
+		{"Hikaru", "hikaru", true},
+		{"Magnus_Carlsen-2", "magnus_carlsen-2", true},
+		{"a", "", false},
+		{strings.Repeat("a", 31), "", false},
+		{"../etc", "", false},
+		{"a/b", "", false},
+		{"a b", "", false},
+		{"", "", false},
+		// the /api/games/{uuid} routes
+		{"games", "", false},
+		{"Games", "", false},
+	}
+
+	for _, test := range tests {
+		player, err := parsePlayer(test.name)
+		if (err == nil) != test.ok || player != test.player {
+			t.Errorf("%q: expected %q %v, got %q %v", test.name, test.player, test.ok, player, err)
+		}
+		var ke *kindError
+		if err != nil && (!errors.As(err, &ke) || ke.Kind != kindInvalidInput) {
+			t.Errorf("%q: expected an invalid input error, got %v", test.name, err)
+		}
+	}
+}
+
+func TestArchiveToYearMonth(t *testing.T) {
+	type testCase struct {
+		// Input Params
+		archive string
+		// Expected Values
+		year  int
+		month time.Month
+		err   error
+	}
+
+	t.Run("archive to year and month", func(t *testing.T) {
+		tests := []testCase{
+			{"2020-05", 2020, 5, nil},
+			{"2021-06", 2021, 6, nil},
+		}
+
+		for _, test := range tests {
+			actualYear, actualMonth, actualErr := archiveToYearMonth(test.archive)
+			if actualYear != test.year {
+				t.Errorf("expected %v, got %v", test.year, actualYear)
+			}
+			if actualMonth != test.month {
+				t.Errorf("expected %v, got %v", test.month, actualMonth)
+			}
+			if actualErr != test.err {
+				t.Errorf("expected %v, got %v", test.err, actualErr)
+			}
+		}
+	})
+
+	t.Run("invalid archive", func(t *testing.T) {
+		nextYear := time.Now().UTC().AddDate(1, 0, 0).Format("2006") + "-01"
+		for _, archive := range []string{"2025", "2025-13", "2025-00", "2025-1", "25-01", "1999-12", nextYear, "2025-02-01", "../2025-02", ""} {
+			year, month, err := archiveToYearMonth(archive)
+			if err == nil || year != 0 || month != 0 {
+				t.Errorf("%q: expected an error, got %v %v %v", archive, year, month, err)
+			}
+		}
+	})
+}
+
+func TestParseArchiveRange(t *testing.T) {
+	type testCase struct {
+		// Input Params
+		query string
+		// Expected Values
+		contains []string
+		excludes []string
+		ok       bool
+	}
+
+	tests := []testCase{
+		{"from=2024-01&to=2025-03", []string{"2024-01", "2024-12", "2025-03"}, []string{"2023-12", "2025-04"}, true},
+		{"from=2025-02", []string{"2025-02", "2026-01"}, []string{"2025-01"}, true},
+		{"to=2025-02", []string{"2005-01", "2025-02"}, []string{"2025-03"}, true},
+		{"", []string{"2005-01", "2025-02"}, nil, true},
+		{"from=2025-02&to=2025-02", []string{"2025-02"}, []string{"2025-01", "2025-03"}, true},
+		{"from=2025-03&to=2025-01", nil, nil, false},
+		{"from=2025-13", nil, nil, false},
+		{"to=2025", nil, nil, false},
+	}
+
+	for _, test := range tests {
+		values, _ := url.ParseQuery(test.query)
+		ar, err := parseArchiveRange(values)
+		if (err == nil) != test.ok {
+			t.Errorf("%q: expected ok %v, got %v", test.query, test.ok, err)
+			continue
+		}
+		for _, key := range test.contains {
+			if !ar.contains(key) {
+				t.Errorf("%q: expected %s in the range", test.query, key)
+			}
+		}
+		for _, key := range test.excludes {
+			if ar.contains(key) {
+				t.Errorf("%q: expected %s outside the range", test.query, key)
+			}
+		}
+	}
+}
+
+func TestValidGameID(t *testing.T) {
+	type testCase struct {
+		// Input Params
+		site gameSource
+		id   string
+		// Expected Values
+		ok bool
+	}
+
+	tests := []testCase{
+		{chessComSource, "8e1f2c3a-0001-11ef-8000-000000000001", true},
+		{chessComSource, "8E1F2C3A-0001-11EF-8000-000000000001", false},
+		{chessComSource, "8e1f2c3a000111ef8000000000000001", false},
+		{chessComSource, "LiGame01", false},
+		{lichessSource, "LiGame01", true},
+		{lichessSource, "LiGame01abcd", false},
+		{lichessSource, "../x/abc", false},
+	}
+
+	for _, test := range tests {
+		if ok := test.site.validGameID(test.id); ok != test.ok {
+			t.Errorf("%s %q: expected %v, got %v", test.site.name(), test.id, test.ok, ok)
+		}
+	}
+
+	if err := parseImportedGameID(strings.Repeat("ab", 16)); err != nil {
+		t.Errorf("expected no error, got %v", err)
+	}
+	if err := parseImportedGameID("nothere"); err == nil {
+		t.Errorf("expected an error, got %v", err)
+	}
+}
+
+func TestNewDatabaseTableName(t *testing.T) {
+	appConfig.DataDir = t.TempDir()
+	defer func() { appConfig = defaultConfig() }()
+
+	for _, player := range []string{"../outside", "a/b", `a\b`, "a.b"} {
+		_, err := newDatabase("archive_list", player)
+		if err == nil {
+			t.Errorf("%q: expected an error, got %v", player, err)
+		}
+	}
+
+	// tables stored before players were normalized are still read
+	_, err := newDatabase("archive_list", "Asdf")
+	if err != nil {
+		t.Errorf("expected no error, got %v", err)
+	}
+}
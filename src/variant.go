@@ -0,0 +1,586 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/notnil/chess"
+)
+
+// Games are replayed under the rules of their variant, the chess.com "rules".
+// Standard chess, from the standard or any other starting position, is left to
+// the chess package. The chess package only castles from the standard squares,
+// so in Chess960, where the king and rooks start on any file, the positions it
+// is given never allow castling: the castling rights are kept here, and castling
+// is made here, written the UCI_Chess960 way, as the king taking its own rook.
+
+const (
+	variantChess    = "chess"
+	variantChess960 = "chess960"
+)
+
+// the standard starting position
+const standardFEN = "rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkq - 0 1"
+
+// the variant named by a chess.com rules field, a Lichess variant or a PGN Variant tag
+func parseVariant(name string) (variant string, err error) {
+	switch strings.ToLower(strings.NewReplacer(" ", "", "-", "", "_", "").Replace(name)) {
+	case "", "chess", "standard", "fromposition":
+		return variantChess, nil
+	case "chess960", "fischerandom", "fischerrandom":
+		return variantChess960, nil
+	}
+	err = invalidInputError("unsupported variant %q: only standard chess and Chess960 can be analyzed", name)
+	return "", WrapError(err)
+}
+
+// the variant of a stored game, games stored before variants were recorded are standard chess
+func gameVariant(gameMap map[string]interface{}) (variant string, err error) {
+	rules, _ := gameMap["rules"].(string)
+	return parseVariant(rules)
+}
+
+// a position under the rules of a variant
+type gamePosition struct {
+	variant  string
+	position *chess.Position // in Chess960, without castling rights
+	castling []chess.Square  // in Chess960, the rooks that may still castle
+}
+
+func newGamePosition(variant string, fen string) (gp gamePosition, err error) {
+	if variant != variantChess960 {
+		fenOpt, err := chess.FEN(fen)
+		if err != nil {
+			err = fmt.Errorf("chess.FEN: %w", err)
+			return gamePosition{}, WrapError(err)
+		}
+		return gamePosition{variant: variant, position: chess.NewGame(fenOpt).Position()}, nil
+	}
+
+	fields := strings.Fields(fen)
+	if len(fields) != 6 {
+		err = fmt.Errorf("invalid FEN %q: expected 6 fields", fen)
+		return gamePosition{}, WrapError(err)
+	}
+	rights := fields[2]
+	fields[2] = "-"
+	fenOpt, err := chess.FEN(strings.Join(fields, " "))
+	if err != nil {
+		err = fmt.Errorf("chess.FEN: %w", err)
+		return gamePosition{}, WrapError(err)
+	}
+	gp = gamePosition{variant: variant, position: chess.NewGame(fenOpt).Position()}
+
+	// X-FEN (KQkq, the outermost rook) or Shredder-FEN (the rook's file)
+	board := gp.position.Board()
+	for _, r := range strings.TrimPrefix(rights, "-") {
+		colour := chess.White
+		if unicode.IsLower(r) {
+			colour = chess.Black
+		}
+		rank := backRank(colour)
+		rook := chess.NewPiece(chess.Rook, colour)
+		king, ok := kingSquare(board, colour)
+		if !ok || king.Rank() != rank {
+			err = fmt.Errorf("invalid FEN %q: castling without a king on the back rank", fen)
+			return gamePosition{}, WrapError(err)
+		}
+
+		found := false
+		var sq chess.Square
+		switch upper := unicode.ToUpper(r); {
+		case upper == 'K':
+			for f := chess.FileH; f > king.File() && !found; f-- {
+				sq = chess.NewSquare(f, rank)
+				found = board.Piece(sq) == rook
+			}
+		case upper == 'Q':
+			for f := chess.FileA; f < king.File() && !found; f++ {
+				sq = chess.NewSquare(f, rank)
+				found = board.Piece(sq) == rook
+			}
+		case upper >= 'A' && upper <= 'H':
+			sq = chess.NewSquare(chess.File(upper-'A'), rank)
+			found = board.Piece(sq) == rook
+		}
+		if !found {
+			err = fmt.Errorf("invalid FEN %q: no rook to castle with for %q", fen, r)
+			return gamePosition{}, WrapError(err)
+		}
+		gp.castling = append(gp.castling, sq)
+	}
+
+	return gp, nil
+}
+
+// the position as FEN, for Chess960 with X-FEN castling rights,
+// or Shredder-FEN where the rook is not the outermost
+func (gp gamePosition) fen() string {
+	if gp.variant != variantChess960 {
+		return gp.position.String()
+	}
+
+	rights := ""
+	board := gp.position.Board()
+	for _, colour := range []chess.Color{chess.White, chess.Black} {
+		king, _ := kingSquare(board, colour)
+		for _, side := range []chess.Side{chess.KingSide, chess.QueenSide} {
+			for _, rook := range gp.castling {
+				if rook.Rank() != backRank(colour) || castlingSide(king, rook) != side {
+					continue
+				}
+				letter := strings.ToUpper(rook.File().String())
+				if outermost(board, king, rook) && side == chess.KingSide {
+					letter = "K"
+				} else if outermost(board, king, rook) {
+					letter = "Q"
+				}
+				if colour == chess.Black {
+					letter = strings.ToLower(letter)
+				}
+				rights += letter
+			}
+		}
+	}
+	if rights == "" {
+		rights = "-"
+	}
+
+	fields := strings.Fields(gp.position.String())
+	fields[2] = rights
+	return strings.Join(fields, " ")
+}
+
+// decodes a move in SAN, or in UCI as the engine writes it
+func (gp gamePosition) decodeMove(s string) (m *chess.Move, err error) {
+	rook, castles, err := gp.castlingNotation(s)
+	if err != nil {
+		err = fmt.Errorf("gp.castlingNotation: %w", err)
+		return nil, WrapError(err)
+	}
+	if castles {
+		return gp.castlingMove(rook)
+	}
+
+	if m, err := (chess.AlgebraicNotation{}).Decode(gp.position, s); err == nil {
+		return m, nil
+	}
+	for _, m := range gp.position.ValidMoves() {
+		if (chess.UCINotation{}).Encode(gp.position, m) == s {
+			return m, nil
+		}
+	}
+
+	err = fmt.Errorf("illegal move %q in %s", s, gp.fen())
+	return nil, WrapError(err)
+}
+
+// the move in SAN
+func (gp gamePosition) encodeSAN(m *chess.Move) string {
+	rook, ok := gp.castlingRook(m)
+	if !ok {
+		return chess.AlgebraicNotation{}.Encode(gp.position, m)
+	}
+
+	san := "O-O"
+	if castlingSide(m.S1(), rook) == chess.QueenSide {
+		san = "O-O-O"
+	}
+	post := gp.castle(rook)
+	opponent := post.position.Turn()
+	if king, _ := kingSquare(post.position.Board(), opponent); attacked(post.position.Board().SquareMap(), king, opponent.Other()) {
+		if len(post.position.ValidMoves()) == 0 {
+			return san + "#"
+		}
+		return san + "+"
+	}
+	return san
+}
+
+// the position after a legal move
+func (gp gamePosition) update(m *chess.Move) gamePosition {
+	if rook, ok := gp.castlingRook(m); ok {
+		return gp.castle(rook)
+	}
+
+	next := gamePosition{variant: gp.variant, position: gp.position.Update(m)}
+	king, _ := kingSquare(gp.position.Board(), gp.position.Turn())
+	for _, rook := range gp.castling {
+		// moving the king or the rook, or capturing the rook, loses the right
+		if m.S1() == rook || m.S2() == rook || (m.S1() == king && rook.Rank() == backRank(gp.position.Turn())) {
+			continue
+		}
+		next.castling = append(next.castling, rook)
+	}
+	return next
+}
+
+// reports whether a Chess960 move is castling, the king taking one of its rooks that may castle
+func (gp gamePosition) castlingRook(m *chess.Move) (rook chess.Square, ok bool) {
+	if gp.variant != variantChess960 {
+		return 0, false
+	}
+	turn := gp.position.Turn()
+	if gp.position.Board().Piece(m.S1()) != chess.NewPiece(chess.King, turn) {
+		return 0, false
+	}
+	for _, rook := range gp.castling {
+		if rook == m.S2() && rook.Rank() == backRank(turn) {
+			return rook, true
+		}
+	}
+	return 0, false
+}
+
+// reports whether a Chess960 move, in SAN or UCI, is castling, and with which rook
+func (gp gamePosition) castlingNotation(s string) (rook chess.Square, castles bool, err error) {
+	if gp.variant != variantChess960 {
+		return 0, false, nil
+	}
+
+	var side chess.Side
+	switch strings.ReplaceAll(strings.TrimRight(s, "+#!?"), "0", "O") {
+	case "O-O":
+		side = chess.KingSide
+	case "O-O-O":
+		side = chess.QueenSide
+	default:
+		m, err := chess.UCINotation{}.Decode(nil, s)
+		if err != nil {
+			return 0, false, nil
+		}
+		rook, castles = gp.castlingRook(m)
+		return rook, castles, nil
+	}
+
+	turn := gp.position.Turn()
+	king, _ := kingSquare(gp.position.Board(), turn)
+	for _, rook := range gp.castling {
+		if rook.Rank() == backRank(turn) && castlingSide(king, rook) == side {
+			return rook, true, nil
+		}
+	}
+	err = fmt.Errorf("%s is not allowed in %s", s, gp.fen())
+	return 0, true, WrapError(err)
+}
+
+// the castling move with a rook, if it is legal: every square the king and the
+// rook cross is empty, and the king is not in check on any square it crosses
+func (gp gamePosition) castlingMove(rook chess.Square) (m *chess.Move, err error) {
+	turn := gp.position.Turn()
+	squares := gp.position.Board().SquareMap()
+	king, _ := kingSquare(gp.position.Board(), turn)
+	kingTo, rookTo := castlingSquares(king, rook)
+
+	delete(squares, king)
+	delete(squares, rook)
+	for _, sq := range append(between(king, kingTo), between(rook, rookTo)...) {
+		if _, occupied := squares[sq]; occupied {
+			err = fmt.Errorf("castling with the rook on %s is blocked in %s", rook, gp.fen())
+			return nil, WrapError(err)
+		}
+	}
+	for _, sq := range between(king, kingTo) {
+		if attacked(squares, sq, turn.Other()) {
+			err = fmt.Errorf("castling with the rook on %s crosses check in %s", rook, gp.fen())
+			return nil, WrapError(err)
+		}
+	}
+
+	return chess.UCINotation{}.Decode(nil, king.String()+rook.String())
+}
+
+// the position after castling with a rook
+func (gp gamePosition) castle(rook chess.Square) gamePosition {
+	turn := gp.position.Turn()
+	squares := gp.position.Board().SquareMap()
+	king, _ := kingSquare(gp.position.Board(), turn)
+	kingTo, rookTo := castlingSquares(king, rook)
+
+	delete(squares, king)
+	delete(squares, rook)
+	squares[kingTo] = chess.NewPiece(chess.King, turn)
+	squares[rookTo] = chess.NewPiece(chess.Rook, turn)
+
+	fields := strings.Fields(gp.position.String())
+	fullMoves, _ := strconv.Atoi(fields[5])
+	if turn == chess.Black {
+		fullMoves++
+	}
+	fen := fmt.Sprintf("%s %s - - %d %d", chess.NewBoard(squares), turn.Other(), gp.position.HalfMoveClock()+1, fullMoves)
+	// the board was legal before castling, so is after
+	fenOpt, _ := chess.FEN(fen)
+
+	next := gamePosition{variant: gp.variant, position: chess.NewGame(fenOpt).Position()}
+	for _, r := range gp.castling {
+		if r.Rank() != backRank(turn) {
+			next.castling = append(next.castling, r)
+		}
+	}
+	return next
+}
+
+// the squares the king and the rook castle to, on the g and f files or the c and d files
+func castlingSquares(king chess.Square, rook chess.Square) (kingTo chess.Square, rookTo chess.Square) {
+	if castlingSide(king, rook) == chess.KingSide {
+		return chess.NewSquare(chess.FileG, king.Rank()), chess.NewSquare(chess.FileF, king.Rank())
+	}
+	return chess.NewSquare(chess.FileC, king.Rank()), chess.NewSquare(chess.FileD, king.Rank())
+}
+
+func castlingSide(king chess.Square, rook chess.Square) chess.Side {
+	if rook.File() > king.File() {
+		return chess.KingSide
+	}
+	return chess.QueenSide
+}
+
+// reports whether a rook is the outermost of its colour on its side of the king
+func outermost(board *chess.Board, king chess.Square, rook chess.Square) bool {
+	step := 1
+	if castlingSide(king, rook) == chess.QueenSide {
+		step = -1
+	}
+	for f := int(rook.File()) + step; f >= int(chess.FileA) && f <= int(chess.FileH); f += step {
+		if board.Piece(chess.NewSquare(chess.File(f), rook.Rank())) == board.Piece(rook) {
+			return false
+		}
+	}
+	return true
+}
+
+// the squares on a rank from one square to another, both included
+func between(from chess.Square, to chess.Square) (squares []chess.Square) {
+	lo, hi := min(from.File(), to.File()), max(from.File(), to.File())
+	for f := lo; f <= hi; f++ {
+		squares = append(squares, chess.NewSquare(f, from.Rank()))
+	}
+	return squares
+}
+
+func backRank(colour chess.Color) chess.Rank {
+	if colour == chess.Black {
+		return chess.Rank8
+	}
+	return chess.Rank1
+}
+
+func kingSquare(board *chess.Board, colour chess.Color) (sq chess.Square, ok bool) {
+	for sq, piece := range board.SquareMap() {
+		if piece == chess.NewPiece(chess.King, colour) {
+			return sq, true
+		}
+	}
+	return 0, false
+}
+
+// reports whether a square is attacked by a colour's pieces
+func attacked(squares map[chess.Square]chess.Piece, sq chess.Square, by chess.Color) bool {
+	pieceAt := func(file int, rank int) chess.Piece {
+		if file < 0 || file > 7 || rank < 0 || rank > 7 {
+			return chess.NoPiece
+		}
+		return squares[chess.NewSquare(chess.File(file), chess.Rank(rank))]
+	}
+	is := func(p chess.Piece, types ...chess.PieceType) bool {
+		for _, t := range types {
+			if p == chess.NewPiece(t, by) {
+				return true
+			}
+		}
+		return false
+	}
+	f, r := int(sq.File()), int(sq.Rank())
+
+	pawnRank := r - 1
+	if by == chess.Black {
+		pawnRank = r + 1
+	}
+	if is(pieceAt(f-1, pawnRank), chess.Pawn) || is(pieceAt(f+1, pawnRank), chess.Pawn) {
+		return true
+	}
+	for _, d := range [][2]int{{1, 2}, {2, 1}, {2, -1}, {1, -2}, {-1, -2}, {-2, -1}, {-2, 1}, {-1, 2}} {
+		if is(pieceAt(f+d[0], r+d[1]), chess.Knight) {
+			return true
+		}
+	}
+	for _, d := range [][2]int{{1, 0}, {-1, 0}, {0, 1}, {0, -1}, {1, 1}, {1, -1}, {-1, 1}, {-1, -1}} {
+		sliders := []chess.PieceType{chess.Rook, chess.Queen}
+		if d[0] != 0 && d[1] != 0 {
+			sliders = []chess.PieceType{chess.Bishop, chess.Queen}
+		}
+		if is(pieceAt(f+d[0], r+d[1]), chess.King) {
+			return true
+		}
+		for i := 1; i < 8; i++ {
+			p := pieceAt(f+i*d[0], r+i*d[1])
+			if p == chess.NoPiece {
+				if f+i*d[0] < 0 || f+i*d[0] > 7 || r+i*d[1] < 0 || r+i*d[1] > 7 {
+					break
+				}
+				continue
+			}
+			if is(p, sliders...) {
+				return true
+			}
+			break
+		}
+	}
+	return false
+}
+
+// a move of a game, with the positions either side of it
+type gamePly struct {
+	pre      gamePosition
+	move     *chess.Move
+	post     gamePosition
+	comments []string
+}
+
+// replays a game's PGN under the rules of its variant, from the PGN's FEN tag,
+// or failing that the chess.com initial_setup, or the standard starting position
+func replayPGN(pgn string, variant string, initialSetup string) (start gamePosition, plies []gamePly, err error) {
+	pgn = normalizeCastling(pgn)
+	tags, moves, _ := parsePGN(pgn)
+	fen, hasFEN := initialSetup, false
+	for _, tp := range tags {
+		if strings.EqualFold(tp.Key, "FEN") {
+			fen, hasFEN = tp.Value, true
+		}
+	}
+	if fen == "" {
+		fen = standardFEN
+	}
+
+	if variant != variantChess960 {
+		// the chess package starts from the FEN tag itself
+		if !hasFEN && fen != standardFEN {
+			pgn = fmt.Sprintf("[SetUp \"1\"]\n[FEN \"%s\"]\n", fen) + pgn
+		}
+		chesspgn, err := chess.PGN(strings.NewReader(pgn))
+		if err != nil {
+			err = fmt.Errorf("chess.PGN: %w", err)
+			return gamePosition{}, nil, WrapError(err)
+		}
+		game := chess.NewGame(chesspgn)
+
+		start = gamePosition{variant: variant, position: game.Positions()[0]}
+		for _, mh := range game.MoveHistory() {
+			plies = append(plies, gamePly{
+				pre:      gamePosition{variant: variant, position: mh.PrePosition},
+				move:     mh.Move,
+				post:     gamePosition{variant: variant, position: mh.PostPosition},
+				comments: mh.Comments,
+			})
+		}
+		return start, plies, nil
+	}
+
+	start, err = newGamePosition(variant, fen)
+	if err != nil {
+		err = fmt.Errorf("newGamePosition: %w", err)
+		return gamePosition{}, nil, WrapError(err)
+	}
+	gp := start
+	for i, s := range moves {
+		m, err := gp.decodeMove(s)
+		if err != nil {
+			err = fmt.Errorf("ply %d: %w", i+1, err)
+			return gamePosition{}, nil, WrapError(err)
+		}
+		next := gp.update(m)
+		plies = append(plies, gamePly{pre: gp, move: m, post: next})
+		gp = next
+	}
+
+	return start, plies, nil
+}
+
+// the move history of replayed moves, for the JSON of a result
+func pliesToMoveHistory(plies []gamePly) (moveHistory []*chess.MoveHistory) {
+	for _, ply := range plies {
+		moveHistory = append(moveHistory, &chess.MoveHistory{
+			PrePosition:  ply.pre.position,
+			PostPosition: ply.post.position,
+			Move:         ply.move,
+			Comments:     ply.comments,
+		})
+	}
+	return moveHistory
+}
+
+// a move number has at least one dot, so 0-0 is not taken for one
+var pgnMoveNumberRegexp = regexp.MustCompile(`^\d+\.+`)
+
+// castling written with zeros, e.g. 0-0-0+, which SAN writes with letters
+var pgnZeroCastlingRegexp = regexp.MustCompile(`\b0-0(-0)?\b`)
+
+// rewrites castling written with zeros in the movetext of a PGN as SAN writes
+// it, which neither the chess package nor decodeMove reads otherwise
+func normalizeCastling(pgn string) string {
+	lines := strings.Split(pgn, "\n")
+	for i, line := range lines {
+		if pgnTagRegexp.MatchString(strings.TrimSpace(line)) {
+			continue
+		}
+		lines[i] = pgnZeroCastlingRegexp.ReplaceAllStringFunc(line, func(castling string) string {
+			return strings.ReplaceAll(castling, "0", "O")
+		})
+	}
+	return strings.Join(lines, "\n")
+}
+
+// splits a single game PGN into its tags, its moves as written, and its result,
+// skipping comments, variations and NAGs
+func parsePGN(pgn string) (tags []*chess.TagPair, moves []string, outcome chess.Outcome) {
+	var movetext strings.Builder
+	for _, line := range strings.Split(pgn, "\n") {
+		line = strings.TrimSpace(line)
+		if m := pgnTagRegexp.FindStringSubmatch(line); m != nil {
+			tags = append(tags, &chess.TagPair{Key: m[1], Value: strings.ReplaceAll(m[2], `\"`, `"`)})
+			continue
+		}
+		movetext.WriteString(line + "\n")
+	}
+
+	var text strings.Builder
+	depth, inComment, inLineComment := 0, false, false
+	for _, r := range movetext.String() {
+		switch {
+		case inComment:
+			inComment = r != '}'
+		case inLineComment:
+			inLineComment = r != '\n'
+		case r == '{':
+			inComment = true
+		case r == ';':
+			inLineComment = true
+		case r == '(':
+			depth++
+		case r == ')':
+			depth = max(depth-1, 0)
+		case depth == 0:
+			text.WriteRune(r)
+			continue
+		}
+		text.WriteRune(' ')
+	}
+
+	outcome = chess.NoOutcome
+	for _, token := range strings.Fields(text.String()) {
+		switch token {
+		case "1-0", "0-1", "1/2-1/2", "*":
+			outcome = chess.Outcome(token)
+			continue
+		}
+		token = strings.TrimRight(pgnMoveNumberRegexp.ReplaceAllString(token, ""), "!?")
+		if token != "" && !strings.HasPrefix(token, "$") {
+			moves = append(moves, token)
+		}
+	}
+
+	return tags, moves, outcome
+}
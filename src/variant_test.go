@@ -0,0 +1,244 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// a Chess960 game where both sides castle queenside, the king from b1 to c1 and the rook from a1 to d1
+const chess960PGN = `[Event "Casual game"]
+[White "asdf"]
+[Black "Opponent1"]
+[Result "1-0"]
+[Variant "Chess960"]
+[SetUp "1"]
+[FEN "rknnbbqr/pppppppp/8/8/8/8/PPPPPPPP/RKNNBBQR w KQkq - 0 1"]
+
+1. Nb3 Nb6 2. Nc3 Nc6 3. O-O-O O-O-O 1-0
+`
+
+func TestParseVariant(t *testing.T) {
+	type testCase struct {
+		// Input Params
+		name string
+		// Expected Values
+		variant string
+		ok      bool
+	}
+
+	tests := []testCase{
+		{"", variantChess, true},
+		{"chess", variantChess, true},
+		{"Standard", variantChess, true},
+		{"From Position", variantChess, true},
+		{"fromPosition", variantChess, true},
+		{"chess960", variantChess960, true},
+		{"Chess960", variantChess960, true},
+		{"Fischerandom", variantChess960, true},
+		{"crazyhouse", "", false},
+		{"kingofthehill", "", false},
+	}
+
+	for _, test := range tests {
+		variant, err := parseVariant(test.name)
+		if (err == nil) != test.ok || variant != test.variant {
+			t.Errorf("%q: expected %q %v, got %q %v", test.name, test.variant, test.ok, variant, err)
+		}
+	}
+}
+
+func TestChess960(t *testing.T) {
+	t.Run("castling rights", func(t *testing.T) {
+		tests := map[string]string{
+			// X-FEN and Shredder-FEN of the same position
+			"rknnbbqr/pppppppp/8/8/8/8/PPPPPPPP/RKNNBBQR w KQkq - 0 1": "KQkq",
+			"rknnbbqr/pppppppp/8/8/8/8/PPPPPPPP/RKNNBBQR w HAha - 0 1": "KQkq",
+			// the g1 rook is not the outermost on its side, so it is named by its file
+			"rk1nbbrr/pppppppp/8/8/8/8/PPPPPPPP/RK1NBBRR w Gq - 0 1": "Gq",
+			"rknnbbqr/pppppppp/8/8/8/8/PPPPPPPP/RKNNBBQR w - - 0 1":  "-",
+		}
+		for fen, expected := range tests {
+			gp, err := newGamePosition(variantChess960, fen)
+			if err != nil {
+				t.Fatalf("%s: %v", fen, err)
+			}
+			if rights := strings.Fields(gp.fen())[2]; rights != expected {
+				t.Errorf("%s: expected %v, got %v", fen, expected, rights)
+			}
+		}
+
+		_, err := newGamePosition(variantChess960, "rknnbbqr/pppppppp/8/8/8/8/PPPPPPPP/RKNNBBQR w C - 0 1")
+		if err == nil {
+			t.Errorf("expected an error for castling with a knight, got %v", err)
+		}
+	})
+
+	t.Run("replay", func(t *testing.T) {
+		start, plies, err := replayPGN(chess960PGN, variantChess960, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if start.fen() != "rknnbbqr/pppppppp/8/8/8/8/PPPPPPPP/RKNNBBQR w KQkq - 0 1" {
+			t.Errorf("expected the FEN tag, got %v", start.fen())
+		}
+		if len(plies) != 6 {
+			t.Fatalf("expected %v plies, got %v", 6, len(plies))
+		}
+
+		castle := plies[4]
+		if san := castle.pre.encodeSAN(castle.move); san != "O-O-O" {
+			t.Errorf("expected %v, got %v", "O-O-O", san)
+		}
+		if uci := castle.move.String(); uci != "b1a1" {
+			t.Errorf("expected the king taking its rook %v, got %v", "b1a1", uci)
+		}
+		// castling gives up both rights
+		expected := "2krbbqr/pppppppp/1nn5/8/8/1NN5/PPPPPPPP/2KRBBQR w - - 6 4"
+		if fen := plies[5].post.fen(); fen != expected {
+			t.Errorf("expected %v, got %v", expected, fen)
+		}
+	})
+
+	t.Run("castling written with zeros", func(t *testing.T) {
+		pgn := strings.Replace(chess960PGN, "3. O-O-O O-O-O", "3.0-0-0 0-0-0", 1)
+		_, plies, err := replayPGN(pgn, variantChess960, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(plies) != 6 || plies[4].move.String() != "b1a1" || plies[5].move.String() != "b8a8" {
+			t.Errorf("expected both sides to castle queenside, got %+v", plies)
+		}
+
+		_, plies, err = replayPGN("1. Nf3 Nf6 2. g3 g6 3. Bg2 Bg7 4. 0-0 0-0 *\n", variantChess, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(plies) != 8 || plies[6].move.String() != "e1g1" || plies[7].move.String() != "e8g8" {
+			t.Errorf("expected both sides to castle kingside, got %+v", plies)
+		}
+
+		_, plies, err = replayPGN("1. 0-0-0 0-0 *\n", variantChess, "r3k2r/8/8/8/8/8/8/R3K2R w KQkq - 0 1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(plies) != 2 || plies[0].move.String() != "e1c1" || plies[1].move.String() != "e8g8" {
+			t.Errorf("expected castling from the position, got %+v", plies)
+		}
+	})
+
+	t.Run("illegal castling", func(t *testing.T) {
+		gp, err := newGamePosition(variantChess960, "rknnbbqr/pppppppp/8/8/8/8/PPPPPPPP/RKNNBBQR w KQkq - 0 1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, s := range []string{"O-O-O", "b1a1", "O-O"} {
+			if _, err := gp.decodeMove(s); err == nil {
+				t.Errorf("%s: expected an error, got %v", s, err)
+			}
+		}
+
+		// the king may not castle through check
+		gp, err = newGamePosition(variantChess960, "rk2bbqr/pppppppp/8/8/8/2r5/PP1PPPPP/RK3BQR w KQkq - 0 1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := gp.decodeMove("O-O-O"); err == nil || !strings.Contains(err.Error(), "check") {
+			t.Errorf("expected a check error, got %v", err)
+		}
+	})
+}
+
+func TestCustomStartingPosition(t *testing.T) {
+	// without a FEN tag, the chess.com initial_setup is the starting position
+	start, plies, err := replayPGN("1. e4 Kd7 *\n", variantChess, "4k3/8/8/8/8/8/4P3/4K3 w - - 0 1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if start.fen() != "4k3/8/8/8/8/8/4P3/4K3 w - - 0 1" {
+		t.Errorf("expected the initial setup, got %v", start.fen())
+	}
+	expected := "8/3k4/8/8/4P3/8/8/4K3 w - - 1 2"
+	if len(plies) != 2 || plies[1].post.fen() != expected {
+		t.Errorf("expected %v, got %+v", expected, plies)
+	}
+
+	// a Black to move start is numbered from 01...
+	setConfig(fakeEngineConfig(t, nil))
+	defer setConfig(defaultConfig())
+	_, plies, err = replayPGN("[SetUp \"1\"]\n[FEN \"4k3/8/8/8/8/8/4P3/4K3 b - - 0 1\"]\n\n1... Kd7 2. e4 *\n", variantChess, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, err := moveHistoryToAnalysis(plies)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"01...", "02."} {
+		if _, ok := a.Moves[key]; !ok {
+			t.Errorf("expected %v in %v", key, a.Moves)
+		}
+	}
+}
+
+func TestVariantResults(t *testing.T) {
+	setConfig(fakeEngineConfig(t, nil))
+	defer setConfig(defaultConfig())
+
+	t.Run("chess960", func(t *testing.T) {
+		report, err := importPGN(chess960PGN)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if report.Added != 1 {
+			t.Fatalf("expected %v added, got %+v", 1, report)
+		}
+		gameMap, err := readImportedGame(report.Games[0].UUID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r, err := NewResult(gameMap)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if r.Variant != variantChess960 || r.MoveCount != 3 {
+			t.Errorf("expected %v in %v moves, got %v in %v", variantChess960, 3, r.Variant, r.MoveCount)
+		}
+
+		err = r.analyzeGame()
+		if err != nil {
+			t.Fatal(err)
+		}
+		move := r.Analysis.Moves["03."].(map[string]interface{})
+		if actual := move["actual"].(map[string]string)["move"]; actual != "O-O-O" {
+			t.Errorf("expected %v, got %v", "O-O-O", actual)
+		}
+
+		fsck, err := runFsck(false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(fsck.Problems) != 0 {
+			t.Errorf("expected no problems, got %+v", fsck.Problems)
+		}
+	})
+
+	t.Run("unsupported", func(t *testing.T) {
+		gameMap, err := pgnToGameMap("[Event \"Casual game\"]\n\n1. e4 e5 *\n")
+		if err != nil {
+			t.Fatal(err)
+		}
+		gameMap["rules"] = "crazyhouse"
+		_, err = NewResult(gameMap)
+		if err == nil || !strings.Contains(err.Error(), "unsupported variant") {
+			t.Errorf("expected an unsupported variant error, got %v", err)
+		}
+
+		report, err := importPGN("[Variant \"Atomic\"]\n\n1. e4 e5 *\n")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if report.Invalid != 1 || !strings.Contains(report.Games[0].Error, "unsupported variant") {
+			t.Errorf("expected an unsupported variant error, got %+v", report)
+		}
+	})
+}